@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Operator actions behind POST /admin/kick and POST /admin/abort-game
+const (
+	AdminKick      = "kick"
+	AdminAbortGame = "abort_game"
+)
+
+var (
+	errAdminUnknownUser = errors.New("user not found")
+	errAdminUnknownGame = errors.New("game not found")
+	errAdminGameOver    = errors.New("game is already over")
+)
+
+// adminRequest is an operator action handed to the hub loop, which owns the
+// users and games it changes. The outcome is sent back on reply.
+type adminRequest struct {
+	action string
+	id     string // UserID for AdminKick, GameID for AdminAbortGame
+	reply  chan error
+}
+
+// adminAuthorized reports whether r carries the admin bearer token. An
+// empty token disables the admin endpoints.
+func adminAuthorized(adminToken string, r *http.Request) bool {
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+adminToken)) == 1
+}
+
+// serveAdminAction answers POST /admin/kick with {"userId": ...} and POST
+// /admin/abort-game with {"gameId": ...}, applying the action on the hub
+// loop
+func serveAdminAction(hub *Hub, action string, w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(hub.config.AdminToken, r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		UserID string `json:"userId"`
+		GameID string `json:"gameId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	req := adminRequest{action: action, id: body.UserID, reply: make(chan error, 1)}
+	if action == AdminAbortGame {
+		req.id = body.GameID
+	}
+	if req.id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case hub.adminRequests <- req:
+	case <-hub.stopped:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+	var err error
+	select {
+	case err = <-req.reply:
+	case <-r.Context().Done():
+		return
+	}
+	switch {
+	case errors.Is(err, errAdminUnknownUser), errors.Is(err, errAdminUnknownGame):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errAdminGameOver):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// applyAdminRequest carries out an operator action on the hub goroutine
+func (h *Hub) applyAdminRequest(req adminRequest) error {
+	switch req.action {
+	case AdminKick:
+		user := h.users[req.id]
+		if user == nil || user.Peer != "" || user.IsBot {
+			return errAdminUnknownUser
+		}
+		h.logger.Info("admin_kick", "user_id", user.ID, "user", user.Username)
+		if user.Client != nil {
+			h.sendToUser(user, &Message{Type: "kicked"})
+			h.disconnectClient(user.Client, CloseKicked)
+		}
+		// A kicked player gets no reconnect grace: whether they were absent
+		// already or just marked absent by the disconnect, drop them now
+		if h.users[user.ID] == user {
+			h.removeUser(user)
+		}
+		return nil
+	case AdminAbortGame:
+		game := h.games[req.id]
+		if game == nil {
+			return errAdminUnknownGame
+		}
+		if game.GameOver {
+			return errAdminGameOver
+		}
+		h.logger.Info("admin_abort", "game_id", game.ID)
+		h.abortGame(game, ReasonAdminAbort)
+		return nil
+	}
+	return errors.New("unknown admin action")
+}
+
+// abortGame ends a game in progress without a result: nobody wins, ratings
+// are left alone and a series it belongs to is abandoned
+func (h *Hub) abortGame(game *Game, reason string) {
+	game.GameOver = true
+	game.Status = "GAME_OVER"
+	game.Reason = reason
+	game.EndTime = h.now()
+	game.ResultHash = resultHash(game.ID, game.History)
+	h.saveGame(game)
+	h.sendGameEnd(game)
+	if game.Series != nil && !game.Series.Over {
+		h.endSeries(game, 0)
+	}
+
+	game.Player1.leaveGame(game.ID)
+	game.Player2.leaveGame(game.ID)
+	h.broadcastUserList()
+	h.scheduleGameRemoval(game.ID)
+	h.logger.Info("game_end", "game_id", game.ID, "winner", 0, "reason", reason)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminPost calls an admin endpoint with the given token and JSON body
+func adminPost(h *Hub, action, token, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/admin/"+action, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	serveAdminAction(h, action, rec, req)
+	return rec.Code
+}
+
+// TestAdminAbortGame tests that an operator can end a game with no winner
+// through the hub loop
+func TestAdminAbortGame(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	h := newHub(config)
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	rating := c1.user.rating()
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	if code := adminPost(h, AdminAbortGame, "wrong", `{"gameId":"`+game.ID+`"}`); code != http.StatusForbidden {
+		t.Errorf("wrong token: got %d, want 403", code)
+	}
+	if code := adminPost(h, AdminAbortGame, "secret", `{"gameId":"nope"}`); code != http.StatusNotFound {
+		t.Errorf("unknown game: got %d, want 404", code)
+	}
+	if code := adminPost(h, AdminAbortGame, "secret", `{"gameId":"`+game.ID+`"}`); code != http.StatusNoContent {
+		t.Fatalf("abort: got %d, want 204", code)
+	}
+	end := waitForMessage(t, c1, "game_end")
+	if end.Winner != 0 || end.ReasonCode != ReasonAdminAbort {
+		t.Errorf("game_end: got winner=%d reason=%s", end.Winner, end.ReasonCode)
+	}
+	if code := adminPost(h, AdminAbortGame, "secret", `{"gameId":"`+game.ID+`"}`); code != http.StatusConflict {
+		t.Errorf("aborting twice: got %d, want 409", code)
+	}
+	if c1.user.rating() != rating {
+		t.Errorf("an aborted game must not change ratings: %d -> %d", rating, c1.user.rating())
+	}
+}
+
+// TestAdminAbortSeries tests that aborting a game of a series ends the
+// series without a winner
+func TestAdminAbortSeries(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGameWith(t, h, c1, c2, Message{BestOf: 3})
+
+	if err := h.applyAdminRequest(adminRequest{action: AdminAbortGame, id: game.ID}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := drainMessages(c1)
+	if end := lastMessageOfType(msgs, "series_end"); end == nil || end.Winner != 0 || !game.Series.Over {
+		t.Errorf("players should be told the series is over, got %+v", end)
+	}
+	if lastMessageOfType(msgs, "game_start") != nil {
+		t.Error("no further series game should start")
+	}
+}
+
+// TestAdminKick tests that an operator can disconnect a user
+func TestAdminKick(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	h := newHub(config)
+	c1 := newTestClient(h)
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	if code := adminPost(h, AdminKick, "", `{"userId":"`+c1.user.ID+`"}`); code != http.StatusForbidden {
+		t.Errorf("no token: got %d, want 403", code)
+	}
+	if code := adminPost(h, AdminKick, "secret", `{}`); code != http.StatusBadRequest {
+		t.Errorf("missing id: got %d, want 400", code)
+	}
+	if code := adminPost(h, AdminKick, "secret", `{"userId":"`+c1.user.ID+`"}`); code != http.StatusNoContent {
+		t.Fatalf("kick: got %d, want 204", code)
+	}
+	waitForMessage(t, c1, "kicked")
+	for range c1.send {
+	}
+	if c1.closeReason != CloseKicked {
+		t.Errorf("close reason: got %q, want %q", c1.closeReason, CloseKicked)
+	}
+}
+
+// TestAdminKickPlayer tests that kicking a connected player ends their game
+// at once instead of waiting out the reconnect grace period
+func TestAdminKickPlayer(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	drainMessages(c2)
+
+	if err := h.applyAdminRequest(adminRequest{action: AdminKick, id: c1.user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := h.users[c1.user.ID]; exists {
+		t.Error("a kicked player must not be kept for a reconnect")
+	}
+	if lastMessageOfType(drainMessages(c2), "opponent_disconnected") == nil {
+		t.Error("the opponent should be told at once")
+	}
+	if _, exists := h.games[game.ID]; exists && !game.GameOver {
+		t.Error("the kicked player's game should not stay live")
+	}
+}
+
+// TestAdminKickAbsentUser tests that kicking a player waiting out the
+// reconnect grace period removes them at once
+func TestAdminKickAbsentUser(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	h := newHub(config)
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	startTestGame(t, h, c1, c2)
+	user := c1.user
+	h.handleUnregister(c1)
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	if code := adminPost(h, AdminKick, "secret", `{"userId":"`+user.ID+`"}`); code != http.StatusNoContent {
+		t.Fatalf("kick: got %d, want 204", code)
+	}
+	waitForMessage(t, c2, "opponent_disconnected")
+	if code := adminPost(h, AdminKick, "secret", `{"userId":"`+user.ID+`"}`); code != http.StatusNotFound {
+		t.Errorf("kicked user should be gone: got %d, want 404", code)
+	}
+}
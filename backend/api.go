@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveGames routes the read-only /games/ endpoints backed by the game store:
+//
+//	GET /games/{id}            - the stored game, for replay
+//	GET /games/{id}/analysis   - history annotated with suboptimal bids
+//	GET /games/{id}/replay     - the game as a text transcript
+//	GET /games/{id1}/vs/{id2} - round-by-round comparison of two games
+func serveGames(store GameStore, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/games/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		record, err := store.LoadGame(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+	case len(parts) == 2 && parts[1] == "analysis":
+		record, err := store.LoadGame(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, analyzeGame(record))
+	case len(parts) == 2 && parts[1] == "replay":
+		record, err := store.LoadGame(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, formatTranscript(record))
+	case len(parts) == 3 && parts[1] == "vs":
+		a, err := store.LoadGame(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		b, err := store.LoadGame(parts[2])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, compareGames(a, b))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveChallengeAbuse reports challengers whose challenges are unusually
+// often declined. It requires the admin bearer token; the query parameters
+// minSent (default 5) and minRate (default 0.8) set the thresholds.
+func serveChallengeAbuse(store GameStore, adminToken string, w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(adminToken, r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	minSent := 5
+	minRate := 0.8
+	if v := r.URL.Query().Get("minSent"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid minSent", http.StatusBadRequest)
+			return
+		}
+		minSent = n
+	}
+	if v := r.URL.Query().Get("minRate"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "invalid minRate", http.StatusBadRequest)
+			return
+		}
+		minRate = f
+	}
+
+	entries, err := store.ChallengeLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, findChallengeAbuse(entries, minSent, minRate))
+}
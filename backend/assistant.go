@@ -0,0 +1,87 @@
+package main
+
+// BidRule is a standing bidding rule the server applies for a player who
+// opts into the bid assistant (set_bid_rule). Each round, if the player
+// hasn't bid within Config.AssistantDelay, the rule's bid is submitted for
+// them. A manual bid before then always takes precedence.
+type BidRule struct {
+	// Bid this percentage (0-100) of the current balance, rounded down
+	Percent int `json:"percent"`
+
+	// At match point, bid one more than the opponent's whole balance, which
+	// guarantees the winning round, when affordable. Ignored in games that
+	// hide the opponent's balance.
+	MatchPointMinimum bool `json:"matchPointMinimum,omitempty"`
+}
+
+// validate returns an error code if the rule is out of range
+func (r *BidRule) validate() string {
+	if r.Percent < 0 || r.Percent > 100 {
+		return ErrBidPercentRange
+	}
+	return ""
+}
+
+// bidFor works out the rule's bid for a player of the game
+func (r *BidRule) bidFor(game *Game, playerNum int) int {
+	balance, opponentBalance := game.Player1Balance, game.Player2Balance
+	if playerNum == 2 {
+		balance, opponentBalance = opponentBalance, balance
+	}
+
+	if r.MatchPointMinimum && !game.Settings.HideBalance && atMatchPoint(game, playerNum) && opponentBalance+1 <= balance {
+		return opponentBalance + 1
+	}
+	return percentOfBalance(balance, r.Percent)
+}
+
+// atMatchPoint reports whether winning the next round wins the player the game
+func atMatchPoint(game *Game, playerNum int) bool {
+	if target := game.Settings.RoundWinTarget; target > 0 {
+		wins := game.Player1RoundWins
+		if playerNum == 2 {
+			wins = game.Player2RoundWins
+		}
+		return wins == target-1
+	}
+
+	pos := game.Player1Pos
+	if playerNum == 2 {
+		pos = game.Player2Pos
+	}
+	return pos == game.maxSteps()-1
+}
+
+// handleSetBidRule registers or, with no rule, clears the user's bid assistant rule
+func (h *Hub) handleSetBidRule(user *User, msg *Message) {
+	if msg.BidRule != nil {
+		if code := msg.BidRule.validate(); code != "" {
+			h.sendError(user, code)
+			return
+		}
+	}
+	user.BidRule = msg.BidRule
+}
+
+// runBidAssistants bids for assistant users who haven't bid within
+// AssistantDelay of the round opening
+func (h *Hub) runBidAssistants() {
+	now := h.now()
+	for _, game := range h.games {
+		if game.Status != "WAITING_FOR_BIDS" || now.Sub(game.RoundStart) < h.config.AssistantDelay {
+			continue
+		}
+		if rule := game.Player1.BidRule; rule != nil && game.Player1Bid == nil {
+			h.logger.Info("assistant_bid", "game_id", game.ID, "user", game.Player1.Username)
+			h.handleSubmitBid(game.Player1, &Message{GameID: game.ID, Bid: rule.bidFor(game, 1)})
+		}
+		// The first bid may have resolved the round
+		if game.Status != "WAITING_FOR_BIDS" {
+			continue
+		}
+		if rule := game.Player2.BidRule; rule != nil && game.Player2Bid == nil {
+			h.logger.Info("assistant_bid", "game_id", game.ID, "user", game.Player2.Username)
+			h.handleSubmitBid(game.Player2, &Message{GameID: game.ID, Bid: rule.bidFor(game, 2)})
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBidRulePercent tests the percentage rule
+func TestBidRulePercent(t *testing.T) {
+	rule := &BidRule{Percent: 20}
+	game := &Game{Player1Balance: 20, Player2Balance: 13}
+
+	if got := rule.bidFor(game, 1); got != 4 {
+		t.Errorf("20%% of 20: got %d, want 4", got)
+	}
+	if got := rule.bidFor(game, 2); got != 2 {
+		t.Errorf("20%% of 13: got %d, want 2", got)
+	}
+}
+
+// TestBidRuleMatchPoint tests that match point overrides the percentage when affordable
+func TestBidRuleMatchPoint(t *testing.T) {
+	rule := &BidRule{Percent: 20, MatchPointMinimum: true}
+	game := &Game{Player1Pos: MAX_STEPS - 1, Player1Balance: 10, Player2Balance: 6}
+
+	if got := rule.bidFor(game, 1); got != 7 {
+		t.Errorf("at match point: got %d, want the opponent's balance + 1 = 7", got)
+	}
+
+	// Not affordable: back to the percentage
+	game.Player2Balance = 10
+	if got := rule.bidFor(game, 1); got != 2 {
+		t.Errorf("unaffordable match point: got %d, want 20%% = 2", got)
+	}
+
+	// Not at match point
+	game.Player1Pos = 0
+	game.Player2Balance = 6
+	if got := rule.bidFor(game, 1); got != 2 {
+		t.Errorf("away from match point: got %d, want 2", got)
+	}
+
+	// Round-win mode uses round wins
+	game.Settings.RoundWinTarget = 3
+	game.Player1RoundWins = 2
+	if got := rule.bidFor(game, 1); got != 7 {
+		t.Errorf("round-win match point: got %d, want 7", got)
+	}
+}
+
+// TestBidAssistant tests that the assistant bids only after the delay and yields to a manual bid
+func TestBidAssistant(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	h.handleClientMessage(c1, &Message{Type: "set_bid_rule", BidRule: &BidRule{Percent: 25}})
+	h.handleClientMessage(c2, &Message{Type: "set_bid_rule", BidRule: &BidRule{Percent: 10}})
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+	h.runBidAssistants()
+	if game.Player1Bid != nil {
+		t.Fatal("assistant should wait for the delay")
+	}
+
+	now = now.Add(h.config.AssistantDelay)
+	h.runBidAssistants()
+	if len(game.History) != 1 || game.History[0].P1Bid != 5 || game.History[0].P2Bid != 3 {
+		t.Fatalf("assistant should bid 25%% for player 1 and keep player 2's manual bid, got %+v", game.History)
+	}
+
+	invalid := &BidRule{Percent: 150}
+	h.handleClientMessage(c1, &Message{Type: "set_bid_rule", BidRule: invalid})
+	if c1.user.BidRule == invalid {
+		t.Error("an out-of-range rule should be rejected")
+	}
+}
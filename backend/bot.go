@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/google/uuid"
+)
+
+// The practice bot plays as player 2 for a user who challenges it. Like a
+// ghost it has no connection and is not listed in the lobby; it bids as soon
+// as each round opens, through the same handleSubmitBid path as a human.
+
+// newBot creates the synthetic user for a bot game
+func newBot() *User {
+	return &User{
+		ID:       uuid.New().String(),
+		Username: "Bot",
+		IsBot:    true,
+		GameIDs:  make(map[string]bool),
+		Locale:   defaultLocale,
+	}
+}
+
+// botBid spreads the player's balance evenly over the rounds they still
+// need to win, give or take one, and never bids more than it takes to beat
+// everything the opponent has left
+func botBid(game *Game, playerNum int, rng *rand.Rand) int {
+	balance, opponentBalance := game.Player1Balance, game.Player2Balance
+	pos, wins := game.Player1Pos, game.Player1RoundWins
+	if playerNum == 2 {
+		balance, opponentBalance = game.Player2Balance, game.Player1Balance
+		pos, wins = game.Player2Pos, game.Player2RoundWins
+	}
+
+	remaining := game.maxSteps() - pos
+	if target := game.Settings.RoundWinTarget; target > 0 {
+		remaining = target - wins
+	}
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	bid := (balance+remaining-1)/remaining + rng.Intn(3) - 1
+	bid = min(bid, opponentBalance+1)
+	return max(0, min(bid, balance))
+}
+
+// handleChallengeBot starts a practice game against the bot, with the same
+// options as a challenge
+func (h *Hub) handleChallengeBot(user *User, msg *Message) {
+	if !h.canJoinGame(user) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	settings := GameSettings{
+		RoundWinTarget: msg.RoundWinTarget,
+		BestOf:         msg.BestOf,
+		GameMode:       msg.GameMode,
+		HideBalance:    msg.HideBalance,
+		MaxSteps:       msg.MaxSteps,
+		InitialBudget:  msg.InitialBudget,
+	}
+	if code := settings.validate(h.config.SuddenDeath); code != "" {
+		h.sendError(user, code)
+		return
+	}
+
+	bot := newBot()
+	game := h.createGame(user, bot, settings, nil)
+	h.broadcastUserList()
+
+	h.logger.Info("game_start", "game_id", game.ID, "player1", user.Username, "player2", "bot")
+}
+
+// playBot submits the bot's bid for the open round
+func (h *Hub) playBot(game *Game) {
+	if !game.Player2.IsBot || game.Status != "WAITING_FOR_BIDS" || game.Player2Bid != nil {
+		return
+	}
+	bid := botBid(game, 2, h.botRNG)
+	if rung := game.Player2.BotRung; rung > 0 {
+		bid = ladderBotBid(game, h.config.LadderRungs[rung-1], h.botRNG)
+	}
+	h.handleSubmitBid(game.Player2, &Message{GameID: game.ID, Bid: bid})
+}
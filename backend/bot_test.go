@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestBotBid tests that the bot's bids stay affordable and sensible
+func TestBotBid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		game := &Game{Player1Balance: 20, Player2Balance: 20}
+		bid := botBid(game, 2, rng)
+		// 3 steps to go: about a third of the balance
+		if bid < 6 || bid > 8 {
+			t.Fatalf("opening bid: got %d, want 6-8", bid)
+		}
+
+		game = &Game{Player2Pos: 2, Player1Balance: 3, Player2Balance: 10}
+		if bid := botBid(game, 2, rng); bid > 4 {
+			t.Fatalf("bid should not exceed what beats the opponent's balance, got %d", bid)
+		}
+
+		game = &Game{Player2Balance: 0, Player1Balance: 5}
+		if bid := botBid(game, 2, rng); bid != 0 {
+			t.Fatalf("bid with no balance: got %d, want 0", bid)
+		}
+	}
+}
+
+// TestBotGame tests a full game against the bot
+func TestBotGame(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	h.config.UserListBatchWindow = 0
+	h.botRNG = rand.New(rand.NewSource(1))
+	c := newTestClient(h)
+	drainMessages(c)
+
+	h.handleClientMessage(c, &Message{Type: "challenge_bot"})
+	msgs := drainMessages(c)
+	start := lastMessageOfType(msgs, "game_start")
+	if start == nil {
+		t.Fatal("game_start not sent")
+	}
+	game := h.games[start.GameID]
+	if !game.Player2.IsBot || game.Player2.Client != nil {
+		t.Fatal("player 2 should be a bot without a connection")
+	}
+	if game.Player2Bid == nil {
+		t.Fatal("the bot should bid as soon as the round opens")
+	}
+	if users := lastMessageOfType(msgs, "users_update"); users != nil {
+		for _, info := range users.Users {
+			if info.UserID == game.Player2.ID {
+				t.Error("the bot must not be listed in the lobby")
+			}
+		}
+	}
+
+	for i := 0; i < 50 && !game.GameOver; i++ {
+		h.handleClientMessage(c, &Message{Type: "submit_bid", GameID: game.ID, Bid: min(2, game.Player1Balance)})
+	}
+	if !game.GameOver {
+		t.Fatal("game against the bot should finish")
+	}
+
+	// The bot takes a rematch at once
+	h.handleClientMessage(c, &Message{Type: "rematch", GameID: game.ID})
+	if rematch := lastMessageOfType(drainMessages(c), "game_start"); rematch == nil || rematch.GameID == game.ID {
+		t.Fatalf("rematch against the bot should start right away, got %+v", rematch)
+	}
+
+	// Leaving mid-game against the bot is handled like any other game
+	h.config.ReconnectGrace = 0
+	h.handleUnregister(c)
+	if len(h.games) != 0 {
+		t.Errorf("bot games should be removed with the player, %d left", len(h.games))
+	}
+}
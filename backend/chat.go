@@ -0,0 +1,77 @@
+package main
+
+import (
+	"unicode/utf8"
+)
+
+// handleChat relays a chat message to the other player and spectators of a
+// game, or without a GameID to everyone in the sender's lobby. The sender
+// gets the same chat_message back as confirmation.
+func (h *Hub) handleChat(client *Client, msg *Message) {
+	user := client.user
+	text := sanitizeText(msg.Text)
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > MAX_CHAT_LENGTH {
+		h.sendError(user, ErrChatTooLong)
+		return
+	}
+	if !h.allowChat(client) {
+		h.sendError(user, ErrChatRateLimited)
+		return
+	}
+
+	chatMsg := Message{
+		Type:         "chat_message",
+		GameID:       msg.GameID,
+		FromUserID:   user.ID,
+		FromUsername: user.Username,
+		Text:         text,
+		Timestamp:    h.now().UnixMilli(),
+	}
+
+	if msg.GameID == "" {
+		h.sendToLobby(user.Lobby, &chatMsg)
+		return
+	}
+
+	game, exists := h.games[msg.GameID]
+	if !exists {
+		return
+	}
+	var opponent *User
+	if game.Player1.ID == user.ID {
+		opponent = game.Player2
+	} else if game.Player2.ID == user.ID {
+		opponent = game.Player1
+	} else {
+		h.logger.Warn("chat_not_player", "game_id", game.ID, "user", user.Username)
+		return
+	}
+	h.sendToUser(user, &chatMsg)
+	h.sendToUser(opponent, &chatMsg)
+	h.sendToSpectators(game, &chatMsg)
+}
+
+// allowChat records a chat message from the client and reports whether it is
+// within ChatRateLimit
+func (h *Hub) allowChat(client *Client) bool {
+	if h.config.ChatRateLimit <= 0 {
+		return true
+	}
+	now := h.now()
+	cutoff := now.Add(-h.config.ChatRateWindow)
+	recent := client.chatTimes[:0]
+	for _, at := range client.chatTimes {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	client.chatTimes = recent
+	if len(recent) >= h.config.ChatRateLimit {
+		return false
+	}
+	client.chatTimes = append(client.chatTimes, now)
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGameChat tests that game chat reaches the opponent and spectators only
+func TestGameChat(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	bystander := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	drainMessages(watcher)
+	drainMessages(bystander)
+
+	h.handleClientMessage(c1, &Message{Type: "chat", GameID: game.ID, Text: "  good luck\x07 "})
+	for _, c := range []*Client{c1, c2, watcher} {
+		chat := lastMessageOfType(drainMessages(c), "chat_message")
+		if chat == nil || chat.Text != "good luck" || chat.FromUsername != c1.user.Username || chat.Timestamp == 0 {
+			t.Errorf("expected a stamped chat_message, got %+v", chat)
+		}
+	}
+	if lastMessageOfType(drainMessages(bystander), "chat_message") != nil {
+		t.Error("game chat should not reach the lobby")
+	}
+}
+
+// TestLobbyChat tests lobby chat delivery and its limits
+func TestLobbyChat(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.ChatRateLimit = 2
+	h.config.ChatRateWindow = 10 * time.Second
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	drainMessages(c2)
+
+	h.handleClientMessage(c1, &Message{Type: "chat", Text: "hello"})
+	if chat := lastMessageOfType(drainMessages(c2), "chat_message"); chat == nil || chat.Text != "hello" {
+		t.Fatalf("lobby chat should reach everyone in the lobby, got %+v", chat)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "chat", Text: strings.Repeat("a", MAX_CHAT_LENGTH+1)})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrChatTooLong) {
+		t.Errorf("expected %s error, got %+v", ErrChatTooLong, errMsg)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "chat", Text: "two"})
+	h.handleClientMessage(c1, &Message{Type: "chat", Text: "three"})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrChatRateLimited) {
+		t.Errorf("expected %s error, got %+v", ErrChatRateLimited, errMsg)
+	}
+
+	now = now.Add(11 * time.Second)
+	drainMessages(c2)
+	h.handleClientMessage(c1, &Message{Type: "chat", Text: "four"})
+	if chat := lastMessageOfType(drainMessages(c2), "chat_message"); chat == nil || chat.Text != "four" {
+		t.Errorf("chat should be allowed again after the window, got %+v", chat)
+	}
+}
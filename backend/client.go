@@ -1,19 +1,17 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	writeWait = 10 * time.Second
 )
 
 var upgrader = websocket.Upgrader{
@@ -28,57 +26,176 @@ var upgrader = websocket.Upgrader{
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
-	send chan []byte
+	send chan []byte // Game messages, and the channel closed to drop the connection
 	user *User
+
+	// lobby carries low-priority lobby messages (Config.LobbyMessageTypes).
+	// The write pump drains send first. nil sends everything on send.
+	lobby chan []byte
+
+	// locale requested by the client on connect (?locale=fr)
+	locale string
+
+	// protocol is the protocol version the client speaks (?protocol=N), 0
+	// for clients from before versioning
+	protocol int
+
+	// loginToken names the client's persistent profile (?login=...), empty
+	// for an anonymous session
+	loginToken string
+
+	// account is the subject of the client's verified JWT, empty for an
+	// anonymous session. authError is the close reason for a JWT that was
+	// presented but rejected; the hub refuses such a connection.
+	account   string
+	authError string
+
+	// leaderboard is pushed again after every rated game, nil when the
+	// client hasn't subscribed
+	leaderboard *leaderboardSubscription
+
+	// limiter caps the rate of inbound messages; nil when unlimited. Only
+	// readPump touches it, along with limited, which is set while messages
+	// are being dropped so the hub is told once per burst.
+	limiter *tokenBucket
+	limited bool
+
+	// chatTimes holds when recent chat messages were sent, for rate limiting
+	chatTimes []time.Time
+
+	// closeReason is sent in the close frame when the hub drops the
+	// connection. Set by the hub before it closes send.
+	closeReason string
+
+	// overflowed is set by the hub when a send found the client's buffer
+	// full. Nothing more is sent and the client is unregistered.
+	overflowed bool
+
+	// registered is closed once the hub has admitted, waitlisted or
+	// rejected the connection; rejected is set before that for a refused
+	// one, which is never read from. nil for clients that don't wait.
+	registered chan struct{}
+	rejected   bool
 }
 
+// Close reasons sent when the server drops a connection
+const (
+	CloseIdleTimeout     = "IDLE_TIMEOUT"
+	CloseVersionMismatch = "VERSION_MISMATCH"
+	CloseKicked          = "KICKED"
+	CloseAuthInvalid     = "AUTH_INVALID"
+	CloseAuthExpired     = "AUTH_EXPIRED"
+)
+
+// Connection lifecycle messages sent after the handshake so clients can
+// drive their connection state machine
+const (
+	LifecycleConnected      = "connected"       // New session; follows welcome
+	LifecycleReconnected    = "reconnected"     // Resumed session; follows welcome
+	LifecycleSessionExpired = "session_expired" // The session can't be resumed
+)
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.stopped:
+		}
 		c.conn.Close()
 	}()
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	// Any read, pongs included, proves the peer is alive; a connection
+	// silent for PongTimeout is dead and goes through unregister
+	pongTimeout := c.hub.config.PongTimeout
+	if c.hub.config.MaxMessageSize > 0 {
+		// Larger messages close the connection with CloseMessageTooBig
+		c.conn.SetReadLimit(c.hub.config.MaxMessageSize)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
 		return nil
 	})
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				c.hub.logger.Info("connection_timeout", "remote_addr", c.conn.RemoteAddr().String(), "pong_timeout", pongTimeout)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				c.hub.logger.Warn("message_too_large", "remote_addr", c.conn.RemoteAddr().String(), "limit", c.hub.config.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.hub.logger.Warn("connection_error", "error", err)
 			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+
+		// Drop floods here so they never reach the hub
+		if !c.limiter.allow(time.Now()) {
+			if !c.limited {
+				c.limited = true
+				select {
+				case c.hub.handleMessage <- &MessageWrapper{client: c, rateLimited: true}:
+				case <-c.hub.stopped:
+					return
+				}
+			}
+			continue
+		}
+		c.limited = false
 
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("error unmarshaling message: %v", err)
+		// A message that can't be decoded is answered with an error rather
+		// than handed to the hub
+		msg, err := decodeMessage(message, c.hub.config.PoolMessages)
+		if err != nil {
+			c.hub.logger.Warn("bad_message", "remote_addr", c.conn.RemoteAddr().String(), "error", err)
+			select {
+			case c.hub.handleMessage <- &MessageWrapper{client: c, badMessage: true}:
+			case <-c.hub.stopped:
+				return
+			}
 			continue
 		}
 
-		c.hub.handleMessage <- &MessageWrapper{client: c, message: &msg}
+		select {
+		case c.hub.handleMessage <- &MessageWrapper{client: c, message: msg}:
+		case <-c.hub.stopped:
+			return
+		}
 	}
 }
 
 // writePump pumps messages from the hub to the websocket connection
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.hub.config.pingInterval())
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
+	write := func(message []byte, ok bool) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if !ok {
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, c.closeReason))
+			return false
+		}
+		return c.conn.WriteMessage(websocket.TextMessage, message) == nil
+	}
+
 	for {
+		if message, ok, ready := c.nextQueued(); ready {
+			if !write(message, ok) {
+				return
+			}
+			continue
+		}
+
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			if !write(message, ok) {
 				return
 			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		case message := <-c.lobby:
+			if !write(message, true) {
 				return
 			}
 		case <-ticker.C:
@@ -90,17 +207,58 @@ func (c *Client) writePump() {
 	}
 }
 
+// nextQueued returns an already queued message without blocking, game
+// messages ahead of lobby ones. ok is false once send is closed; ready is
+// false if nothing is queued.
+func (c *Client) nextQueued() (message []byte, ok bool, ready bool) {
+	select {
+	case message, ok := <-c.send:
+		return message, ok, true
+	default:
+	}
+	select {
+	case message := <-c.lobby:
+		return message, true, true
+	default:
+	}
+	return nil, false, false
+}
+
 // serveWs handles websocket requests from clients
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		hub.logger.Warn("upgrade_failed", "error", err)
 		return
 	}
 
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
-	client.hub.register <- client
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), lobby: make(chan []byte, 256), locale: r.URL.Query().Get("locale"), loginToken: r.URL.Query().Get("login")}
+	client.protocol, _ = strconv.Atoi(r.URL.Query().Get("protocol"))
+	client.limiter = newTokenBucket(hub.config.MessageRate, hub.config.MessageBurst, time.Now())
+	if token := bearerToken(r); token != "" && len(hub.config.JWTSecret) > 0 {
+		account, err := verifyJWT(hub.config.JWTSecret, token, time.Now())
+		switch {
+		case errors.Is(err, ErrJWTExpired):
+			client.authError = CloseAuthExpired
+		case err != nil:
+			client.authError = CloseAuthInvalid
+		default:
+			client.account = account
+		}
+	}
+	client.registered = make(chan struct{})
+	select {
+	case client.hub.register <- client:
+	case <-hub.stopped:
+		// Shutting down: refuse new connections
+		conn.Close()
+		return
+	}
 
+	// writePump sends a refused connection its error and closes it
 	go client.writePump()
-	go client.readPump()
+	<-client.registered
+	if !client.rejected {
+		go client.readPump()
+	}
 }
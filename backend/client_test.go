@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves the hub's WebSocket endpoint and returns its URL
+func newTestServer(t *testing.T, h *Hub) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(h, w, r)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// TestHeartbeatDropsDeadConnections tests that a connection that stops answering pings is unregistered
+func TestHeartbeatDropsDeadConnections(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.PingInterval = 50 * time.Millisecond
+	h.config.PongTimeout = 300 * time.Millisecond
+	go h.run(context.Background())
+	defer h.shutdown()
+	url := newTestServer(t, h)
+
+	// A live client: reading lets gorilla answer pings with pongs
+	live, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	go func() {
+		for {
+			if _, _, err := live.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// A half-open client: never reads, so never pongs
+	dead, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+
+	clients := func() int {
+		reply := make(chan Stats, 1)
+		h.statsRequests <- reply
+		return (<-reply).Clients
+	}
+	waitForClients := func(want int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for clients() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("want %d clients, got %d", want, clients())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForClients(2)
+	waitForClients(1) // the unresponsive connection is dropped
+
+	// The live client stays connected well past the pong timeout
+	time.Sleep(2 * h.config.PongTimeout)
+	if n := clients(); n != 1 {
+		t.Errorf("the responsive connection should stay, got %d clients", n)
+	}
+}
+
+// TestTokenBucket tests bursts, refill and the unlimited nil bucket
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatalf("message %d of the burst should be allowed", i+1)
+		}
+	}
+	if b.allow(now) {
+		t.Fatal("message beyond the burst should be rejected")
+	}
+	if !b.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("a token should be back after 1/rate seconds")
+	}
+	if b.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("only one token should have been refilled")
+	}
+	if !b.allow(now.Add(time.Hour)) || !b.allow(now.Add(time.Hour)) || !b.allow(now.Add(time.Hour)) || b.allow(now.Add(time.Hour)) {
+		t.Error("refill should stop at the burst size")
+	}
+
+	unlimited := newTokenBucket(0, 0, now)
+	if unlimited != nil || !unlimited.allow(now) {
+		t.Error("a zero rate should not limit")
+	}
+}
+
+// TestRateLimitBurst tests that a client flooding messages gets only its burst through, and one error
+func TestRateLimitBurst(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.MessageRate = 1
+	h.config.MessageBurst = 5
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	conn, _, err := websocket.DefaultDialer.Dial(newTestServer(t, h), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := conn.WriteJSON(&Message{Type: "leaderboard"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answered, limited := 0, 0
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			break
+		}
+		switch {
+		case msg.Type == "leaderboard":
+			answered++
+		case msg.Type == "error" && msg.ErrorCode == ErrRateLimited:
+			limited++
+		}
+	}
+	// One token may have refilled while the burst was being sent
+	if answered < h.config.MessageBurst || answered > h.config.MessageBurst+1 {
+		t.Errorf("got %d messages through, want the burst of %d", answered, h.config.MessageBurst)
+	}
+	if limited != 1 {
+		t.Errorf("got %d rate-limit errors, want one for the whole flood", limited)
+	}
+}
+
+// TestProtocolVersion tests that the welcome names the protocol version and incompatible clients are turned away
+func TestProtocolVersion(t *testing.T) {
+	h := newHub(DefaultConfig())
+	go h.run(context.Background())
+	defer h.shutdown()
+	url := newTestServer(t, h)
+
+	for _, query := range []string{"", "?protocol=1"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var welcome Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatal(err)
+		}
+		if welcome.Type != "welcome" || welcome.ProtocolVersion != ProtocolVersion {
+			t.Errorf("%q: welcome should carry the protocol version, got %+v", query, welcome)
+		}
+		conn.Close()
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?protocol=99", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var errMsg Message
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatal(err)
+	}
+	if errMsg.Type != "error" || errMsg.ErrorCode != ErrVersionMismatch || errMsg.ProtocolVersion != ProtocolVersion {
+		t.Errorf("incompatible client should get version_mismatch, got %+v", errMsg)
+	}
+	_, _, err = conn.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Text != CloseVersionMismatch {
+		t.Errorf("socket should be closed with the mismatch reason, got %v", err)
+	}
+}
+
+// TestMalformedMessages tests that undecodable messages get a BAD_MESSAGE error
+// and oversized ones close the connection
+func TestMalformedMessages(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.MaxMessageSize = 256
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	conn, _, err := websocket.DefaultDialer.Dial(newTestServer(t, h), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expectBadMessage := func(payload string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("no error for %q: %v", payload, err)
+			}
+			if msg.Type == "error" {
+				if msg.ErrorCode != ErrBadMessage {
+					t.Errorf("%q: got %s error, want %s", payload, msg.ErrorCode, ErrBadMessage)
+				}
+				return
+			}
+		}
+	}
+	expectBadMessage("{not json")
+	expectBadMessage(`{"gameId": "abc"}`)
+	expectBadMessage(`{"type": "submit_bid", "bid": 1e100}`)
+
+	// The connection still works after bad messages
+	if err := conn.WriteJSON(&Message{Type: "leaderboard"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	for msg.Type != "leaderboard" {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("connection should survive bad messages: %v", err)
+		}
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","text":"`+strings.Repeat("a", 300)+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("an oversized message should close the connection as too big, got %v", err)
+	}
+}
+
+// TestWaitlistedBadMessage tests that a malformed message from a waitlisted
+// connection, which has no user yet, is answered without stopping the hub
+func TestWaitlistedBadMessage(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.MaxConnections = 1
+	h.config.WaitlistSize = 1
+	go h.run(context.Background())
+	defer h.shutdown()
+	url := newTestServer(t, h)
+
+	admitted, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admitted.Close()
+	var msg Message
+	if err := admitted.ReadJSON(&msg); err != nil || msg.Type != "welcome" {
+		t.Fatalf("first connection should be welcomed, got %+v, %v", msg, err)
+	}
+
+	waiting, _, err := websocket.DefaultDialer.Dial(url+"?locale=fr", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiting.Close()
+	if err := waiting.ReadJSON(&msg); err != nil || msg.Type != "waitlisted" {
+		t.Fatalf("second connection should be waitlisted, got %+v, %v", msg, err)
+	}
+	if err := waiting.WriteMessage(websocket.TextMessage, []byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	waiting.SetReadDeadline(time.Now().Add(time.Second))
+	if err := waiting.ReadJSON(&msg); err != nil || msg.ErrorCode != ErrBadMessage || msg.Error != translate("fr", ErrBadMessage) {
+		t.Fatalf("waitlisted connection should get a localized BAD_MESSAGE, got %+v, %v", msg, err)
+	}
+
+	if err := admitted.WriteJSON(&Message{Type: "leaderboard"}); err != nil {
+		t.Fatal(err)
+	}
+	admitted.SetReadDeadline(time.Now().Add(time.Second))
+	for msg.Type != "leaderboard" {
+		if err := admitted.ReadJSON(&msg); err != nil {
+			t.Fatalf("hub should keep running: %v", err)
+		}
+	}
+}
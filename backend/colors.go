@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// DefaultPalette is the set of player colors used unless Config.Palette overrides it
+var DefaultPalette = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231",
+	"#911eb4", "#42d4f4", "#f032e6", "#9a6324",
+}
+
+// hashUserID is the stable hash colors and avatar seeds are derived from
+func hashUserID(userID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(userID))
+	return h.Sum64()
+}
+
+// userColor maps a user ID to a palette color, the same on every server
+// sharing the palette
+func userColor(palette []string, userID string) string {
+	if len(palette) == 0 {
+		return ""
+	}
+	return palette[hashUserID(userID)%uint64(len(palette))]
+}
+
+// avatarSeed is an opaque per-user seed clients can feed an avatar generator
+func avatarSeed(userID string) string {
+	return fmt.Sprintf("%016x", hashUserID(userID))
+}
+
+// gameColors returns the colors for the two players of a game. If both map
+// to the same color, player 2 takes the next one in the palette.
+func gameColors(palette []string, player1ID, player2ID string) (string, string) {
+	c1 := userColor(palette, player1ID)
+	c2 := userColor(palette, player2ID)
+	if c1 == c2 && len(palette) > 1 {
+		c2 = palette[(hashUserID(player2ID)+1)%uint64(len(palette))]
+	}
+	return c1, c2
+}
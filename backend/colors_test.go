@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// TestUserColorDeterministic tests that a user ID always maps to the same color
+func TestUserColorDeterministic(t *testing.T) {
+	for _, id := range []string{"a", "user-123", "6f1c2a9e-0000-4000-8000-000000000000"} {
+		first := userColor(DefaultPalette, id)
+		if first == "" {
+			t.Fatalf("no color for %q", id)
+		}
+		for i := 0; i < 5; i++ {
+			if got := userColor(DefaultPalette, id); got != first {
+				t.Errorf("color for %q changed: %s then %s", id, first, got)
+			}
+		}
+		if avatarSeed(id) != avatarSeed(id) {
+			t.Errorf("avatar seed for %q is not stable", id)
+		}
+	}
+}
+
+// TestGameColorsDistinct tests that the two players of a game get different colors
+func TestGameColorsDistinct(t *testing.T) {
+	// With a two-color palette, find two IDs that collide on their base color
+	palette := []string{"#111111", "#222222"}
+	p1, p2 := "p1", ""
+	for i := 0; p2 == ""; i++ {
+		id := string(rune('a' + i))
+		if userColor(palette, id) == userColor(palette, p1) {
+			p2 = id
+		}
+	}
+	c1, c2 := gameColors(palette, p1, p2)
+	if c1 == c2 {
+		t.Errorf("players in a game should get distinct colors, both got %s", c1)
+	}
+
+	h := newHub(DefaultConfig())
+	u1 := newTestClient(h)
+	u2 := newTestClient(h)
+	game := startTestGame(t, h, u1, u2)
+	if game.Player1Color == "" || game.Player1Color == game.Player2Color {
+		t.Errorf("game colors should be set and distinct: %q vs %q", game.Player1Color, game.Player2Color)
+	}
+}
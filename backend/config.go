@@ -0,0 +1,423 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"quevadis/gameengine"
+)
+
+// Config holds the tunable rules and server settings used by the hub.
+// main fills it from flags and environment variables (see loadConfig).
+type Config struct {
+	// Address the HTTP server listens on and the log output format
+	// (LogFormatText or LogFormatJSON)
+	ListenAddr string
+	LogFormat  string
+
+	// Position a game is raced to and the balance each player starts with,
+	// unless a challenge asks for something else
+	MaxSteps      int
+	InitialBudget int
+
+	// How long a challenge waits for an answer before it expires, and the
+	// range a sender may pick their own expiry from
+	ChallengeExpiry    time.Duration
+	MinChallengeExpiry time.Duration
+	MaxChallengeExpiry time.Duration
+
+	// Anti-sandbagging rule: by round MinTotalBidRound each player must have
+	// bid at least MinTotalBid in total, or they forfeit. 0 disables it.
+	MinTotalBid      int
+	MinTotalBidRound int
+
+	// Rapid user-list changes within this window are coalesced into a
+	// single users_update broadcast. 0 broadcasts immediately.
+	UserListBatchWindow time.Duration
+
+	// Most games a user may play at once (0 = no limit)
+	MaxGamesPerUser int
+
+	// When set, the next round only opens after both clients send
+	// reveal_done for the previous result, or after this timeout. 0 opens
+	// the next round immediately.
+	RevealAckTimeout time.Duration
+
+	// While one bid is in, remind its player that the opponent is still
+	// deciding this often (0 = never)
+	ThinkingPulseInterval time.Duration
+
+	// How long a finished game stays available (for rematch and late
+	// messages) before it is removed
+	FinishedGameRetention time.Duration
+
+	// WebSocket heartbeat: the server pings every PingInterval and drops a
+	// connection it hasn't heard from, pongs included, for PongTimeout.
+	// PingInterval must be shorter than PongTimeout (see pingInterval).
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// How long a private room waits for someone to join (0 = until the
+	// creator leaves)
+	RoomTTL time.Duration
+
+	// A rematch starts as soon as both players of a finished game ask for
+	// one within this window
+	RematchWindow time.Duration
+
+	// How long the bid assistant waits for a manual bid before bidding by
+	// the player's rule
+	AssistantDelay time.Duration
+
+	// A player who hasn't bid this long after a round opens bids 0
+	// (0 = wait indefinitely)
+	BidTimeout time.Duration
+
+	// Longest a game may stay paused before it resumes on its own (0 = no limit)
+	MaxPauseDuration time.Duration
+
+	// End the game after this many rounds, so players who keep bidding 0
+	// can't draw forever (0 = no cap). See gameengine.CheckWin.
+	MaxRounds int
+
+	// Draw a random event card each round (see events.go)
+	EventCards bool
+
+	// Tie-breakers tried in order to decide a bankruptcy stalemate in the
+	// position race (see gameengine/tiebreak.go). Nobody winning them is a
+	// draw.
+	TieBreaks []string
+
+	// Decide a series still tied after its last game with one sudden-death
+	// game, raced to SuddenDeathSteps with SuddenDeathBudget each (0 keeps
+	// the series' own setting). Without it a tied series is drawn.
+	SuddenDeath       bool
+	SuddenDeathSteps  int
+	SuddenDeathBudget int
+
+	// Give a player who runs out of balance while ahead on position a
+	// one-time grace balance of 1, so they get a final shot at the finish
+	GraceBid bool
+
+	// Practice ladder: the chance, in percent, that the bot on each rung
+	// bids at random instead of by its strategy, from the bottom rung up
+	LadderRungs []int
+
+	// Elo K-factor: the most a rating can move in one game. With
+	// EloDominance, more decisive wins move ratings further (see dominanceK).
+	EloK         int
+	EloDominance bool
+
+	// Log every inbound and outbound message at debug level, optionally
+	// redacting user-written text
+	WireLog       bool
+	WireLogRedact bool
+
+	// Connection cap (0 = unlimited). Connections beyond it wait in a queue of
+	// up to WaitlistSize and are promoted as slots free up; the rest are refused.
+	MaxConnections int
+	WaitlistSize   int
+
+	// Each connection may send MessageRate messages per second on average,
+	// in bursts of up to MessageBurst; messages beyond that are dropped with
+	// a RATE_LIMITED error (MessageRate 0 = unlimited)
+	MessageRate  float64
+	MessageBurst int
+
+	// Largest inbound WebSocket message in bytes; a bigger one closes the
+	// connection (0 = no limit)
+	MaxMessageSize int64
+
+	// Each connection may send at most ChatRateLimit chat messages per
+	// ChatRateWindow (0 = unlimited)
+	ChatRateLimit  int
+	ChatRateWindow time.Duration
+
+	// Most spectators one game may have (0 = no limit)
+	MaxSpectatorsPerGame int
+
+	// Disconnect lobby users who send nothing for this long (0 = never)
+	LobbyIdleTimeout time.Duration
+
+	// Take a player out of the quick-match queue after waiting this long
+	// without an opponent (0 = wait indefinitely)
+	QuickMatchTimeout time.Duration
+
+	// How long a player who drops mid-game is kept, with their games, for
+	// them to reconnect (0 = end their games at once)
+	ReconnectGrace time.Duration
+
+	// Key for signing session tokens and how long an issued token stays valid
+	TokenSecret []byte
+	TokenTTL    time.Duration
+
+	// HS256 key account JWTs are verified with; empty ignores them (see jwt.go)
+	JWTSecret []byte
+
+	// Federation: this server's ID, the shared secret peers must present,
+	// and websocket URLs of peers to link with. Disabled without an ID.
+	FederationID     string
+	FederationSecret string
+	FederationPeers  []string
+
+	// Colors assigned to players, picked deterministically from the user ID
+	Palette []string
+
+	// Message types queued at low priority, behind game messages to the same client
+	LobbyMessageTypes []string
+
+	// Recycle inbound message structs through a sync.Pool (see pool.go)
+	PoolMessages bool
+
+	// Bearer token for the /admin/ endpoints, which are disabled when empty
+	AdminToken string
+
+	// Random usernames to try before falling back to a suffixed name
+	NameAttempts int
+
+	// SQLite database for finished games, the challenge log and profiles.
+	// Empty keeps them in memory only.
+	DatabasePath string
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:            ":8080",
+		MaxGamesPerUser:       1,
+		LogFormat:             LogFormatText,
+		MaxSteps:              MAX_STEPS,
+		InitialBudget:         INITIAL_BUDGET,
+		ChallengeExpiry:       CHALLENGE_EXPIRY * time.Second,
+		MinChallengeExpiry:    10 * time.Second,
+		MaxChallengeExpiry:    10 * time.Minute,
+		UserListBatchWindow:   50 * time.Millisecond,
+		TieBreaks:             []string{gameengine.TieBreakPosition},
+		LadderRungs:           []int{60, 40, 20, 10, 0},
+		ThinkingPulseInterval: 3 * time.Second,
+		MaxPauseDuration:      5 * time.Minute,
+		BidTimeout:            20 * time.Second,
+		MaxRounds:             30,
+		SuddenDeathSteps:      MIN_GAME_STEPS,
+		SuddenDeathBudget:     INITIAL_BUDGET / 2,
+		RematchWindow:         30 * time.Second,
+		RoomTTL:               10 * time.Minute,
+		LobbyIdleTimeout:      15 * time.Minute,
+		QuickMatchTimeout:     2 * time.Minute,
+		MaxSpectatorsPerGame:  50,
+		PingInterval:          54 * time.Second,
+		PongTimeout:           60 * time.Second,
+		FinishedGameRetention: 10 * time.Second,
+		AssistantDelay:        5 * time.Second,
+		ReconnectGrace:        30 * time.Second,
+		ChatRateLimit:         5,
+		MessageRate:           20,
+		MessageBurst:          40,
+		MaxMessageSize:        4096,
+		ChatRateWindow:        10 * time.Second,
+		Palette:               DefaultPalette,
+		NameAttempts:          10,
+		EloK:                  32,
+		DatabasePath:          "quevadis.db",
+		TokenSecret:           newTokenSecret(),
+		TokenTTL:              10 * time.Minute,
+		LobbyMessageTypes: []string{
+			"users_update", "open_challenge_available", "challenge_taken", "challenge_cancelled",
+			"queue_update",
+		},
+	}
+}
+
+// pingInterval returns PingInterval, or 9/10 of PongTimeout when it is unset
+// or too long to keep the connection alive
+func (c Config) pingInterval() time.Duration {
+	if c.PingInterval <= 0 || c.PingInterval >= c.PongTimeout {
+		return c.PongTimeout * 9 / 10
+	}
+	return c.PingInterval
+}
+
+// loadConfig builds the configuration from the defaults, overridden by
+// environment variables and then by command-line flags. Every flag -some-name
+// can also be set through the variable SOME_NAME; PORT, when set, is a
+// shorthand for ADDR=:PORT.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	fs := flag.NewFlagSet("quevadis", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.ListenAddr, "addr", cfg.ListenAddr, "HTTP listen address")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output: text or json")
+	fs.StringVar(&cfg.DatabasePath, "database", cfg.DatabasePath, "SQLite database path (empty = in memory)")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token for /admin/ (empty = disabled)")
+
+	fs.IntVar(&cfg.MaxSteps, "max-steps", cfg.MaxSteps, "position a game is raced to")
+	fs.IntVar(&cfg.InitialBudget, "initial-budget", cfg.InitialBudget, "balance each player starts with")
+	fs.IntVar(&cfg.MaxRounds, "max-rounds", cfg.MaxRounds, "rounds before a game ends (0 = no cap)")
+	fs.IntVar(&cfg.MinTotalBid, "min-total-bid", cfg.MinTotalBid, "least a player must have bid in total by -min-total-bid-round (0 = off)")
+	fs.IntVar(&cfg.MinTotalBidRound, "min-total-bid-round", cfg.MinTotalBidRound, "round the minimum total bid is checked at")
+	fs.BoolVar(&cfg.EventCards, "event-cards", cfg.EventCards, "draw a random event card each round")
+	fs.BoolVar(&cfg.SuddenDeath, "sudden-death", cfg.SuddenDeath, "decide a tied series with one sudden-death game")
+	fs.IntVar(&cfg.SuddenDeathSteps, "sudden-death-steps", cfg.SuddenDeathSteps, "track length of a sudden-death game (0 = the series')")
+	fs.IntVar(&cfg.SuddenDeathBudget, "sudden-death-budget", cfg.SuddenDeathBudget, "starting balance in a sudden-death game (0 = the series')")
+	fs.BoolVar(&cfg.GraceBid, "grace-bid", cfg.GraceBid, "give a broke player ahead on position one grace bid")
+	fs.IntVar(&cfg.MaxGamesPerUser, "max-games", cfg.MaxGamesPerUser, "most games a user may play at once (0 = no limit)")
+	fs.IntVar(&cfg.MaxSpectatorsPerGame, "max-spectators", cfg.MaxSpectatorsPerGame, "most spectators per game (0 = no limit)")
+	fs.Func("tie-breaks", "comma-separated bankruptcy tie-breakers, tried in order", func(v string) error {
+		cfg.TieBreaks = splitList(v)
+		return nil
+	})
+	fs.IntVar(&cfg.NameAttempts, "name-attempts", cfg.NameAttempts, "random usernames to try before adding a suffix")
+	fs.IntVar(&cfg.EloK, "elo-k", cfg.EloK, "Elo K-factor")
+	fs.BoolVar(&cfg.EloDominance, "elo-dominance", cfg.EloDominance, "scale rating changes by the game's dominance score")
+	fs.Func("ladder-rungs", "comma-separated blunder percentages of the practice ladder bots, bottom rung first", func(v string) error {
+		cfg.LadderRungs = nil
+		for _, item := range splitList(v) {
+			blunder, err := strconv.Atoi(item)
+			if err != nil {
+				return err
+			}
+			cfg.LadderRungs = append(cfg.LadderRungs, blunder)
+		}
+		return nil
+	})
+
+	fs.DurationVar(&cfg.ChallengeExpiry, "challenge-expiry", cfg.ChallengeExpiry, "how long a challenge waits for an answer")
+	fs.DurationVar(&cfg.MinChallengeExpiry, "min-challenge-expiry", cfg.MinChallengeExpiry, "shortest expiry a challenger may ask for")
+	fs.DurationVar(&cfg.MaxChallengeExpiry, "max-challenge-expiry", cfg.MaxChallengeExpiry, "longest expiry a challenger may ask for")
+	fs.DurationVar(&cfg.BidTimeout, "bid-timeout", cfg.BidTimeout, "bid 0 for a player who hasn't bid this long (0 = wait)")
+	fs.DurationVar(&cfg.RevealAckTimeout, "reveal-ack-timeout", cfg.RevealAckTimeout, "wait for reveal_done this long before the next round (0 = don't wait)")
+	fs.DurationVar(&cfg.ReconnectGrace, "reconnect-grace", cfg.ReconnectGrace, "how long a dropped player may reconnect to their games")
+	fs.DurationVar(&cfg.MaxPauseDuration, "max-pause", cfg.MaxPauseDuration, "longest a game may stay paused (0 = no limit)")
+	fs.DurationVar(&cfg.RematchWindow, "rematch-window", cfg.RematchWindow, "how long after a game a rematch may be asked for")
+	fs.DurationVar(&cfg.RoomTTL, "room-ttl", cfg.RoomTTL, "how long a private room waits for a guest (0 = forever)")
+	fs.DurationVar(&cfg.LobbyIdleTimeout, "lobby-idle-timeout", cfg.LobbyIdleTimeout, "disconnect silent lobby users after this long (0 = never)")
+	fs.DurationVar(&cfg.QuickMatchTimeout, "quick-match-timeout", cfg.QuickMatchTimeout, "give up a quick match after waiting this long (0 = wait)")
+	fs.DurationVar(&cfg.AssistantDelay, "assistant-delay", cfg.AssistantDelay, "how long the bid assistant waits for a manual bid")
+	fs.DurationVar(&cfg.ThinkingPulseInterval, "thinking-pulse-interval", cfg.ThinkingPulseInterval, "remind a player the opponent is still deciding this often (0 = never)")
+	fs.DurationVar(&cfg.UserListBatchWindow, "user-list-batch-window", cfg.UserListBatchWindow, "coalesce user-list changes within this window (0 = broadcast at once)")
+	fs.DurationVar(&cfg.FinishedGameRetention, "finished-game-retention", cfg.FinishedGameRetention, "how long a finished game is kept before it is removed")
+	fs.DurationVar(&cfg.PingInterval, "ping-interval", cfg.PingInterval, "WebSocket ping interval")
+	fs.DurationVar(&cfg.PongTimeout, "pong-timeout", cfg.PongTimeout, "drop a connection silent for this long")
+
+	fs.IntVar(&cfg.MaxConnections, "max-connections", cfg.MaxConnections, "connection cap (0 = unlimited)")
+	fs.IntVar(&cfg.WaitlistSize, "waitlist-size", cfg.WaitlistSize, "connections queued beyond the cap")
+	fs.Float64Var(&cfg.MessageRate, "message-rate", cfg.MessageRate, "messages per second per connection (0 = unlimited)")
+	fs.IntVar(&cfg.MessageBurst, "message-burst", cfg.MessageBurst, "message burst per connection")
+	fs.Int64Var(&cfg.MaxMessageSize, "max-message-size", cfg.MaxMessageSize, "largest inbound message in bytes (0 = no limit)")
+	fs.IntVar(&cfg.ChatRateLimit, "chat-rate-limit", cfg.ChatRateLimit, "chat messages per -chat-rate-window per connection (0 = unlimited)")
+	fs.DurationVar(&cfg.ChatRateWindow, "chat-rate-window", cfg.ChatRateWindow, "window the chat rate limit counts over")
+	fs.BoolVar(&cfg.PoolMessages, "pool-messages", cfg.PoolMessages, "recycle inbound message structs through a pool")
+	fs.BoolVar(&cfg.WireLog, "wire-log", cfg.WireLog, "log every protocol message at debug level")
+	fs.BoolVar(&cfg.WireLogRedact, "wire-log-redact", cfg.WireLogRedact, "redact user-written text in the wire log")
+
+	fs.Func("palette", "comma-separated player colors", func(v string) error {
+		cfg.Palette = splitList(v)
+		return nil
+	})
+
+	fs.Func("token-secret", "key for signing session tokens (default random)", func(v string) error {
+		cfg.TokenSecret = []byte(v)
+		return nil
+	})
+	fs.Func("jwt-secret", "HS256 key for account JWTs (empty = accounts off)", func(v string) error {
+		cfg.JWTSecret = []byte(v)
+		return nil
+	})
+	fs.DurationVar(&cfg.TokenTTL, "token-ttl", cfg.TokenTTL, "how long a session token stays valid")
+	fs.StringVar(&cfg.FederationID, "federation-id", cfg.FederationID, "this server's federation ID (empty = no federation)")
+	fs.StringVar(&cfg.FederationSecret, "federation-secret", cfg.FederationSecret, "secret shared with federation peers")
+	fs.Func("federation-peers", "comma-separated WebSocket URLs of federation peers", func(v string) error {
+		cfg.FederationPeers = splitList(v)
+		return nil
+	})
+
+	if port := getenv("PORT"); port != "" {
+		cfg.ListenAddr = ":" + port
+	}
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if v := getenv(name); v != "" && envErr == nil {
+			if err := f.Value.Set(v); err != nil {
+				envErr = fmt.Errorf("%s=%q: %v", name, v, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return cfg, envErr
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// envName maps a flag name to its environment variable
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validate rejects settings the server can't run with
+func (c Config) validate() error {
+	switch {
+	case c.ListenAddr == "":
+		return fmt.Errorf("addr must not be empty")
+	case c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON:
+		return fmt.Errorf("log-format must be %s or %s, got %q", LogFormatText, LogFormatJSON, c.LogFormat)
+	case c.MaxSteps < 1:
+		return fmt.Errorf("max-steps must be at least 1, got %d", c.MaxSteps)
+	case c.InitialBudget < 1:
+		return fmt.Errorf("initial-budget must be at least 1, got %d", c.InitialBudget)
+	case c.MaxRounds < 0 || c.MinTotalBid < 0 || c.MinTotalBidRound < 0 || c.MaxGamesPerUser < 0 || c.MaxSpectatorsPerGame < 0:
+		return fmt.Errorf("max-rounds, min-total-bid, min-total-bid-round, max-games and max-spectators must not be negative")
+	case c.MinTotalBid > 0 && c.MinTotalBidRound == 0:
+		return fmt.Errorf("min-total-bid needs min-total-bid-round to say when it is checked")
+	case c.EloK < 1:
+		return fmt.Errorf("elo-k must be at least 1, got %d", c.EloK)
+	case c.ChallengeExpiry <= 0:
+		return fmt.Errorf("challenge-expiry must be positive, got %s", c.ChallengeExpiry)
+	case c.MinChallengeExpiry <= 0 || c.MaxChallengeExpiry < c.MinChallengeExpiry:
+		return fmt.Errorf("challenge expiry range %s-%s is empty", c.MinChallengeExpiry, c.MaxChallengeExpiry)
+	case c.PongTimeout <= 0:
+		return fmt.Errorf("pong-timeout must be positive, got %s", c.PongTimeout)
+	case c.BidTimeout < 0 || c.RevealAckTimeout < 0 || c.ReconnectGrace < 0 || c.MaxPauseDuration < 0 ||
+		c.RematchWindow < 0 || c.RoomTTL < 0 || c.LobbyIdleTimeout < 0 || c.QuickMatchTimeout < 0 || c.PingInterval < 0 || c.TokenTTL < 0 ||
+		c.AssistantDelay < 0 || c.ThinkingPulseInterval < 0 || c.UserListBatchWindow < 0 || c.FinishedGameRetention < 0 || c.ChatRateWindow < 0:
+		return fmt.Errorf("durations must not be negative")
+	case c.MaxConnections < 0 || c.WaitlistSize < 0 || c.MessageRate < 0 || c.MessageBurst < 0 || c.MaxMessageSize < 0 || c.ChatRateLimit < 0:
+		return fmt.Errorf("connection limits must not be negative")
+	case len(c.TokenSecret) == 0:
+		return fmt.Errorf("token-secret must not be empty")
+	case GameSettings{MaxSteps: c.SuddenDeathSteps, InitialBudget: c.SuddenDeathBudget}.validate(false) != "":
+		return fmt.Errorf("sudden-death-steps must be 0 or %d-%d and sudden-death-budget 0 or %d-%d",
+			MIN_GAME_STEPS, MAX_GAME_STEPS, MIN_INITIAL_BUDGET, MAX_INITIAL_BUDGET)
+	}
+	if len(c.LadderRungs) == 0 {
+		return fmt.Errorf("ladder-rungs must list at least one rung")
+	}
+	for _, blunder := range c.LadderRungs {
+		if blunder < 0 || blunder > 100 {
+			return fmt.Errorf("ladder-rungs must be percentages, got %d", blunder)
+		}
+	}
+	for _, name := range c.TieBreaks {
+		if !gameengine.ValidTieBreak(name) {
+			return fmt.Errorf("unknown tie-breaker %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoadConfig tests that flags override environment variables, which
+// override the defaults
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"PORT":             "9000",
+		"MAX_STEPS":        "5",
+		"INITIAL_BUDGET":   "30",
+		"CHALLENGE_EXPIRY": "2m",
+		"TIE_BREAKS":       "BALANCE, POSITION",
+		"PALETTE":          "#000000,#ffffff",
+		"POOL_MESSAGES":    "true",
+	}
+	cfg, err := loadConfig([]string{"-max-steps", "4", "-log-format", "json"}, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":9000" || cfg.MaxSteps != 4 || cfg.InitialBudget != 30 || cfg.ChallengeExpiry != 2*time.Minute || cfg.LogFormat != LogFormatJSON {
+		t.Errorf("got addr=%q steps=%d budget=%d expiry=%s log=%q", cfg.ListenAddr, cfg.MaxSteps, cfg.InitialBudget, cfg.ChallengeExpiry, cfg.LogFormat)
+	}
+	if len(cfg.TieBreaks) != 2 || cfg.TieBreaks[0] != "BALANCE" || cfg.TieBreaks[1] != "POSITION" {
+		t.Errorf("tie-breaks: got %v", cfg.TieBreaks)
+	}
+	if len(cfg.Palette) != 2 || cfg.Palette[1] != "#ffffff" || !cfg.PoolMessages {
+		t.Errorf("palette and pool-messages: got %v, %v", cfg.Palette, cfg.PoolMessages)
+	}
+	if cfg.BidTimeout != DefaultConfig().BidTimeout {
+		t.Errorf("unset values should keep their default, got bid timeout %s", cfg.BidTimeout)
+	}
+}
+
+// TestLoadConfigInvalid tests that nonsense settings fail at startup
+func TestLoadConfigInvalid(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	for _, args := range [][]string{
+		{"-max-steps", "0"},
+		{"-initial-budget", "-1"},
+		{"-challenge-expiry", "0s"},
+		{"-bid-timeout", "-5s"},
+		{"-log-format", "xml"},
+		{"-tie-breaks", "COIN_FLIP"},
+		{"-max-steps", "three"},
+		{"-min-total-bid", "10"},
+		{"-chat-rate-window", "-1s"},
+	} {
+		if _, err := loadConfig(args, noEnv); err == nil {
+			t.Errorf("%v should be rejected", args)
+		}
+	}
+	if _, err := loadConfig(nil, func(name string) string {
+		if name == "MAX_STEPS" {
+			return "three"
+		}
+		return ""
+	}); err == nil {
+		t.Error("MAX_STEPS=three should be rejected")
+	}
+}
+
+// TestConfiguredGameDefaults tests that new games use the configured race
+// length and budget unless the challenge sets its own
+func TestConfiguredGameDefaults(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSteps = 5
+	config.InitialBudget = 40
+	hub := newHub(config)
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+	if game.maxSteps() != 5 || game.Player1Balance != 40 || game.Player2Balance != 40 {
+		t.Errorf("got steps=%d balances=%d/%d, want 5 and 40", game.maxSteps(), game.Player1Balance, game.Player2Balance)
+	}
+
+	c3 := newTestClient(hub)
+	c4 := newTestClient(hub)
+	custom := startTestGameWith(t, hub, c3, c4, Message{MaxSteps: 3, InitialBudget: 10})
+	if custom.maxSteps() != 3 || custom.Player1Balance != 10 {
+		t.Errorf("challenge settings should win, got steps=%d balance=%d", custom.maxSteps(), custom.Player1Balance)
+	}
+}
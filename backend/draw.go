@@ -0,0 +1,91 @@
+package main
+
+// A player may offer a draw once per round. The opponent accepts, ending
+// the game as a draw, or declines; an unanswered offer lapses when the next
+// round opens. Bots and ghosts always decline.
+
+// handleOfferDraw relays a draw offer to the opponent
+func (h *Hub) handleOfferDraw(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 {
+		return
+	}
+	if game.GameOver {
+		h.sendError(user, ErrGameNotLive)
+		return
+	}
+	if game.DrawOfferedBy != 0 {
+		return
+	}
+	// A declined offer can't be renewed until the next round
+	if game.DrawOfferRounds[playerNum-1] == game.CurrentRound {
+		h.sendError(user, ErrDrawOfferLimit)
+		return
+	}
+
+	opponent := game.Player1
+	if playerNum == 1 {
+		opponent = game.Player2
+	}
+	if opponent.IsBot || game.Ghost != nil {
+		h.sendToUser(user, &Message{Type: "draw_declined", GameID: game.ID})
+		return
+	}
+	game.DrawOfferedBy = playerNum
+	game.DrawOfferRounds[playerNum-1] = game.CurrentRound
+	h.sendToUser(opponent, &Message{Type: "draw_offered", GameID: game.ID, FromUsername: user.Username})
+}
+
+// handleAcceptDraw ends the game as a draw if the opponent offered one
+func (h *Hub) handleAcceptDraw(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.DrawOfferedBy == 0 {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 || playerNum == game.DrawOfferedBy {
+		return
+	}
+	if game.GameOver {
+		h.sendError(user, ErrGameNotLive)
+		return
+	}
+
+	game.DrawOfferedBy = 0
+	h.logger.Info("draw_agreed", "game_id", game.ID)
+	h.finishGame(game, 3, ReasonDrawAgreed)
+}
+
+// handleDeclineDraw turns down the opponent's offer
+func (h *Hub) handleDeclineDraw(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.DrawOfferedBy == 0 {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 || playerNum == game.DrawOfferedBy {
+		return
+	}
+
+	offerer := game.Player1
+	if game.DrawOfferedBy == 2 {
+		offerer = game.Player2
+	}
+	game.DrawOfferedBy = 0
+	h.sendToUser(offerer, &Message{Type: "draw_declined", GameID: game.ID})
+}
+
+// expireDrawOffer withdraws an unanswered offer, telling both players
+func (h *Hub) expireDrawOffer(game *Game) {
+	if game.DrawOfferedBy == 0 {
+		return
+	}
+	game.DrawOfferedBy = 0
+	expiredMsg := Message{Type: "draw_expired", GameID: game.ID}
+	h.sendToUser(game.Player1, &expiredMsg)
+	h.sendToUser(game.Player2, &expiredMsg)
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+// TestDrawAgreed tests that an accepted offer ends the game as a draw
+func TestDrawAgreed(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c1, &Message{Type: "offer_draw", GameID: game.ID})
+	offer := lastMessageOfType(drainMessages(c2), "draw_offered")
+	if offer == nil || offer.FromUsername != c1.user.Username {
+		t.Fatalf("opponent should be told of the offer, got %+v", offer)
+	}
+
+	// The offerer can't accept their own offer
+	h.handleClientMessage(c1, &Message{Type: "accept_draw", GameID: game.ID})
+	if game.GameOver {
+		t.Fatal("an offer must be accepted by the opponent")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "accept_draw", GameID: game.ID})
+	if !game.GameOver || game.Winner != 3 || game.Reason != ReasonDrawAgreed {
+		t.Fatalf("game should end in an agreed draw, got over=%v winner=%d reason=%s", game.GameOver, game.Winner, game.Reason)
+	}
+	if end := lastMessageOfType(drainMessages(c1), "game_end"); end == nil || end.ReasonCode != ReasonDrawAgreed || end.Reason != "Draw agreed" {
+		t.Errorf("game_end should give the reason, got %+v", end)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "offer_draw", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrGameNotLive {
+		t.Errorf("offering on a finished game should be rejected, got %+v", errMsg)
+	}
+}
+
+// TestDrawDeclinedAndExpired tests declining an offer and an offer lapsing at the next round
+func TestDrawDeclinedAndExpired(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c2, &Message{Type: "offer_draw", GameID: game.ID})
+	h.handleClientMessage(c1, &Message{Type: "decline_draw", GameID: game.ID})
+	if lastMessageOfType(drainMessages(c2), "draw_declined") == nil {
+		t.Error("offerer should be told the offer was declined")
+	}
+	if game.GameOver || game.DrawOfferedBy != 0 {
+		t.Fatal("a declined offer should leave the game running with no offer pending")
+	}
+	drainMessages(c1)
+	h.handleClientMessage(c2, &Message{Type: "offer_draw", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.ErrorCode != ErrDrawOfferLimit {
+		t.Errorf("a second offer in the same round should be refused, got %+v", errMsg)
+	}
+	if lastMessageOfType(drainMessages(c1), "draw_offered") != nil {
+		t.Error("a refused offer must not reach the opponent")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "offer_draw", GameID: game.ID})
+	drainMessages(c1)
+	playRound(h, game, c1, c2, 2, 1)
+	if game.DrawOfferedBy != 0 {
+		t.Fatal("an offer should lapse when the next round opens")
+	}
+	if lastMessageOfType(drainMessages(c2), "draw_expired") == nil {
+		t.Error("players should be told the offer lapsed")
+	}
+	h.handleClientMessage(c2, &Message{Type: "offer_draw", GameID: game.ID})
+	if game.DrawOfferedBy != 2 {
+		t.Error("a new round should allow a new offer")
+	}
+	h.handleClientMessage(c1, &Message{Type: "decline_draw", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "accept_draw", GameID: game.ID})
+	if game.GameOver {
+		t.Error("a lapsed offer can't be accepted")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// Emotes are canned reactions a player can send during a game, lighter than
+// chat: the opponent and spectators get emote_received. Each player may send
+// MAX_EMOTES_PER_ROUND per round.
+const (
+	EmoteGG   = "gg"
+	EmoteNice = "nice"
+	EmoteOops = "oops"
+	EmoteWow  = "wow"
+)
+
+const MAX_EMOTES_PER_ROUND = 2
+
+// validEmote reports whether emote is one of the allowed emotes
+func validEmote(emote string) bool {
+	switch emote {
+	case EmoteGG, EmoteNice, EmoteOops, EmoteWow:
+		return true
+	}
+	return false
+}
+
+// handleEmote relays an emote from a player of a live game
+func (h *Hub) handleEmote(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 {
+		return
+	}
+	if game.GameOver {
+		h.sendError(user, ErrGameOver)
+		return
+	}
+	if !validEmote(msg.Emote) {
+		h.sendError(user, ErrInvalidEmote)
+		return
+	}
+
+	if game.EmoteRound != game.CurrentRound {
+		game.EmoteRound = game.CurrentRound
+		game.EmotesSent = [2]int{}
+	}
+	if game.EmotesSent[playerNum-1] >= MAX_EMOTES_PER_ROUND {
+		h.sendError(user, ErrEmoteRateLimited)
+		return
+	}
+	game.EmotesSent[playerNum-1]++
+
+	emoteMsg := Message{
+		Type:         "emote_received",
+		GameID:       game.ID,
+		FromUserID:   user.ID,
+		FromUsername: user.Username,
+		Emote:        msg.Emote,
+	}
+	h.sendToUser(liveOpponent(game, user), &emoteMsg)
+	h.sendToSpectators(game, &emoteMsg)
+}
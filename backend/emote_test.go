@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// TestEmote tests that emotes reach the opponent and spectators, are limited
+// per round and refused once the game is over
+func TestEmote(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	drainMessages(watcher)
+
+	h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: EmoteNice})
+	for _, c := range []*Client{c2, watcher} {
+		if msg := lastMessageOfType(drainMessages(c), "emote_received"); msg == nil || msg.Emote != EmoteNice || msg.FromUserID != c1.user.ID {
+			t.Errorf("emote_received: got %+v", msg)
+		}
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: "rude"})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrInvalidEmote {
+		t.Errorf("unknown emote: got %+v", errMsg)
+	}
+
+	for i := 1; i < MAX_EMOTES_PER_ROUND; i++ {
+		h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: EmoteWow})
+	}
+	drainMessages(c2)
+	h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: EmoteWow})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrEmoteRateLimited {
+		t.Errorf("emote over the round limit: got %+v", errMsg)
+	}
+	if lastMessageOfType(drainMessages(c2), "emote_received") != nil {
+		t.Error("an emote over the limit should not be relayed")
+	}
+
+	// The limit starts over with the next round
+	playRound(h, game, c1, c2, 2, 1)
+	drainMessages(c2)
+	h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: EmoteOops})
+	if lastMessageOfType(drainMessages(c2), "emote_received") == nil {
+		t.Error("emotes should be allowed again in the next round")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	h.handleClientMessage(c1, &Message{Type: "emote", GameID: game.ID, Emote: EmoteGG})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrGameOver {
+		t.Errorf("emote on a finished game: got %+v", errMsg)
+	}
+}
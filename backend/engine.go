@@ -0,0 +1,62 @@
+package main
+
+import "quevadis/gameengine"
+
+// engineRules returns the rules the engine plays the game by
+func (h *Hub) engineRules(game *Game) gameengine.Rules {
+	return gameengine.Rules{
+		Mode:             game.gameMode(),
+		MaxSteps:         game.maxSteps(),
+		RoundWinTarget:   game.Settings.RoundWinTarget,
+		GraceBid:         h.config.GraceBid,
+		TieBreaks:        h.config.TieBreaks,
+		MinTotalBid:      h.config.MinTotalBid,
+		MinTotalBidRound: h.config.MinTotalBidRound,
+		MaxRounds:        game.MaxRounds,
+	}
+}
+
+// engineState copies the part of the game the rules work on
+func (g *Game) engineState() gameengine.State {
+	state := gameengine.State{
+		Round: g.CurrentRound,
+		Event: g.Event,
+		P1: gameengine.Player{
+			Position:  g.Player1Pos,
+			Balance:   g.Player1Balance,
+			Spent:     g.Player1Spent,
+			TotalBid:  g.Player1TotalBid,
+			RoundWins: g.Player1RoundWins,
+			GraceUsed: g.Player1GraceUsed,
+		},
+		P2: gameengine.Player{
+			Position:  g.Player2Pos,
+			Balance:   g.Player2Balance,
+			Spent:     g.Player2Spent,
+			TotalBid:  g.Player2TotalBid,
+			RoundWins: g.Player2RoundWins,
+			GraceUsed: g.Player2GraceUsed,
+		},
+		History: make([]gameengine.Round, len(g.History)),
+	}
+	for i, round := range g.History {
+		state.History[i] = gameengine.Round{Turn: round.Turn, P1Pos: round.P1NewPos, P2Pos: round.P2NewPos}
+	}
+	return state
+}
+
+// setEngineState copies a state the engine updated back into the game
+func (g *Game) setEngineState(state gameengine.State) {
+	g.Player1Pos = state.P1.Position
+	g.Player1Balance = state.P1.Balance
+	g.Player1Spent = state.P1.Spent
+	g.Player1TotalBid = state.P1.TotalBid
+	g.Player1RoundWins = state.P1.RoundWins
+	g.Player1GraceUsed = state.P1.GraceUsed
+	g.Player2Pos = state.P2.Position
+	g.Player2Balance = state.P2.Balance
+	g.Player2Spent = state.P2.Spent
+	g.Player2TotalBid = state.P2.TotalBid
+	g.Player2RoundWins = state.P2.RoundWins
+	g.Player2GraceUsed = state.P2.GraceUsed
+}
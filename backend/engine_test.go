@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"quevadis/gameengine"
+)
+
+// TestBankruptcyTieBreakChain tests that the configured chain decides a bankruptcy stalemate
+func TestBankruptcyTieBreakChain(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.TieBreaks = []string{gameengine.TieBreakPosition, gameengine.TieBreakFirstToReach}
+	game := &Game{
+		Player1Pos: 1,
+		Player2Pos: 1,
+		History: []RoundHistory{
+			{Turn: 1, P1NewPos: 0, P2NewPos: 1, Result: "P2_WINS_ROUND"},
+			{Turn: 2, P1NewPos: 1, P2NewPos: 1, Result: "P1_WINS_ROUND"},
+		},
+	}
+
+	winner, reason := h.checkWinCondition(game)
+	if winner != 2 || reason != ReasonStalemateTieBreak {
+		t.Errorf("player 2 reached step 1 first: got winner %d (%s)", winner, reason)
+	}
+
+	h.config.TieBreaks = DefaultConfig().TieBreaks
+	if winner, _ := h.checkWinCondition(game); winner != 3 {
+		t.Errorf("default chain should draw an equal-position stalemate, got winner %d", winner)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math/rand"
+
+	"quevadis/gameengine"
+)
+
+// Event cards drawn each round in the event cards variant; their effects are
+// applied by the rules engine
+const (
+	EventCalm             = gameengine.EventCalm
+	EventDoubleAdvance    = gameengine.EventDoubleAdvance
+	EventBonusBudget      = gameengine.EventBonusBudget
+	EventWinnerPaysDouble = gameengine.EventWinnerPaysDouble
+)
+
+var eventDeck = []string{EventCalm, EventDoubleAdvance, EventBonusBudget, EventWinnerPaysDouble}
+
+// newEventRNG returns the per-game generator the deck is drawn from, so a
+// game's events are reproducible from its seed
+func newEventRNG(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// drawEvent draws the next card from the deck
+func drawEvent(rng *rand.Rand) string {
+	return eventDeck[rng.Intn(len(eventDeck))]
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+// TestEventDrawDeterministic tests that the same seed draws the same events
+func TestEventDrawDeterministic(t *testing.T) {
+	a := newEventRNG(42)
+	b := newEventRNG(42)
+	for i := 0; i < 20; i++ {
+		if ea, eb := drawEvent(a), drawEvent(b); ea != eb {
+			t.Fatalf("draw %d: got %s and %s for the same seed", i, ea, eb)
+		}
+	}
+}
+
+// TestEventEffects tests that event cards modify round resolution
+func TestEventEffects(t *testing.T) {
+	tests := []struct {
+		name          string
+		event         string
+		p1Bid, p2Bid  int
+		expectedP1Pos int
+		expectedP2Pos int
+		expectedP1Bal int
+		expectedP2Bal int
+	}{
+		{"Double advance", EventDoubleAdvance, 5, 3, 2, 0, 15, 17},
+		{"Bonus budget", EventBonusBudget, 5, 3, 1, 0, 18, 20},
+		{"Winner pays double", EventWinnerPaysDouble, 5, 3, 1, 0, 10, 17},
+		{"Winner pays double on draw", EventWinnerPaysDouble, 4, 4, 0, 0, 16, 16},
+		{"Calm", EventCalm, 2, 7, 0, 1, 18, 13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newHub(DefaultConfig())
+			hub.config.EventCards = true
+			c1 := newTestClient(hub)
+			c2 := newTestClient(hub)
+			game := startTestGame(t, hub, c1, c2)
+			if game.Event == "" {
+				t.Fatal("an event should be drawn for the first round")
+			}
+
+			game.Event = tt.event
+			playRound(hub, game, c1, c2, tt.p1Bid, tt.p2Bid)
+
+			if game.Player1Pos != tt.expectedP1Pos || game.Player2Pos != tt.expectedP2Pos {
+				t.Errorf("positions: got %d/%d, want %d/%d", game.Player1Pos, game.Player2Pos, tt.expectedP1Pos, tt.expectedP2Pos)
+			}
+			if game.Player1Balance != tt.expectedP1Bal || game.Player2Balance != tt.expectedP2Bal {
+				t.Errorf("balances: got %d/%d, want %d/%d", game.Player1Balance, game.Player2Balance, tt.expectedP1Bal, tt.expectedP2Bal)
+			}
+			result := lastMessageOfType(drainMessages(c1), "round_result")
+			if result == nil || result.Event != tt.event {
+				t.Errorf("round_result should carry the event, got %+v", result)
+			}
+		})
+	}
+}
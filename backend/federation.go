@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server-to-server federation lets linked servers share a player pool.
+//
+// Each server announces its local users to its peers, and remote users show
+// up in the local hub as proxy users (User.Peer set to their home server), so
+// they can be listed and challenged like anyone else. A game is hosted by the
+// server where the challenge was created. Hub messages addressed to a proxy
+// user are relayed to the user's home server, and a user's messages about a
+// remotely hosted challenge or game are forwarded to the hosting server, which
+// handles them on behalf of its proxy for that user.
+
+// Federation message types
+const (
+	FedHello    = "hello"    // Identifies the sending server on a new link
+	FedPresence = "presence" // Full list of the sender's local users
+	FedRelay    = "relay"    // Hub message for a user homed on the receiving server
+	FedForward  = "forward"  // Client message from a user homed on the sending server
+)
+
+// FederationMessage is exchanged between linked servers
+type FederationMessage struct {
+	Type    string     `json:"type"`
+	Server  string     `json:"server"`            // ID of the sending server
+	Users   []UserInfo `json:"users,omitempty"`   // presence
+	UserID  string     `json:"userId,omitempty"`  // relay target or forward sender
+	Message *Message   `json:"message,omitempty"` // relay and forward payload
+}
+
+// PeerLink carries federation messages to a peer server
+type PeerLink interface {
+	Send(msg *FederationMessage) error
+}
+
+// federationEvent is a message received on a link, or a closed link when msg is nil
+type federationEvent struct {
+	link PeerLink
+	msg  *FederationMessage
+}
+
+// Federator links a hub with its peer servers. It is only used from the hub's
+// run goroutine.
+type Federator struct {
+	hub          *Hub
+	serverID     string
+	peers        map[string]PeerLink // by server ID
+	remoteRefs   map[string]string   // remotely hosted challenge/game ID -> server ID
+	lastPresence string              // last presence sent, to skip unchanged updates
+}
+
+func newFederator(hub *Hub, serverID string) *Federator {
+	return &Federator{
+		hub:        hub,
+		serverID:   serverID,
+		peers:      make(map[string]PeerLink),
+		remoteRefs: make(map[string]string),
+	}
+}
+
+// connect introduces this server over a new outbound link
+func (f *Federator) connect(link PeerLink) {
+	link.Send(&FederationMessage{Type: FedHello, Server: f.serverID})
+}
+
+// handleEvent processes a message from a peer, or the loss of a link
+func (f *Federator) handleEvent(ev federationEvent) {
+	if ev.msg == nil {
+		f.removeLink(ev.link)
+		return
+	}
+
+	msg := ev.msg
+	if msg.Type != FedHello && f.peers[msg.Server] != ev.link {
+		f.hub.logger.Warn("federation_unknown_server", "type", msg.Type, "server", msg.Server)
+		return
+	}
+
+	switch msg.Type {
+	case FedHello:
+		if msg.Server == "" || msg.Server == f.serverID || f.peers[msg.Server] != nil {
+			return
+		}
+		f.peers[msg.Server] = ev.link
+		f.hub.logger.Info("federation_linked", "server", msg.Server)
+		f.connect(ev.link)
+		ev.link.Send(f.presence())
+	case FedPresence:
+		f.syncPresence(msg.Server, msg.Users)
+	case FedRelay:
+		f.handleRelay(msg)
+	case FedForward:
+		f.handleForward(msg)
+	}
+}
+
+// presence lists this server's own users
+func (f *Federator) presence() *FederationMessage {
+	users := make([]UserInfo, 0, len(f.hub.users))
+	for _, user := range f.hub.users {
+		if user.Peer == "" && !f.hub.isHidden(user) {
+			users = append(users, UserInfo{UserID: user.ID, Username: user.Username, InGame: user.InGame, Lobby: user.Lobby})
+		}
+	}
+	return &FederationMessage{Type: FedPresence, Server: f.serverID, Users: users}
+}
+
+// sendPresence sends the local user list to every peer if it changed
+func (f *Federator) sendPresence() {
+	msg := f.presence()
+	data, _ := json.Marshal(msg.Users)
+	if string(data) == f.lastPresence {
+		return
+	}
+	f.lastPresence = string(data)
+	for _, link := range f.peers {
+		link.Send(msg)
+	}
+}
+
+// syncPresence mirrors a peer's user list as proxy users in the local hub
+func (f *Federator) syncPresence(server string, users []UserInfo) {
+	seen := make(map[string]bool, len(users))
+	for _, info := range users {
+		seen[info.UserID] = true
+		proxy, exists := f.hub.users[info.UserID]
+		if exists {
+			if proxy.Peer != server {
+				continue // ID collision with a user from elsewhere; keep ours
+			}
+			proxy.Username = info.Username
+			proxy.Lobby = lobbyOrDefault(info.Lobby)
+			proxy.InGame = info.InGame || len(proxy.GameIDs) > 0
+			continue
+		}
+
+		proxy = &User{
+			ID:         info.UserID,
+			Username:   info.Username,
+			InGame:     info.InGame,
+			GameIDs:    make(map[string]bool),
+			Locale:     defaultLocale,
+			LastActive: f.hub.now(),
+			Peer:       server,
+			Lobby:      lobbyOrDefault(info.Lobby),
+		}
+		proxy.Client = &Client{hub: f.hub, user: proxy}
+		f.hub.users[proxy.ID] = proxy
+	}
+
+	for _, user := range f.hub.users {
+		if user.Peer == server && !seen[user.ID] {
+			f.hub.handleDisconnect(user.Client)
+		}
+	}
+	f.hub.broadcastUserList()
+}
+
+// removeLink drops a peer whose link closed, along with its users
+func (f *Federator) removeLink(link PeerLink) {
+	for server, peer := range f.peers {
+		if peer != link {
+			continue
+		}
+		delete(f.peers, server)
+		for id, host := range f.remoteRefs {
+			if host == server {
+				delete(f.remoteRefs, id)
+			}
+		}
+		f.syncPresence(server, nil)
+		f.hub.logger.Info("federation_lost", "server", server)
+	}
+}
+
+// relay sends a hub message to a proxy user's home server
+func (f *Federator) relay(user *User, msg *Message) {
+	link, exists := f.peers[user.Peer]
+	if !exists {
+		return
+	}
+	link.Send(&FederationMessage{Type: FedRelay, Server: f.serverID, UserID: user.ID, Message: msg})
+}
+
+// handleRelay delivers a message from a hosting server to a local user,
+// remembering which server hosts the challenge or game it refers to
+func (f *Federator) handleRelay(msg *FederationMessage) {
+	user, exists := f.hub.users[msg.UserID]
+	if !exists || user.Peer != "" || msg.Message == nil {
+		return
+	}
+	if msg.Message.ChallengeID != "" {
+		f.remoteRefs[msg.Message.ChallengeID] = msg.Server
+	}
+	if msg.Message.GameID != "" {
+		f.remoteRefs[msg.Message.GameID] = msg.Server
+	}
+
+	// Track remotely hosted games so the user shows as busy here too
+	switch msg.Message.Type {
+	case "game_start":
+		user.joinGame(msg.Message.GameID)
+		f.hub.broadcastUserList()
+	case "game_end", "opponent_disconnected":
+		user.leaveGame(msg.Message.GameID)
+		f.hub.broadcastUserList()
+	}
+
+	f.hub.sendToUser(user, msg.Message)
+}
+
+// forward sends a local user's message to the server hosting the challenge
+// or game it refers to. It reports false if the message is handled locally.
+func (f *Federator) forward(user *User, msg *Message) bool {
+	server := f.remoteRefs[msg.GameID]
+	if server == "" {
+		server = f.remoteRefs[msg.ChallengeID]
+	}
+	link, exists := f.peers[server]
+	if server == "" || !exists {
+		return false
+	}
+	link.Send(&FederationMessage{Type: FedForward, Server: f.serverID, UserID: user.ID, Message: msg})
+	return true
+}
+
+// handleForward handles a remote user's message on behalf of their proxy
+func (f *Federator) handleForward(msg *FederationMessage) {
+	proxy, exists := f.hub.users[msg.UserID]
+	if !exists || proxy.Peer != msg.Server || msg.Message == nil {
+		return
+	}
+	f.hub.handleClientMessage(proxy.Client, msg.Message)
+}
+
+// wsPeerLink is a PeerLink over a websocket connection
+type wsPeerLink struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+func newWSPeerLink(conn *websocket.Conn) *wsPeerLink {
+	return &wsPeerLink{conn: conn, send: make(chan []byte, 256)}
+}
+
+func (l *wsPeerLink) Send(msg *FederationMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case l.send <- data:
+		return nil
+	default:
+		return errors.New("federation link send buffer full")
+	}
+}
+
+// run pumps the link until the connection fails, then reports it closed
+func (l *wsPeerLink) run(hub *Hub) {
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case data := <-l.send:
+				l.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := l.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					l.conn.Close()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+read:
+	for {
+		var msg FederationMessage
+		if err := l.conn.ReadJSON(&msg); err != nil {
+			break
+		}
+		select {
+		case hub.federationIn <- federationEvent{link: l, msg: &msg}:
+		case <-hub.stopped:
+			break read
+		}
+	}
+	close(stop)
+	l.conn.Close()
+	select {
+	case hub.federationIn <- federationEvent{link: l}:
+	case <-hub.stopped:
+	}
+}
+
+// serveFederation accepts an inbound link from a peer server that presents
+// the shared federation secret
+func serveFederation(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	secret := hub.config.FederationSecret
+	got := r.Header.Get("X-Federation-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		hub.logger.Warn("upgrade_failed", "error", err)
+		return
+	}
+	go newWSPeerLink(conn).run(hub)
+}
+
+// dialPeer keeps an outbound link to a peer server open, redialing after
+// failures until the hub stops
+func dialPeer(hub *Hub, url string) {
+	header := http.Header{"X-Federation-Secret": []string{hub.config.FederationSecret}}
+	hello := &FederationMessage{Type: FedHello, Server: hub.config.FederationID}
+	for {
+		select {
+		case <-hub.stopped:
+			return
+		default:
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			hub.logger.Warn("federation_dial_failed", "url", url, "error", err)
+		} else {
+			link := newWSPeerLink(conn)
+			link.Send(hello)
+			link.run(hub)
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-hub.stopped:
+			return
+		}
+	}
+}
+
+// lobbyOrDefault puts users from peers that don't report a lobby in the default one
+func lobbyOrDefault(lobby string) string {
+	if lobby == "" {
+		return DEFAULT_LOBBY
+	}
+	return lobby
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// inProcessLink delivers federation messages straight to another hub's federator
+type inProcessLink struct {
+	target *Federator
+	back   PeerLink // the target's link back to the sender
+}
+
+func (l *inProcessLink) Send(msg *FederationMessage) error {
+	l.target.handleEvent(federationEvent{link: l.back, msg: msg})
+	return nil
+}
+
+// newFederatedHubs returns two hubs linked in-process
+func newFederatedHubs() (*Hub, *Hub) {
+	a := newHub(DefaultConfig())
+	b := newHub(DefaultConfig())
+	a.config.UserListBatchWindow = 0
+	b.config.UserListBatchWindow = 0
+	a.federator = newFederator(a, "server-a")
+	b.federator = newFederator(b, "server-b")
+
+	toB := &inProcessLink{target: b.federator}
+	toA := &inProcessLink{target: a.federator}
+	toB.back = toA
+	toA.back = toB
+	a.federator.connect(toB)
+	return a, b
+}
+
+// TestFederationPresence tests that linked servers see each other's users
+func TestFederationPresence(t *testing.T) {
+	hubA, hubB := newFederatedHubs()
+	a1 := newTestClient(hubA)
+	b1 := newTestClient(hubB)
+
+	proxy, exists := hubA.users[b1.user.ID]
+	if !exists || proxy.Peer != "server-b" || proxy.Username != b1.user.Username {
+		t.Fatalf("server A should list B's user as a proxy, got %+v", proxy)
+	}
+	if _, exists := hubB.users[a1.user.ID]; !exists {
+		t.Fatal("server B should list A's user")
+	}
+
+	update := lastMessageOfType(drainMessages(a1), "users_update")
+	found := false
+	for _, info := range update.Users {
+		if info.UserID == b1.user.ID && info.Server == "server-b" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("users_update on A should include the remote user, got %+v", update.Users)
+	}
+
+	// A user leaving B disappears from A
+	hubB.handleUnregister(b1)
+	if _, exists := hubA.users[b1.user.ID]; exists {
+		t.Error("remote user should be removed when they leave their server")
+	}
+}
+
+// TestFederationCrossServerChallenge tests a challenge and game between users on two servers
+func TestFederationCrossServerChallenge(t *testing.T) {
+	hubA, hubB := newFederatedHubs()
+	a1 := newTestClient(hubA)
+	b1 := newTestClient(hubB)
+	drainMessages(a1)
+	drainMessages(b1)
+
+	hubA.handleClientMessage(a1, &Message{Type: "challenge", TargetUserID: b1.user.ID, Note: "hi from A"})
+	received := lastMessageOfType(drainMessages(b1), "challenge_received")
+	if received == nil || received.FromUserID != a1.user.ID || received.Note != "hi from A" {
+		t.Fatalf("B's user should receive the relayed challenge, got %+v", received)
+	}
+
+	// Accepting on B is forwarded to A, which hosts the game
+	hubB.handleClientMessage(b1, &Message{Type: "accept_challenge", ChallengeID: received.ChallengeID})
+	startA := lastMessageOfType(drainMessages(a1), "game_start")
+	startB := lastMessageOfType(drainMessages(b1), "game_start")
+	if startA == nil || startB == nil || startA.GameID != startB.GameID {
+		t.Fatalf("both users should get game_start: A=%+v B=%+v", startA, startB)
+	}
+	game, hostedOnA := hubA.games[startA.GameID]
+	if !hostedOnA {
+		t.Fatal("game should be hosted on the challenger's server")
+	}
+	if _, hostedOnB := hubB.games[startA.GameID]; hostedOnB {
+		t.Error("game should not also exist on B")
+	}
+	if !b1.user.InGame {
+		t.Error("B's user should show as in a game on their own server")
+	}
+
+	hubA.handleClientMessage(a1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+	hubB.handleClientMessage(b1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 5})
+	result := lastMessageOfType(drainMessages(b1), "round_result")
+	if result == nil || result.P1Bid != 3 || result.P2Bid != 5 {
+		t.Fatalf("B's user should get the round result, got %+v", result)
+	}
+	if game.Player2Pos != 1 {
+		t.Errorf("B's user should have advanced on A: pos=%d", game.Player2Pos)
+	}
+}
+
+// TestFederationRemoteSpectator tests that a remote user watching a game is
+// reached through their home server
+func TestFederationRemoteSpectator(t *testing.T) {
+	hubA, hubB := newFederatedHubs()
+	hubA.config.RevealAckTimeout = 0
+	a1 := newTestClient(hubA)
+	a2 := newTestClient(hubA)
+	b1 := newTestClient(hubB)
+	game := startTestGame(t, hubA, a1, a2)
+	drainMessages(b1)
+
+	proxy := hubA.users[b1.user.ID]
+	hubA.handleClientMessage(proxy.Client, &Message{Type: "spectate", GameID: game.ID})
+	if proxy.Client.overflowed {
+		t.Fatal("sending to a proxy must not mark its client overflowed")
+	}
+	if lastMessageOfType(drainMessages(b1), "spectating") == nil {
+		t.Fatal("the remote spectator should be sent the game state")
+	}
+
+	playRound(hubA, game, a1, a2, 2, 1)
+	if result := lastMessageOfType(drainMessages(b1), "round_result"); result == nil || result.P1Bid != 2 {
+		t.Errorf("the remote spectator should get the round result, got %+v", result)
+	}
+}
+
+// TestDialPeerStopsWithHub tests that redialing an unreachable peer ends
+// when the hub stops
+func TestDialPeerStopsWithHub(t *testing.T) {
+	h := newHub(DefaultConfig())
+	go h.run(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		dialPeer(h, "ws://127.0.0.1:1/federation")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	h.shutdown()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("dialPeer should return once the hub has stopped")
+	}
+}
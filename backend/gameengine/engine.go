@@ -0,0 +1,269 @@
+// Package gameengine holds the rules of the game: how a round's bids are
+// paid and move the pawns, and when a game is over. It works on plain values
+// with no knowledge of players' connections; the hub feeds it the game state
+// and broadcasts what comes back.
+package gameengine
+
+import "math"
+
+// Game modes: how bids are paid (see Payments)
+const (
+	AllPay      = "all_pay"      // Both players pay their bid
+	SecondPrice = "second_price" // The winner pays the loser's bid, the loser pays nothing
+	FirstPrice  = "first_price"  // The winner pays their bid, the loser pays nothing
+)
+
+// Round results
+const (
+	P1WinsRound = "P1_WINS_ROUND"
+	P2WinsRound = "P2_WINS_ROUND"
+	Draw        = "DRAW"
+)
+
+// Reason codes for how a game ended
+const (
+	ReasonReachedFinalStep  = "REACHED_FINAL_STEP"
+	ReasonStalemateWin      = "STALEMATE_HIGHER_POSITION"
+	ReasonStalemateDraw     = "STALEMATE_DRAW"
+	ReasonNoMovesDraw       = "NO_MOVES_DRAW"
+	ReasonMinTotalBidNotMet = "MIN_TOTAL_BID_NOT_MET"
+	ReasonMinTotalBidDraw   = "MIN_TOTAL_BID_DRAW"
+	ReasonRoundWinTarget    = "ROUND_WIN_TARGET"
+	ReasonStalemateTieBreak = "STALEMATE_TIE_BREAK"
+	ReasonRoundLimit        = "ROUND_LIMIT"
+)
+
+// Rules are the parameters a game is played with
+type Rules struct {
+	Mode             string // One of the game modes, "" plays AllPay
+	MaxSteps         int    // Position to reach to win the position race
+	RoundWinTarget   int    // Round wins to win instead, 0 = position race
+	GraceBid         bool   // Grant a player leading on position a last coin once
+	TieBreaks        []string
+	MinTotalBid      int // Anti-sandbagging: total to have bid by MinTotalBidRound, 0 = off
+	MinTotalBidRound int
+	MaxRounds        int // 0 = no round cap
+}
+
+// Player is one side of the game state
+type Player struct {
+	Position  int
+	Balance   int
+	Spent     int // Cumulative amount paid for bids over the game
+	TotalBid  int // Cumulative amount bid, paid or not; what MinTotalBid counts
+	RoundWins int // Rounds won outright (draws don't count)
+	GraceUsed bool
+}
+
+// Round is the part of a played round the rules look back on
+type Round struct {
+	Turn  int
+	P1Pos int // Positions after the round
+	P2Pos int
+}
+
+// State is the game as far as the rules are concerned
+type State struct {
+	Round   int    // Current round, from 1
+	Event   string // Event card in play this round, "" without event cards
+	P1, P2  Player
+	History []Round // Rounds played so far, oldest first
+}
+
+// Outcome is what resolving a round changed beyond the state itself
+type Outcome struct {
+	Result string // P1WinsRound, P2WinsRound or Draw
+	Grace  int    // Player granted a grace bid this round, 0 if none
+}
+
+// Payments returns what each player pays for a round. In all-pay auctions
+// both pay their bid whatever the outcome; in second-price auctions the
+// winner pays the loser's bid and the loser nothing, and a tie costs both
+// their (equal) bid; in first-price auctions only the winner pays their own
+// bid, so a tie costs nothing.
+func Payments(mode string, p1Bid, p2Bid int) (int, int) {
+	switch mode {
+	case SecondPrice:
+		switch {
+		case p1Bid > p2Bid:
+			return p2Bid, 0
+		case p2Bid > p1Bid:
+			return 0, p1Bid
+		}
+	case FirstPrice:
+		switch {
+		case p1Bid > p2Bid:
+			return p1Bid, 0
+		case p2Bid > p1Bid:
+			return 0, p2Bid
+		}
+		return 0, 0
+	}
+	return p1Bid, p2Bid
+}
+
+// ResolveRound plays a round with the given bids, updating state: the
+// players pay by the game mode, the higher bid advances, the round's event
+// card applies and, if the rules allow, a grace bid is granted. Bids are
+// validated by the caller; any outside 0 to the player's balance are clamped
+// so the state stays valid regardless.
+func ResolveRound(rules Rules, state *State, p1Bid, p2Bid int) Outcome {
+	p1Bid = clampBid(p1Bid, state.P1.Balance)
+	p2Bid = clampBid(p2Bid, state.P2.Balance)
+
+	// Deduction, by the game mode's payment rule
+	p1Pay, p2Pay := Payments(rules.Mode, p1Bid, p2Bid)
+	state.P1.Balance -= p1Pay
+	state.P2.Balance -= p2Pay
+	state.P1.Spent = credit(state.P1.Spent, p1Pay)
+	state.P2.Spent = credit(state.P2.Spent, p2Pay)
+	state.P1.TotalBid = credit(state.P1.TotalBid, p1Bid)
+	state.P2.TotalBid = credit(state.P2.TotalBid, p2Bid)
+
+	// Movement determination
+	var outcome Outcome
+	if p1Bid > p2Bid {
+		state.P1.Position++
+		state.P1.RoundWins++
+		outcome.Result = P1WinsRound
+	} else if p2Bid > p1Bid {
+		state.P2.Position++
+		state.P2.RoundWins++
+		outcome.Result = P2WinsRound
+	} else {
+		outcome.Result = Draw
+	}
+
+	applyEvent(rules, state, outcome.Result, p1Bid, p2Bid)
+
+	if rules.GraceBid {
+		outcome.Grace = grantGraceBid(rules, state)
+	}
+	return outcome
+}
+
+// clampBid limits a bid to what the player can pay
+func clampBid(bid, balance int) int {
+	if bid < 0 || balance <= 0 {
+		return 0
+	}
+	return min(bid, balance)
+}
+
+// credit adds a non-negative amount, saturating instead of overflowing
+func credit(total, amount int) int {
+	if total > math.MaxInt-amount {
+		return math.MaxInt
+	}
+	return total + amount
+}
+
+// grantGraceBid gives a player who has just run out of balance while leading
+// on position a one-time balance of 1, returning who got it. Only the
+// position race has a leader.
+func grantGraceBid(rules Rules, state *State) int {
+	if rules.RoundWinTarget > 0 {
+		return 0
+	}
+	if state.P1.Balance == 0 && state.P1.Position > state.P2.Position && !state.P1.GraceUsed {
+		state.P1.Balance = 1
+		state.P1.GraceUsed = true
+		return 1
+	}
+	if state.P2.Balance == 0 && state.P2.Position > state.P1.Position && !state.P2.GraceUsed {
+		state.P2.Balance = 1
+		state.P2.GraceUsed = true
+		return 2
+	}
+	return 0
+}
+
+// CheckWin returns the winner (1 or 2, 3 for a draw, 0 while the game goes
+// on) and the reason code
+func CheckWin(rules Rules, state State) (int, string) {
+	p1, p2 := state.P1, state.P2
+	if rules.RoundWinTarget > 0 {
+		// Round-win mode: first to the target number of round wins, positions are ignored
+		if p1.RoundWins >= rules.RoundWinTarget {
+			return 1, ReasonRoundWinTarget
+		}
+		if p2.RoundWins >= rules.RoundWinTarget {
+			return 2, ReasonRoundWinTarget
+		}
+
+		// Bankruptcy stalemate goes to whoever won more rounds
+		if p1.Balance == 0 && p2.Balance == 0 {
+			if p1.RoundWins > p2.RoundWins {
+				return 1, ReasonStalemateWin
+			} else if p2.RoundWins > p1.RoundWins {
+				return 2, ReasonStalemateWin
+			} else {
+				return 3, ReasonStalemateDraw
+			}
+		}
+	} else {
+		// Check if either player reached the final step
+		if p1.Position >= rules.MaxSteps {
+			return 1, ReasonReachedFinalStep
+		}
+		if p2.Position >= rules.MaxSteps {
+			return 2, ReasonReachedFinalStep
+		}
+
+		// Check for bankruptcy stalemate. Only both players being broke
+		// ends the game: in the first-price mode the loser keeps their
+		// stake, so one player can run dry while the other still has coins
+		// to outbid them with.
+		if p1.Balance == 0 && p2.Balance == 0 {
+			return BreakTie(state, rules.TieBreaks)
+		}
+
+		// Check if both players are at position 0 with 0 balance (edge case)
+		if p1.Position == 0 && p2.Position == 0 && p1.Balance == 0 && p2.Balance == 0 {
+			return 3, ReasonNoMovesDraw
+		}
+	}
+
+	// Anti-sandbagging: players who haven't bid enough by the threshold round
+	// forfeit. Bids count whether or not the game mode made them pay, so an
+	// outbid player in a first- or second-price game isn't taken for a sandbagger.
+	if rules.MinTotalBid > 0 && state.Round == rules.MinTotalBidRound {
+		p1Short := p1.TotalBid < rules.MinTotalBid
+		p2Short := p2.TotalBid < rules.MinTotalBid
+		if p1Short && p2Short {
+			return 3, ReasonMinTotalBidDraw
+		} else if p1Short {
+			return 2, ReasonMinTotalBidNotMet
+		} else if p2Short {
+			return 1, ReasonMinTotalBidNotMet
+		}
+	}
+
+	// Round cap: stops players who keep bidding 0 from drawing forever
+	if rules.MaxRounds > 0 && state.Round >= rules.MaxRounds {
+		return roundLimitWinner(rules, state), ReasonRoundLimit
+	}
+
+	return 0, ""
+}
+
+// roundLimitWinner decides a game stopped by the round cap: the player
+// further ahead wins (on round wins in the round-win mode), then the one
+// with more balance left, otherwise it's a draw
+func roundLimitWinner(rules Rules, state State) int {
+	p1Lead, p2Lead := state.P1.Position, state.P2.Position
+	if rules.RoundWinTarget > 0 {
+		p1Lead, p2Lead = state.P1.RoundWins, state.P2.RoundWins
+	}
+	switch {
+	case p1Lead > p2Lead:
+		return 1
+	case p2Lead > p1Lead:
+		return 2
+	case state.P1.Balance > state.P2.Balance:
+		return 1
+	case state.P2.Balance > state.P1.Balance:
+		return 2
+	}
+	return 3
+}
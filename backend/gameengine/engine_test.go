@@ -0,0 +1,316 @@
+package gameengine
+
+import (
+	"math"
+	"testing"
+)
+
+// newState returns round 1 of a game with both players on budget
+func newState(budget int) State {
+	return State{Round: 1, P1: Player{Balance: budget}, P2: Player{Balance: budget}}
+}
+
+var positionRace = Rules{MaxSteps: 3, TieBreaks: []string{TieBreakPosition}}
+
+// TestAllPayMechanic tests that both players lose their bid regardless of outcome
+func TestAllPayMechanic(t *testing.T) {
+	tests := []struct {
+		name          string
+		p1Bid         int
+		p2Bid         int
+		expectedP1Bal int
+		expectedP2Bal int
+	}{
+		{"P1 wins round", 5, 3, 15, 17},
+		{"P2 wins round", 2, 7, 18, 13},
+		{"Draw - both bid 0", 0, 0, 20, 20},
+		{"Draw - both bid same non-zero", 5, 5, 15, 15},
+		{"All-in P1 wins", 20, 10, 0, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newState(20)
+			ResolveRound(positionRace, &state, tt.p1Bid, tt.p2Bid)
+
+			if state.P1.Balance != tt.expectedP1Bal {
+				t.Errorf("P1 balance: got %d, want %d", state.P1.Balance, tt.expectedP1Bal)
+			}
+			if state.P2.Balance != tt.expectedP2Bal {
+				t.Errorf("P2 balance: got %d, want %d", state.P2.Balance, tt.expectedP2Bal)
+			}
+			if state.P1.Spent != tt.p1Bid || state.P2.Spent != tt.p2Bid {
+				t.Errorf("spent: got %d/%d, want %d/%d", state.P1.Spent, state.P2.Spent, tt.p1Bid, tt.p2Bid)
+			}
+		})
+	}
+}
+
+// TestPayments tests what each game mode charges for a round
+func TestPayments(t *testing.T) {
+	tests := []struct {
+		mode         string
+		p1Bid, p2Bid int
+		p1Pay, p2Pay int
+	}{
+		{"", 7, 4, 7, 4},
+		{AllPay, 5, 5, 5, 5},
+		{SecondPrice, 7, 4, 4, 0},
+		{SecondPrice, 2, 6, 0, 2},
+		{SecondPrice, 5, 5, 5, 5},
+		{FirstPrice, 7, 4, 7, 0},
+		{FirstPrice, 2, 6, 0, 6},
+		{FirstPrice, 5, 5, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if p1, p2 := Payments(tt.mode, tt.p1Bid, tt.p2Bid); p1 != tt.p1Pay || p2 != tt.p2Pay {
+			t.Errorf("%q %d vs %d: got %d/%d, want %d/%d", tt.mode, tt.p1Bid, tt.p2Bid, p1, p2, tt.p1Pay, tt.p2Pay)
+		}
+	}
+}
+
+// TestRoundResolution tests who advances based on bids
+func TestRoundResolution(t *testing.T) {
+	tests := []struct {
+		name           string
+		p1Bid          int
+		p2Bid          int
+		expectedPos1   int
+		expectedPos2   int
+		expectedResult string
+	}{
+		{"P1 wins with higher bid", 5, 3, 1, 0, P1WinsRound},
+		{"P2 wins with higher bid", 2, 7, 0, 1, P2WinsRound},
+		{"Draw - equal bids", 5, 5, 0, 0, Draw},
+		{"Draw - both bid 0", 0, 0, 0, 0, Draw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []string{AllPay, SecondPrice, FirstPrice} {
+				state := newState(20)
+				outcome := ResolveRound(Rules{Mode: mode, MaxSteps: 3}, &state, tt.p1Bid, tt.p2Bid)
+
+				if state.P1.Position != tt.expectedPos1 {
+					t.Errorf("%s: P1 position: got %d, want %d", mode, state.P1.Position, tt.expectedPos1)
+				}
+				if state.P2.Position != tt.expectedPos2 {
+					t.Errorf("%s: P2 position: got %d, want %d", mode, state.P2.Position, tt.expectedPos2)
+				}
+				if outcome.Result != tt.expectedResult {
+					t.Errorf("%s: result: got %s, want %s", mode, outcome.Result, tt.expectedResult)
+				}
+			}
+		})
+	}
+}
+
+// TestEventEffects tests that event cards modify round resolution
+func TestEventEffects(t *testing.T) {
+	tests := []struct {
+		name          string
+		event         string
+		p1Bid, p2Bid  int
+		expectedP1Pos int
+		expectedP2Pos int
+		expectedP1Bal int
+		expectedP2Bal int
+	}{
+		{"Double advance", EventDoubleAdvance, 5, 3, 2, 0, 15, 17},
+		{"Double advance stops at the finish", EventDoubleAdvance, 5, 3, 3, 0, 15, 17},
+		{"Bonus budget", EventBonusBudget, 5, 3, 1, 0, 18, 20},
+		{"Winner pays double", EventWinnerPaysDouble, 5, 3, 1, 0, 10, 17},
+		{"Winner pays double on draw", EventWinnerPaysDouble, 4, 4, 0, 0, 16, 16},
+		{"Calm", EventCalm, 2, 7, 0, 1, 18, 13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newState(20)
+			state.Event = tt.event
+			if tt.expectedP1Pos == 3 {
+				state.P1.Position = 2
+			}
+			ResolveRound(positionRace, &state, tt.p1Bid, tt.p2Bid)
+
+			if state.P1.Position != tt.expectedP1Pos || state.P2.Position != tt.expectedP2Pos {
+				t.Errorf("positions: got %d/%d, want %d/%d", state.P1.Position, state.P2.Position, tt.expectedP1Pos, tt.expectedP2Pos)
+			}
+			if state.P1.Balance != tt.expectedP1Bal || state.P2.Balance != tt.expectedP2Bal {
+				t.Errorf("balances: got %d/%d, want %d/%d", state.P1.Balance, state.P2.Balance, tt.expectedP1Bal, tt.expectedP2Bal)
+			}
+		})
+	}
+}
+
+// TestGraceBid tests that a player going broke while ahead gets one coin, once
+func TestGraceBid(t *testing.T) {
+	rules := positionRace
+	rules.GraceBid = true
+	state := newState(5)
+
+	if outcome := ResolveRound(rules, &state, 5, 2); outcome.Grace != 1 || state.P1.Balance != 1 {
+		t.Fatalf("P1 should get a grace bid, got grace %d and balance %d", outcome.Grace, state.P1.Balance)
+	}
+	state.P1.Position = 2
+	if outcome := ResolveRound(rules, &state, 1, 0); outcome.Grace != 0 || state.P1.Balance != 0 {
+		t.Errorf("the grace bid is only granted once, got grace %d and balance %d", outcome.Grace, state.P1.Balance)
+	}
+
+	rules.RoundWinTarget = 3
+	state = newState(5)
+	if outcome := ResolveRound(rules, &state, 5, 2); outcome.Grace != 0 {
+		t.Error("the round-win mode has no leader to grant a grace bid")
+	}
+}
+
+// TestWinCondition tests the win conditions
+func TestWinCondition(t *testing.T) {
+	tests := []struct {
+		name        string
+		p1Pos       int
+		p2Pos       int
+		p1Bal       int
+		p2Bal       int
+		expectedWin int // 0 = continue, 1 = p1 wins, 2 = p2 wins, 3 = draw
+	}{
+		{"P1 reaches finish", 3, 1, 10, 10, 1},
+		{"P2 reaches finish", 1, 3, 10, 10, 2},
+		{"Game continues - neither at finish", 1, 1, 10, 10, 0},
+		{"Game continues - one player broke", 1, 1, 0, 10, 0},
+		{"Bankruptcy stalemate - P1 higher position", 2, 1, 0, 0, 1},
+		{"Bankruptcy stalemate - P2 higher position", 1, 2, 0, 0, 2},
+		{"Bankruptcy stalemate - equal position draw", 1, 1, 0, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := State{
+				Round: 1,
+				P1:    Player{Position: tt.p1Pos, Balance: tt.p1Bal},
+				P2:    Player{Position: tt.p2Pos, Balance: tt.p2Bal},
+			}
+			if winner, _ := CheckWin(positionRace, state); winner != tt.expectedWin {
+				t.Errorf("Winner: got %d, want %d", winner, tt.expectedWin)
+			}
+		})
+	}
+}
+
+// TestWinConditionRules tests the optional end conditions
+func TestWinConditionRules(t *testing.T) {
+	t.Run("Round-win target", func(t *testing.T) {
+		rules := Rules{MaxSteps: 3, RoundWinTarget: 2}
+		state := State{Round: 4, P1: Player{Position: 3, Balance: 5, RoundWins: 1}, P2: Player{Balance: 5, RoundWins: 2}}
+		if winner, reason := CheckWin(rules, state); winner != 2 || reason != ReasonRoundWinTarget {
+			t.Errorf("got winner %d (%s), want 2 on round wins", winner, reason)
+		}
+	})
+
+	t.Run("Minimum total bid", func(t *testing.T) {
+		rules := Rules{MaxSteps: 3, MinTotalBid: 4, MinTotalBidRound: 3}
+		state := State{Round: 3, P1: Player{Balance: 10, TotalBid: 6}, P2: Player{Balance: 10, TotalBid: 2, Spent: 6}}
+		if winner, reason := CheckWin(rules, state); winner != 1 || reason != ReasonMinTotalBidNotMet {
+			t.Errorf("got winner %d (%s), want P2 to forfeit", winner, reason)
+		}
+		state.Round = 4
+		if winner, _ := CheckWin(rules, state); winner != 0 {
+			t.Errorf("the rule only applies at its round, got winner %d", winner)
+		}
+		// An outbid player pays nothing in a first-price game but still bid
+		rules = Rules{Mode: FirstPrice, MaxSteps: 5, MinTotalBid: 8, MinTotalBidRound: 2}
+		state = newState(20)
+		ResolveRound(rules, &state, 6, 5)
+		ResolveRound(rules, &state, 6, 5)
+		state.Round = 2
+		if state.P2.Spent != 0 || state.P2.TotalBid != 10 {
+			t.Fatalf("P2 spent %d and bid %d, want 0 and 10", state.P2.Spent, state.P2.TotalBid)
+		}
+		if winner, reason := CheckWin(rules, state); winner != 0 {
+			t.Errorf("an outbid player met the minimum, got winner %d (%s)", winner, reason)
+		}
+	})
+
+	t.Run("Round limit", func(t *testing.T) {
+		rules := Rules{MaxSteps: 3, MaxRounds: 10}
+		state := State{Round: 10, P1: Player{Position: 1, Balance: 3}, P2: Player{Position: 1, Balance: 7}}
+		if winner, reason := CheckWin(rules, state); winner != 2 || reason != ReasonRoundLimit {
+			t.Errorf("got winner %d (%s), want 2 on balance", winner, reason)
+		}
+	})
+}
+
+// FuzzResolveRound checks the round invariants for any bids and starting
+// state: balances never go negative, a round moves a pawn at most one step
+// (two on a double advance), and a game played to the end terminates
+func FuzzResolveRound(f *testing.F) {
+	f.Add(5, 3, 20, 20, 0, 0, uint8(0))
+	f.Add(0, 0, 0, 0, 1, 1, uint8(1))
+	f.Add(-1, 99, 3, 2, 2, 0, uint8(2))
+	f.Add(math.MaxInt, math.MinInt, math.MaxInt, math.MaxInt-1, 0, 2, uint8(3))
+	f.Add(7, 7, math.MaxInt, math.MaxInt, 1, 2, uint8(7))
+	f.Add(1, 0, math.MaxInt, math.MaxInt, 0, 0, uint8(9))
+
+	modes := []string{AllPay, SecondPrice, FirstPrice}
+	events := []string{"", EventCalm, EventDoubleAdvance, EventBonusBudget, EventWinnerPaysDouble}
+
+	f.Fuzz(func(t *testing.T, p1Bid, p2Bid, p1Balance, p2Balance, p1Pos, p2Pos int, variant uint8) {
+		rules := Rules{
+			Mode:      modes[int(variant)%len(modes)],
+			MaxSteps:  3,
+			GraceBid:  variant&8 != 0,
+			TieBreaks: []string{TieBreakPosition},
+			MaxRounds: 30,
+		}
+		if variant&16 != 0 {
+			rules.RoundWinTarget = 2
+		}
+		state := State{
+			Round: 1,
+			Event: events[int(variant/3)%len(events)],
+			P1:    Player{Position: abs(p1Pos) % rules.MaxSteps, Balance: abs(p1Balance)},
+			P2:    Player{Position: abs(p2Pos) % rules.MaxSteps, Balance: abs(p2Balance)},
+		}
+
+		before := state
+		ResolveRound(rules, &state, p1Bid, p2Bid)
+		maxMove := 1
+		if state.Event == EventDoubleAdvance {
+			maxMove = 2
+		}
+		if state.P1.Balance < 0 || state.P2.Balance < 0 {
+			t.Fatalf("negative balance: %d/%d", state.P1.Balance, state.P2.Balance)
+		}
+		for _, move := range []int{state.P1.Position - before.P1.Position, state.P2.Position - before.P2.Position} {
+			if move < 0 || move > maxMove {
+				t.Fatalf("positions moved from %d/%d to %d/%d", before.P1.Position, before.P2.Position, state.P1.Position, state.P2.Position)
+			}
+		}
+
+		// Play on with the same bids (within balance) until the game ends
+		for state.Round = 1; ; state.Round++ {
+			if winner, _ := CheckWin(rules, state); winner != 0 {
+				if winner < 1 || winner > 3 {
+					t.Fatalf("invalid winner %d", winner)
+				}
+				return
+			}
+			if state.Round > rules.MaxRounds {
+				t.Fatalf("game still going after %d rounds: %+v", state.Round, state)
+			}
+			ResolveRound(rules, &state, p1Bid, p2Bid)
+			if state.P1.Balance < 0 || state.P2.Balance < 0 {
+				t.Fatalf("negative balance in round %d: %d/%d", state.Round, state.P1.Balance, state.P2.Balance)
+			}
+		}
+	})
+}
+
+// abs maps fuzzed ints to non-negative ones, including math.MinInt
+func abs(n int) int {
+	if n < 0 {
+		n = -(n + 1)
+	}
+	return n
+}
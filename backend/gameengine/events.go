@@ -0,0 +1,34 @@
+package gameengine
+
+// Event cards drawn each round in the event cards variant
+const (
+	EventCalm             = "CALM"               // No effect
+	EventDoubleAdvance    = "DOUBLE_ADVANCE"     // The round winner advances two steps
+	EventBonusBudget      = "BONUS_BUDGET"       // Both players gain EVENT_BONUS_BUDGET after paying
+	EventWinnerPaysDouble = "WINNER_PAYS_DOUBLE" // The round winner pays their bid twice
+)
+
+const EVENT_BONUS_BUDGET = 3
+
+// applyEvent applies the round's event card after the normal deduction and
+// movement
+func applyEvent(rules Rules, state *State, result string, p1Bid, p2Bid int) {
+	switch state.Event {
+	case EventDoubleAdvance:
+		if result == P1WinsRound && state.P1.Position < rules.MaxSteps {
+			state.P1.Position++
+		} else if result == P2WinsRound && state.P2.Position < rules.MaxSteps {
+			state.P2.Position++
+		}
+	case EventBonusBudget:
+		state.P1.Balance = credit(state.P1.Balance, EVENT_BONUS_BUDGET)
+		state.P2.Balance = credit(state.P2.Balance, EVENT_BONUS_BUDGET)
+	case EventWinnerPaysDouble:
+		// The winner pays their bid a second time, as far as their balance allows
+		if result == P1WinsRound {
+			state.P1.Balance -= min(p1Bid, state.P1.Balance)
+		} else if result == P2WinsRound {
+			state.P2.Balance -= min(p2Bid, state.P2.Balance)
+		}
+	}
+}
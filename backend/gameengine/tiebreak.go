@@ -0,0 +1,71 @@
+package gameengine
+
+// Tie-breakers an operator can chain in the rules. They decide a bankruptcy
+// stalemate in the position race, trying each in order until one separates
+// the players; if none does the game is a draw.
+const (
+	TieBreakPosition     = "POSITION"       // Higher position wins
+	TieBreakBalance      = "BALANCE"        // More remaining budget wins
+	TieBreakSpent        = "SPENT"          // More spent over the game wins
+	TieBreakFirstToReach = "FIRST_TO_REACH" // Whoever reached the tied position first wins
+)
+
+// ValidTieBreak reports whether name is a known tie-breaker
+func ValidTieBreak(name string) bool {
+	_, ok := tieBreakers[name]
+	return ok
+}
+
+// tieBreaker compares the players, returning > 0 if player 1 is ahead, < 0 if
+// player 2 is, and 0 if they are level
+type tieBreaker func(state State) int
+
+var tieBreakers = map[string]tieBreaker{
+	TieBreakPosition: func(state State) int {
+		return state.P1.Position - state.P2.Position
+	},
+	TieBreakBalance: func(state State) int {
+		return state.P1.Balance - state.P2.Balance
+	},
+	TieBreakSpent: func(state State) int {
+		return state.P1.Spent - state.P2.Spent
+	},
+	TieBreakFirstToReach: func(state State) int {
+		if state.P1.Position != state.P2.Position || state.P1.Position == 0 {
+			return 0
+		}
+		p1, p2 := 0, 0
+		for i := len(state.History) - 1; i >= 0; i-- {
+			if state.History[i].P1Pos == state.P1.Position {
+				p1 = state.History[i].Turn
+			}
+			if state.History[i].P2Pos == state.P2.Position {
+				p2 = state.History[i].Turn
+			}
+		}
+		return p2 - p1 // earlier round wins
+	},
+}
+
+// BreakTie applies the tie-break chain, returning the winner (3 for a draw)
+// and the reason code. Names are checked with ValidTieBreak when the
+// configuration is loaded; an unknown one is skipped.
+func BreakTie(state State, chain []string) (int, string) {
+	for _, name := range chain {
+		compare, exists := tieBreakers[name]
+		if !exists {
+			continue
+		}
+
+		reason := ReasonStalemateTieBreak
+		if name == TieBreakPosition {
+			reason = ReasonStalemateWin
+		}
+		if diff := compare(state); diff > 0 {
+			return 1, reason
+		} else if diff < 0 {
+			return 2, reason
+		}
+	}
+	return 3, ReasonStalemateDraw
+}
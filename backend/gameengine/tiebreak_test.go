@@ -0,0 +1,47 @@
+package gameengine
+
+import "testing"
+
+// TestBreakTieByBalance tests a chain that breaks an equal-position stalemate by remaining budget
+func TestBreakTieByBalance(t *testing.T) {
+	state := State{
+		P1: Player{Position: 1, Balance: 2},
+		P2: Player{Position: 1, Balance: 5},
+	}
+	chain := []string{TieBreakPosition, TieBreakBalance}
+
+	winner, reason := BreakTie(state, chain)
+	if winner != 2 || reason != ReasonStalemateTieBreak {
+		t.Errorf("got winner %d (%s), want 2 on tie-break", winner, reason)
+	}
+
+	// Position still comes first in the chain
+	state.P1.Position = 2
+	if winner, reason := BreakTie(state, chain); winner != 1 || reason != ReasonStalemateWin {
+		t.Errorf("got winner %d (%s), want 1 on position", winner, reason)
+	}
+
+	// Level on every tie-breaker is a draw
+	state.P1.Position = 1
+	state.P1.Balance = 5
+	if winner, reason := BreakTie(state, chain); winner != 3 || reason != ReasonStalemateDraw {
+		t.Errorf("got winner %d (%s), want a draw", winner, reason)
+	}
+}
+
+// TestBreakTieFirstToReach tests that the earlier arrival at the tied position wins
+func TestBreakTieFirstToReach(t *testing.T) {
+	state := State{
+		P1: Player{Position: 2},
+		P2: Player{Position: 2},
+		History: []Round{
+			{Turn: 1, P1Pos: 1, P2Pos: 0},
+			{Turn: 2, P1Pos: 2, P2Pos: 0},
+			{Turn: 3, P1Pos: 2, P2Pos: 1},
+			{Turn: 4, P1Pos: 2, P2Pos: 2},
+		},
+	}
+	if winner, reason := BreakTie(state, []string{TieBreakFirstToReach}); winner != 1 || reason != ReasonStalemateTieBreak {
+		t.Errorf("got winner %d (%s), want 1 on tie-break", winner, reason)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/google/uuid"
+)
+
+// A ghost replays one player of a stored game: the human plays live as
+// player 1 against the recorded bids, round by round, to see if they can do
+// better. The ghost has no connection and is not listed in the lobby.
+type Ghost struct {
+	User *User
+	Bids []int // Recorded bids in round order
+}
+
+// newGhost builds a ghost of the given player (1 or 2) of a stored game
+func newGhost(record *GameRecord, player int) *Ghost {
+	username := record.Player1Username
+	if player == 2 {
+		username = record.Player2Username
+	}
+
+	bids := make([]int, len(record.History))
+	for i, round := range record.History {
+		if player == 2 {
+			bids[i] = round.P2Bid
+		} else {
+			bids[i] = round.P1Bid
+		}
+	}
+
+	return &Ghost{
+		User: &User{
+			ID:       uuid.New().String(),
+			Username: "Ghost of " + username,
+			GameIDs:  make(map[string]bool),
+			Locale:   defaultLocale,
+		},
+		Bids: bids,
+	}
+}
+
+// bid returns the ghost's bid for a round, or 0 once the recording runs out.
+// The bid is capped at the ghost's balance since the live game can drift
+// from the recorded one.
+func (g *Ghost) bid(round, balance int) int {
+	if round < 1 || round > len(g.Bids) {
+		return 0
+	}
+	return min(g.Bids[round-1], balance)
+}
+
+// handlePlayGhost starts a game against a ghost of a stored game's player
+func (h *Hub) handlePlayGhost(user *User, msg *Message) {
+	if !h.canJoinGame(user) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	record, err := h.store.LoadGame(msg.GameID)
+	if err != nil {
+		h.sendError(user, ErrUnknownGame)
+		return
+	}
+
+	player := msg.GhostPlayer
+	if player != 2 {
+		player = 1
+	}
+	ghost := newGhost(record, player)
+
+	// A ghost replays a single game, never a series
+	settings := record.Settings
+	settings.BestOf = 0
+	game := h.createGame(user, ghost.User, settings, nil)
+	game.Ghost = ghost
+	h.playGhost(game)
+	h.broadcastUserList()
+
+	h.logger.Info("game_start", "game_id", game.ID, "player1", user.Username, "player2", ghost.User.Username, "ghost_of", record.ID)
+}
+
+// playGhost submits the ghost's recorded bid for the open round
+func (h *Hub) playGhost(game *Game) {
+	if game.Ghost == nil || game.Status != "WAITING_FOR_BIDS" || game.Player2Bid != nil {
+		return
+	}
+	bid := game.Ghost.bid(game.CurrentRound, game.Player2Balance)
+	h.handleSubmitBid(game.Ghost.User, &Message{GameID: game.ID, Bid: bid})
+}
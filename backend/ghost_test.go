@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+// TestPlayGhost tests a game against a short ghost that runs out of recorded bids
+func TestPlayGhost(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.store.SaveGame(&GameRecord{
+		ID:              "recorded",
+		Player1Username: "Alice",
+		Player2Username: "Bob",
+		History: []RoundHistory{
+			{Turn: 1, P1Bid: 5, P2Bid: 3, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+			{Turn: 2, P1Bid: 4, P2Bid: 6, P1NewPos: 1, P2NewPos: 1, Result: "P2_WINS_ROUND"},
+		},
+	})
+	c := newTestClient(h)
+	drainMessages(c)
+
+	h.handleClientMessage(c, &Message{Type: "play_ghost", GameID: "recorded", GhostPlayer: 1})
+	start := lastMessageOfType(drainMessages(c), "game_start")
+	if start == nil || start.OpponentUsername != "Ghost of Alice" {
+		t.Fatalf("expected a game against Alice's ghost, got %+v", start)
+	}
+	game := h.games[start.GameID]
+	if game.Player2Bid == nil || *game.Player2Bid != 5 {
+		t.Fatalf("ghost should bid its recorded 5 right away, got %v", game.Player2Bid)
+	}
+
+	h.handleClientMessage(c, &Message{Type: "submit_bid", GameID: game.ID, Bid: 6})
+	h.handleClientMessage(c, &Message{Type: "submit_bid", GameID: game.ID, Bid: 5})
+	// The recording is over: the ghost falls back to 0
+	h.handleClientMessage(c, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+
+	if len(game.History) != 3 {
+		t.Fatalf("expected 3 rounds, got %d", len(game.History))
+	}
+	for i, want := range []int{5, 4, 0} {
+		if got := game.History[i].P2Bid; got != want {
+			t.Errorf("round %d ghost bid: got %d, want %d", i+1, got, want)
+		}
+	}
+	if !game.GameOver || game.Winner != 1 {
+		t.Errorf("human should beat the ghost: over=%v winner=%d", game.GameOver, game.Winner)
+	}
+
+	// Unknown recordings are refused
+	h.handleClientMessage(c, &Message{Type: "play_ghost", GameID: "missing"})
+	if errMsg := lastMessageOfType(drainMessages(c), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrUnknownGame) {
+		t.Errorf("expected %s error, got %+v", ErrUnknownGame, errMsg)
+	}
+}
@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/google/uuid v1.4.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.17
 )
 
 require golang.org/x/net v0.17.0 // indirect
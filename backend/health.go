@@ -0,0 +1,46 @@
+package main
+
+import "net/http"
+
+// Probes for container orchestration. Both read flags only, never the hub's
+// channels, so they stay cheap and can't block behind a busy hub loop.
+
+// HealthStatus is the JSON body of /healthz and /readyz
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // Per-component detail in /readyz
+}
+
+// serveHealthz reports live once the hub loop is running
+func serveHealthz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !hub.running.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, HealthStatus{Status: "starting"})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// serveReadyz reports ready once the hub loop is running and the websocket
+// upgrader and game store are set up
+func serveReadyz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"hub": "ok", "upgrader": "ok", "store": "ok"}
+	ready := true
+	if !hub.running.Load() {
+		checks["hub"] = "not running"
+		ready = false
+	}
+	if upgrader.ReadBufferSize == 0 || upgrader.WriteBufferSize == 0 {
+		checks["upgrader"] = "not configured"
+		ready = false
+	}
+	if hub.store == nil {
+		checks["store"] = "not initialized"
+		ready = false
+	}
+
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, HealthStatus{Status: "not ready", Checks: checks})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ready", Checks: checks})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHealthProbes tests that the probes turn healthy once the hub loop runs
+func TestHealthProbes(t *testing.T) {
+	h := newHub(DefaultConfig())
+
+	probe := func(serve func(*Hub, http.ResponseWriter, *http.Request)) (int, HealthStatus) {
+		rec := httptest.NewRecorder()
+		serve(h, rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		var status HealthStatus
+		json.Unmarshal(rec.Body.Bytes(), &status)
+		return rec.Code, status
+	}
+
+	if code, status := probe(serveHealthz); code != http.StatusServiceUnavailable || status.Status != "starting" {
+		t.Errorf("healthz before run: got %d %+v", code, status)
+	}
+	if code, status := probe(serveReadyz); code != http.StatusServiceUnavailable || status.Checks["hub"] != "not running" {
+		t.Errorf("readyz before run: got %d %+v", code, status)
+	}
+
+	go h.run(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for !h.running.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code, status := probe(serveHealthz); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("healthz: got %d %+v", code, status)
+	}
+	if code, status := probe(serveReadyz); code != http.StatusOK || status.Status != "ready" {
+		t.Errorf("readyz: got %d %+v", code, status)
+	}
+
+	h.store = nil
+	if code, status := probe(serveReadyz); code != http.StatusServiceUnavailable || status.Checks["store"] != "not initialized" {
+		t.Errorf("readyz without a store: got %d %+v", code, status)
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"quevadis/gameengine"
 )
 
 // Hub maintains the set of active clients and broadcasts messages
@@ -14,49 +25,297 @@ type Hub struct {
 	users        map[string]*User
 	challenges   map[string]*Challenge
 	games        map[string]*Game
+	rematches    map[string]*pendingRematch // by finished game ID
+	profileSessions map[string]*SessionStats // Session stats of profile users, by profile ID
+	spectators   map[string][]*Client       // by game ID
 	register     chan *Client
 	unregister   chan *Client
 	handleMessage chan *MessageWrapper
+	waitlist     []*Client // Connections waiting for a free slot, in order
+	matchQueue   map[string][]*User // Users waiting for a quick match, in order, by lobby
+	rooms        map[string]*Room // Open private rooms, by join code
+	federator    *Federator // nil unless federation is configured
+	federationIn chan federationEvent
+	statsRequests chan chan Stats // GET /stats snapshots, answered by run()
+	liveGamesRequests chan chan []LiveGame // GET /games listings, answered by run()
+	adminRequests chan adminRequest // POST /admin/ actions, applied by run()
+	removeGame   chan string      // Finished games due for removal, by ID
+	running      atomic.Bool      // Set once run() is processing events, for /healthz
+	quit         chan struct{}    // Closed by shutdown to stop run()
+	quitOnce     sync.Once
+	stopped      chan struct{}    // Closed once run() has returned
+	config       Config
+	store        GameStore
+
+	logger *slog.Logger
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+
+	// generateName produces candidate usernames; replaced in tests
+	generateName func() string
+
+	// botRNG randomizes the practice bot's bids; seeded in tests
+	botRNG *rand.Rand
+
+	// userListPending fires when a coalesced users_update is due; nil when
+	// no broadcast is scheduled
+	userListPending <-chan time.Time
+
+	// messageHook, if set, runs before every dispatched message (tests only)
+	messageHook func(client *Client, msg *Message)
 }
 
-func newHub() *Hub {
+func newHub(config Config) *Hub {
 	return &Hub{
+		config:       config,
+		now:          time.Now,
+		logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		generateName: GenerateRandomName,
+		botRNG:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		store:        newMemoryStore(),
 		clients:      make(map[*Client]bool),
 		users:        make(map[string]*User),
 		challenges:   make(map[string]*Challenge),
 		games:        make(map[string]*Game),
+		rematches:    make(map[string]*pendingRematch),
+		profileSessions: make(map[string]*SessionStats),
+		spectators:   make(map[string][]*Client),
+		rooms:        make(map[string]*Room),
+		matchQueue:   make(map[string][]*User),
 		register:     make(chan *Client),
 		unregister:   make(chan *Client),
 		handleMessage: make(chan *MessageWrapper, 256),
+		federationIn:  make(chan federationEvent, 256),
+		statsRequests: make(chan chan Stats),
+		liveGamesRequests: make(chan chan []LiveGame),
+		adminRequests: make(chan adminRequest),
+		removeGame:    make(chan string, 64),
+		quit:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 }
 
-func (h *Hub) run() {
+// run is the hub loop. It returns, closing every connection, when ctx is
+// cancelled or shutdown is called.
+func (h *Hub) run(ctx context.Context) {
 	// Challenge expiration ticker - runs every 1 second
 	challengeTicker := time.NewTicker(1 * time.Second)
 	defer challengeTicker.Stop()
 
+	h.running.Store(true)
+	defer close(h.stopped)
+	defer h.running.Store(false)
+
 	for {
 		select {
+		case <-ctx.Done():
+			h.closeAll()
+			return
+		case <-h.quit:
+			h.closeAll()
+			return
 		case client := <-h.register:
-			h.clients[client] = true
-			h.handleConnect(client)
+			h.handleRegister(client)
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				h.handleDisconnect(client)
-				delete(h.clients, client)
-				close(client.send)
-			}
+			h.handleUnregister(client)
 		case wrapper := <-h.handleMessage:
-			h.handleClientMessage(wrapper.client, wrapper.message)
+			if wrapper.rateLimited {
+				h.sendClientError(wrapper.client, ErrRateLimited)
+				break
+			}
+			if wrapper.badMessage {
+				h.sendClientError(wrapper.client, ErrBadMessage)
+				break
+			}
+			if wrapper.client.user == nil {
+				// Waitlisted: nothing can be done before the connection is admitted
+				h.logger.Debug("message_dropped", "type", wrapper.message.Type, "reason", "waitlisted")
+			} else {
+				h.handleClientMessage(wrapper.client, wrapper.message)
+			}
+			if h.config.PoolMessages {
+				releaseMessage(wrapper.message)
+			}
+		case ev := <-h.federationIn:
+			if h.federator != nil {
+				h.federator.handleEvent(ev)
+			}
+		case reply := <-h.statsRequests:
+			reply <- h.collectStats()
+		case reply := <-h.liveGamesRequests:
+			reply <- h.collectLiveGames()
+		case req := <-h.adminRequests:
+			req.reply <- h.applyAdminRequest(req)
+		case gameID := <-h.removeGame:
+			h.deleteFinishedGame(gameID)
 		case <-challengeTicker.C:
 			h.checkExpiredChallenges()
+			h.checkExpiredRooms()
+			h.pruneRematches()
+			h.checkRevealTimeouts()
+			h.checkBidTimeouts()
+			h.sendThinkingPulses()
+			h.runBidAssistants()
+			h.checkPauseTimeouts()
+			h.checkIdleUsers()
+			h.checkQueueTimeouts()
+			h.checkAbsentUsers()
+		case <-h.userListPending:
+			h.userListPending = nil
+			h.flushUserList()
+		}
+	}
+}
+
+// shutdown stops the hub loop and returns once it has exited. Connections
+// are told server_shutdown and closed, and games in progress are aborted.
+// run must have been started.
+func (h *Hub) shutdown() {
+	h.quitOnce.Do(func() { close(h.quit) })
+	<-h.stopped
+}
+
+// closeAll aborts games in progress and closes every connection, including
+// waitlisted ones. It runs on the hub goroutine as run() exits.
+func (h *Hub) closeAll() {
+	for _, game := range h.games {
+		if !game.GameOver {
+			h.abortGame(game, ReasonServerShutdown)
+		}
+	}
+
+	shutdownMsg := Message{Type: "server_shutdown"}
+	for _, client := range append(h.waitlist, h.clientList()...) {
+		h.sendToClient(client, &shutdownMsg)
+		client.closeReason = "server shutting down"
+		close(client.send)
+	}
+	h.clients = make(map[*Client]bool)
+	h.waitlist = nil
+	h.logger.Info("hub_stopped")
+}
+
+// clientList returns the admitted connections
+func (h *Hub) clientList() []*Client {
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// handleRegister admits a new connection, or waitlists or rejects it when
+// the server is at MaxConnections
+func (h *Hub) handleRegister(client *Client) {
+	if client.registered != nil {
+		defer close(client.registered)
+	}
+	if client.protocol != 0 && (client.protocol < MinProtocolVersion || client.protocol > ProtocolVersion) {
+		h.sendToClient(client, &Message{Type: "error", Error: translate(normalizeLocale(client.locale), ErrVersionMismatch), ErrorCode: ErrVersionMismatch, ProtocolVersion: ProtocolVersion})
+		client.closeReason = CloseVersionMismatch
+		client.rejected = true
+		close(client.send)
+		h.logger.Info("connection_rejected", "reason", ErrVersionMismatch, "protocol", client.protocol)
+		return
+	}
+	if client.authError != "" {
+		// The close reason doubles as the error code
+		h.sendToClient(client, &Message{Type: "error", Error: translate(normalizeLocale(client.locale), client.authError), ErrorCode: client.authError})
+		client.closeReason = client.authError
+		client.rejected = true
+		close(client.send)
+		h.logger.Info("connection_rejected", "reason", client.authError)
+		return
+	}
+	if h.config.MaxConnections > 0 && len(h.clients) >= h.config.MaxConnections {
+		if len(h.waitlist) >= h.config.WaitlistSize {
+			h.sendToClient(client, &Message{Type: "error", Error: translate(normalizeLocale(client.locale), ErrServerFull), ErrorCode: ErrServerFull})
+			client.rejected = true
+			close(client.send)
+			h.logger.Info("connection_rejected", "reason", ErrServerFull)
+			return
+		}
+		h.waitlist = append(h.waitlist, client)
+		h.sendToClient(client, &Message{Type: "waitlisted", Position: len(h.waitlist)})
+		h.logger.Info("connection_waitlisted", "position", len(h.waitlist))
+		return
+	}
+
+	h.clients[client] = true
+	h.handleConnect(client)
+}
+
+// handleUnregister drops a connection and promotes the next waitlisted
+// client into the freed slot
+func (h *Hub) handleUnregister(client *Client) {
+	if _, ok := h.clients[client]; ok {
+		h.handleDisconnect(client)
+		delete(h.clients, client)
+		close(client.send)
+
+		if len(h.waitlist) > 0 {
+			next := h.waitlist[0]
+			h.waitlist = h.waitlist[1:]
+			h.clients[next] = true
+			h.handleConnect(next)
+			h.sendWaitlistPositions()
+		}
+		return
+	}
+
+	for i, waiting := range h.waitlist {
+		if waiting == client {
+			h.waitlist = append(h.waitlist[:i], h.waitlist[i+1:]...)
+			close(client.send)
+			h.sendWaitlistPositions()
+			return
+		}
+	}
+}
+
+// disconnectClient drops a connection from the server side, closing the
+// socket with the given reason
+func (h *Hub) disconnectClient(client *Client, reason string) {
+	client.closeReason = reason
+	h.handleUnregister(client)
+}
+
+// checkIdleUsers disconnects lobby users who haven't sent anything within
+// the idle timeout, telling them why first. Users playing, spectating or
+// waiting for a quick match are never considered idle.
+func (h *Hub) checkIdleUsers() {
+	if h.config.LobbyIdleTimeout <= 0 {
+		return
+	}
+	now := h.now()
+	for client := range h.clients {
+		user := client.user
+		if user == nil || user.InGame || h.isSpectating(client) || h.isQueued(user) {
+			continue
 		}
+		if now.Sub(user.LastActive) > h.config.LobbyIdleTimeout {
+			h.logger.Info("user_idle", "user_id", user.ID, "user", user.Username)
+			h.sendToClient(client, &Message{Type: "kicked_idle", Elapsed: int(h.config.LobbyIdleTimeout / time.Second)})
+			h.disconnectClient(client, CloseIdleTimeout)
+		}
+	}
+}
+
+// sendWaitlistPositions tells every waitlisted client its current position
+func (h *Hub) sendWaitlistPositions() {
+	for i, client := range h.waitlist {
+		h.sendToClient(client, &Message{Type: "waitlisted", Position: i + 1})
 	}
 }
 
 func (h *Hub) handleConnect(client *Client) {
-	username := GenerateRandomName()
+	profile := h.clientProfile(client)
+	username := generateUniqueName(h.generateName, h.isUsernameTaken, h.config.NameAttempts)
+	// An account keeps its name across sessions while nobody else holds it
+	if client.account != "" && profile != nil && profile.Username != "" && !h.isUsernameTaken(profile.Username) {
+		username = profile.Username
+	}
 	userID := uuid.New().String()
 
 	user := &User{
@@ -64,31 +323,100 @@ func (h *Hub) handleConnect(client *Client) {
 		Username: username,
 		Client:   client,
 		InGame:   false,
+		GameIDs:  make(map[string]bool),
+		LastActive: h.now(),
+		Locale:   normalizeLocale(client.locale),
+		Lobby:    DEFAULT_LOBBY,
+		Profile:  profile,
+		Session:  h.sessionStatsFor(profile),
 	}
 	client.user = user
 	h.users[userID] = user
-
-	// Send welcome message
-	msg := Message{
-		Type:     "welcome",
-		UserID:   userID,
-		Username: username,
+	if user.Profile != nil && user.Profile.Username != username {
+		user.Profile.Username = username
+		if err := h.store.SaveProfile(user.Profile); err != nil {
+			h.logger.Error("profile_save_failed", "profile_id", user.Profile.ID, "error", err)
+		}
 	}
-	h.sendToClient(client, &msg)
+
+	h.sendWelcome(user)
+
+	// Lifecycle: a fresh session, as opposed to a reconnect
+	h.sendToClient(client, &Message{Type: LifecycleConnected, UserID: userID})
 
 	// Broadcast updated user list
 	h.broadcastUserList()
 
-	log.Printf("User connected: %s (%s)", username, userID)
+	h.logger.Info("user_connect", "user_id", userID, "user", username)
+}
+
+// sendWelcome greets a new or resumed session with who the user is and a
+// fresh session token. A user who is still in a live game is pointed back
+// to it, so the client can open the board instead of the lobby.
+func (h *Hub) sendWelcome(user *User) {
+	h.sendToUser(user, &Message{
+		Type:            "welcome",
+		UserID:          user.ID,
+		Username:        user.Username,
+		Token:           issueToken(h.config.TokenSecret, user.ID, h.now().Add(h.config.TokenTTL)),
+		Rating:          user.rating(),
+		SessionStats:    user.Session,
+		LadderRung:      user.ladderRung(),
+		ActiveGameID:    h.activeGameID(user),
+		ProtocolVersion: ProtocolVersion,
+	})
+}
+
+// activeGameID returns the user's live game, the most recently started one
+// if there are several, or "" if they aren't playing
+func (h *Hub) activeGameID(user *User) string {
+	var active *Game
+	for _, game := range h.games {
+		if liveOpponent(game, user) != nil && (active == nil || game.StartTime.After(active.StartTime)) {
+			active = game
+		}
+	}
+	if active == nil {
+		return ""
+	}
+	return active.ID
 }
 
+// handleDisconnect handles a dropped connection. A player in a live game
+// is only marked absent for ReconnectGrace so they can resume; everyone else
+// is removed at once.
 func (h *Hub) handleDisconnect(client *Client) {
+	h.removeSpectator(client)
 	if client.user == nil {
 		return
 	}
 
 	user := client.user
-	log.Printf("User disconnected: %s (%s)", user.Username, user.ID)
+	if h.config.ReconnectGrace > 0 && user.Peer == "" && h.hasLiveGame(user) {
+		h.logger.Info("user_disconnect", "user_id", user.ID, "user", user.Username, "awaiting_reconnect", true)
+		h.dequeueMatch(user)
+		h.removeRooms(user)
+		user.Client = nil
+		user.AbsentSince = h.now()
+		h.removeChallenges(user)
+		for _, game := range h.games {
+			if opponent := liveOpponent(game, user); opponent != nil {
+				h.sendToUser(opponent, &Message{Type: "opponent_absent", GameID: game.ID})
+			}
+		}
+		return
+	}
+
+	h.logger.Info("user_disconnect", "user_id", user.ID, "user", user.Username)
+	h.removeUser(user)
+}
+
+// removeUser ends the user's games, challenges and rooms, takes them out of
+// the quick-match queue and drops them from the lobby
+func (h *Hub) removeUser(user *User) {
+	h.dequeueMatch(user)
+	h.removeRooms(user)
+	user.AutoSpectateOnEnd = false // Nothing to watch from: don't attach them when their games end
 
 	// Remove user from active games
 	for gameID, game := range h.games {
@@ -102,39 +430,217 @@ func (h *Hub) handleDisconnect(client *Client) {
 			}
 
 			if opponent != nil && !game.GameOver {
-				opponent.InGame = false
 				msg := Message{
 					Type:   "opponent_disconnected",
 					GameID: gameID,
 				}
 				h.sendToUser(opponent, &msg)
+				if game.rated() {
+					// Leaving a rated game loses it, so dropping out can't dodge a rating loss
+					h.finishGame(game, playerNumber(game, opponent), ReasonOpponentLeft)
+					continue
+				}
+				opponent.leaveGame(gameID)
+				if game.Series != nil && !game.Series.Over {
+					h.endSeries(game, playerNumber(game, opponent))
+				}
 			}
 
 			delete(h.games, gameID)
+			delete(h.spectators, gameID)
 		}
 	}
 
-	// Remove pending challenges
+	h.removeChallenges(user)
+
+	delete(h.users, user.ID)
+	h.broadcastUserList()
+}
+
+// removeChallenges withdraws every pending challenge from or to the user
+func (h *Hub) removeChallenges(user *User) {
 	for challengeID, challenge := range h.challenges {
-		if challenge.FromUser.ID == user.ID || challenge.ToUser.ID == user.ID {
-			// Notify the other party if it's the recipient
-			if challenge.FromUser.ID == user.ID && challenge.ToUser != nil {
+		if challenge.FromUser.ID == user.ID || (challenge.ToUser != nil && challenge.ToUser.ID == user.ID) {
+			// Notify whichever party remains, or the lobby for an open challenge.
+			// Username is always the recipient, as in an expiry.
+			if challenge.ToUser == nil {
+				h.sendToLobby(challenge.Lobby, &Message{Type: "challenge_cancelled", ChallengeID: challengeID}, user)
+			} else {
+				remaining := challenge.ToUser
+				if challenge.ToUser.ID == user.ID {
+					remaining = challenge.FromUser
+				}
 				expireMsg := Message{
 					Type:     "challenge_expired",
 					ChallengeID: challengeID,
 					Username: challenge.ToUser.Username,
 				}
-				h.sendToUser(challenge.ToUser, &expireMsg)
+				h.sendToUser(remaining, &expireMsg)
 			}
+			h.logChallenge(challenge, ChallengeCancelled)
 			delete(h.challenges, challengeID)
 		}
 	}
 
-	delete(h.users, user.ID)
+}
+
+// hasLiveGame reports whether the user is playing an unfinished game
+func (h *Hub) hasLiveGame(user *User) bool {
+	for _, game := range h.games {
+		if liveOpponent(game, user) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// liveOpponent returns the user's opponent in an unfinished game, or nil if
+// the user isn't playing it
+func liveOpponent(game *Game, user *User) *User {
+	if game.GameOver {
+		return nil
+	}
+	if game.Player1.ID == user.ID {
+		return game.Player2
+	} else if game.Player2.ID == user.ID {
+		return game.Player1
+	}
+	return nil
+}
+
+// checkAbsentUsers removes players who didn't reconnect within ReconnectGrace,
+// ending their games
+func (h *Hub) checkAbsentUsers() {
+	now := h.now()
+	for _, user := range h.users {
+		if !user.AbsentSince.IsZero() && now.Sub(user.AbsentSince) >= h.config.ReconnectGrace {
+			h.logger.Info("reconnect_expired", "user_id", user.ID, "user", user.Username)
+			h.removeUser(user)
+		}
+	}
+}
+
+// handleReconnect re-attaches a new connection to the absent user named by a
+// session token, in place of the fresh user created for the connection
+func (h *Hub) handleReconnect(client *Client, msg *Message) {
+	fresh := client.user
+	userID, err := verifyToken(h.config.TokenSecret, msg.Token, h.now())
+	if err == ErrTokenExpired {
+		h.sendToClient(client, &Message{Type: LifecycleSessionExpired})
+		h.sendError(fresh, ErrSessionExpired)
+		return
+	} else if err != nil {
+		h.sendError(fresh, ErrSessionInvalid)
+		return
+	}
+
+	user, exists := h.users[userID]
+	if !exists || user.Peer != "" {
+		// The grace period ran out and the session is gone
+		h.sendToClient(client, &Message{Type: LifecycleSessionExpired})
+		return
+	}
+	if user == fresh {
+		return
+	}
+	h.resumeSession(client, user)
+}
+
+// handleRejoinGame re-attaches a new connection to a player who dropped out
+// of a live game, named by the game and their user ID, for clients that
+// have no session token to resume with. Only a player still in the
+// reconnect grace period can be rejoined, and a logged-in player only from
+// a connection with the same profile.
+func (h *Hub) handleRejoinGame(client *Client, msg *Message) {
+	fresh := client.user
+	game, exists := h.games[msg.GameID]
+	if !exists || game.GameOver {
+		h.sendError(fresh, ErrGameNotLive)
+		return
+	}
+	var user *User
+	if game.Player1.ID == msg.UserID {
+		user = game.Player1
+	} else if game.Player2.ID == msg.UserID {
+		user = game.Player2
+	}
+	if user == nil || user == fresh || user.Peer != "" || user.Client != nil || user.AbsentSince.IsZero() ||
+		(user.Profile != nil && user.Profile != fresh.Profile) {
+		h.sendError(fresh, ErrCannotRejoin)
+		return
+	}
+	h.resumeSession(client, user)
+}
+
+// resumeSession moves a connection from the fresh user created for it to
+// an existing user, and brings it up to date with the user's games
+func (h *Hub) resumeSession(client *Client, user *User) {
+	fresh := client.user
+
+	// A reconnect can beat the server noticing the old connection dropped
+	if old := user.Client; old != nil {
+		old.user = nil
+		h.handleUnregister(old)
+	}
+
+	h.removeUser(fresh)
+	client.user = user
+	user.Client = client
+	user.AbsentSince = time.Time{}
+	user.LastActive = h.now()
+
+	h.sendWelcome(user)
+	h.sendToClient(client, &Message{Type: LifecycleReconnected, UserID: user.ID})
+
+	// Bring the board back up to date
+	for _, game := range h.games {
+		opponent := liveOpponent(game, user)
+		if opponent == nil {
+			continue
+		}
+		h.sendToUser(opponent, &Message{Type: "opponent_reconnected", GameID: game.ID})
+		if game.Status == "WAITING_FOR_BIDS" {
+			h.sendWaitingForBids(game)
+		}
+		h.sendBoardState(game, user)
+	}
+
 	h.broadcastUserList()
+	h.logger.Info("user_reconnect", "user_id", user.ID, "user", user.Username)
 }
 
 func (h *Hub) handleClientMessage(client *Client, msg *Message) {
+	// A bug in one handler must not take down the hub goroutine
+	defer func() {
+		if r := recover(); r != nil {
+			username := ""
+			if client.user != nil {
+				username = client.user.Username
+			}
+			h.logger.Error("panic", "type", msg.Type, "user", username, "error", r, "stack", string(debug.Stack()))
+			if client.user != nil {
+				h.sendError(client.user, ErrInternal)
+			}
+		}
+	}()
+
+	if h.config.WireLog {
+		h.logWire("in", client.user, msg)
+	}
+
+	if client.user != nil {
+		client.user.LastActive = h.now()
+	}
+
+	if h.messageHook != nil {
+		h.messageHook(client, msg)
+	}
+
+	// Messages about a challenge or game hosted on a peer server go there
+	if h.federator != nil && client.user != nil && client.user.Peer == "" && h.federator.forward(client.user, msg) {
+		return
+	}
+
 	switch msg.Type {
 	case "challenge":
 		h.handleChallenge(client.user, msg)
@@ -142,129 +648,402 @@ func (h *Hub) handleClientMessage(client *Client, msg *Message) {
 		h.handleAcceptChallenge(client.user, msg)
 	case "decline_challenge":
 		h.handleDeclineChallenge(client.user, msg)
+	case "open_challenge":
+		h.handleOpenChallenge(client.user, msg)
+	case "cancel_challenge":
+		h.handleCancelChallenge(client.user, msg)
 	case "submit_bid":
 		h.handleSubmitBid(client.user, msg)
 	case "rematch":
 		h.handleRematch(client.user, msg)
+	case "accept_rematch":
+		h.handleAcceptRematch(client.user, msg)
+	case "decline_rematch":
+		h.handleDeclineRematch(client.user, msg)
 	case "resign":
 		h.handleResign(client.user, msg)
+	case "reveal_done":
+		h.handleRevealDone(client.user, msg)
+	case "set_auto_fold":
+		h.handleSetAutoFold(client.user, msg)
+	case "set_auto_spectate_on_end":
+		h.handleSetAutoSpectate(client.user, msg)
+	case "offer_draw":
+		h.handleOfferDraw(client.user, msg)
+	case "accept_draw":
+		h.handleAcceptDraw(client.user, msg)
+	case "decline_draw":
+		h.handleDeclineDraw(client.user, msg)
+	case "request_pause":
+		h.handleRequestPause(client.user, msg)
+	case "accept_pause":
+		h.handleAcceptPause(client.user, msg)
+	case "decline_pause":
+		h.handleDeclinePause(client.user, msg)
+	case "rejoin_game":
+		h.handleRejoinGame(client, msg)
+	case "resume":
+		// With a session token this resumes a dropped connection, otherwise a paused game
+		if msg.Token != "" {
+			h.handleReconnect(client, msg)
+		} else {
+			h.handleResume(client.user, msg)
+		}
+	case "chat":
+		h.handleChat(client, msg)
+	case "emote":
+		h.handleEmote(client.user, msg)
+	case "create_room":
+		h.handleCreateRoom(client.user, msg)
+	case "join_room":
+		h.handleJoinRoom(client.user, msg)
+	case "leaderboard":
+		h.handleLeaderboard(client, msg)
+	case "quick_match":
+		h.handleQuickMatch(client.user)
+	case "leave_queue":
+		h.dequeueMatch(client.user)
+	case "spectate":
+		h.handleSpectate(client, msg)
+	case "challenge_bot":
+		h.handleChallengeBot(client.user, msg)
+	case "play_ladder":
+		h.handlePlayLadder(client.user)
+	case "play_ghost":
+		h.handlePlayGhost(client.user, msg)
+	case "set_username":
+		h.handleSetUsername(client.user, msg)
+	case "set_bid_rule":
+		h.handleSetBidRule(client.user, msg)
+	case "join_lobby":
+		h.handleJoinLobby(client.user, msg)
+	case "leave_lobby":
+		h.handleJoinLobby(client.user, &Message{Lobby: DEFAULT_LOBBY})
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		h.logger.Warn("unknown_message", "type", msg.Type)
 	}
 }
 
 // Challenge handlers
 
+// handleChallenge sends a challenge to the user given by TargetUserID or,
+// without one, by TargetUsername
 func (h *Hub) handleChallenge(from *User, msg *Message) {
 	to, exists := h.users[msg.TargetUserID]
-	if !exists {
-		log.Printf("Target user not found: %s", msg.TargetUserID)
+	if msg.TargetUserID == "" && msg.TargetUsername != "" {
+		var code string
+		if to, code = h.findUserByName(msg.TargetUsername); code != "" {
+			h.sendError(from, code)
+			return
+		}
+	} else if !exists {
+		h.logger.Info("challenge_target_not_found", "target_user_id", msg.TargetUserID, "target_username", msg.TargetUsername)
+		return
+	}
+	if to.ID == from.ID {
+		h.sendError(from, ErrSelfChallenge)
 		return
 	}
 
-	if to.InGame {
-		h.sendError(from, "User is already in a game")
+	if !h.canJoinGame(to) {
+		h.sendError(from, ErrUserInGame)
 		return
 	}
 
 	// Check for existing pending challenges from this user to the target
 	for _, c := range h.challenges {
-		if c.FromUser.ID == from.ID && c.ToUser.ID == to.ID {
-			h.sendError(from, "You already have a pending challenge to this user")
+		if c.FromUser.ID == from.ID && c.ToUser != nil && c.ToUser.ID == to.ID {
+			h.sendError(from, ErrChallengePending)
 			return
 		}
 	}
 
+	note := sanitizeText(msg.Note)
+	if utf8.RuneCountInString(note) > MAX_NOTE_LENGTH {
+		h.sendError(from, ErrNoteTooLong)
+		return
+	}
+
+	settings := GameSettings{
+		RoundWinTarget: msg.RoundWinTarget,
+		BestOf:         msg.BestOf,
+		GameMode:       msg.GameMode,
+		HideBalance:    msg.HideBalance,
+		MaxSteps:       msg.MaxSteps,
+		InitialBudget:  msg.InitialBudget,
+	}
+	if code := settings.validate(h.config.SuddenDeath); code != "" {
+		h.sendError(from, code)
+		return
+	}
+	expiry, code := h.requestedExpiry(msg)
+	if code != "" {
+		h.sendError(from, code)
+		return
+	}
+
 	challengeID := uuid.New().String()
 	challenge := &Challenge{
 		ID:        challengeID,
 		FromUser:  from,
 		ToUser:    to,
-		Timestamp: time.Now(),
+		Timestamp: h.now(),
+		Note:      note,
+		Settings:  settings,
+		Expiry:    expiry,
 	}
 	h.challenges[challengeID] = challenge
+	h.logChallenge(challenge, ChallengeSent)
 
 	// Send challenge notification to target user
 	challengeMsg := Message{
 		Type:         "challenge_received",
 		ChallengeID:  challengeID,
 		FromUserID:   from.ID,
-		FromUsername: from.Username,
+		FromUsername:  from.Username,
+		Note:          challenge.Note,
+		MaxSteps:      settings.MaxSteps,
+		InitialBudget: settings.InitialBudget,
+		BestOf:        settings.BestOf,
+		GameMode:      settings.GameMode,
+		HideBalance:   settings.HideBalance,
+		ExpirySeconds: int(h.challengeExpiry(challenge) / time.Second),
 	}
 	h.sendToUser(to, &challengeMsg)
 
-	log.Printf("Challenge created: %s -> %s", from.Username, to.Username)
+	h.logger.Info("challenge_create", "challenge_id", challenge.ID, "from", from.Username, "to", to.Username)
 }
 
 func (h *Hub) handleAcceptChallenge(user *User, msg *Message) {
 	challenge, exists := h.challenges[msg.ChallengeID]
 	if !exists {
-		log.Printf("Challenge not found: %s", msg.ChallengeID)
+		// Clients accept a rematch_received as the challenge "rematch-<gameID>"
+		if gameID, isRematch := strings.CutPrefix(msg.ChallengeID, "rematch-"); isRematch {
+			h.handleRematch(user, &Message{GameID: gameID})
+			return
+		}
+		h.logger.Info("challenge_not_found", "challenge_id", msg.ChallengeID)
+		return
+	}
+
+	if challenge.ToUser == nil {
+		// Open challenge: the first eligible user to accept claims it
+		if challenge.FromUser.ID == user.ID || user.Lobby != challenge.Lobby {
+			return
+		}
+		if !h.canJoinGame(user) || !h.canJoinGame(challenge.FromUser) {
+			h.sendError(user, ErrUserInGame)
+			return
+		}
+		challenge.ToUser = user
+		h.sendToLobby(challenge.Lobby, &Message{Type: "challenge_taken", ChallengeID: challenge.ID}, challenge.FromUser, user)
+	} else if challenge.ToUser.ID != user.ID {
+		h.logger.Warn("challenge_wrong_recipient", "challenge_id", challenge.ID, "user", user.Username)
+		return
+	} else if challenge.FromUser.ID == user.ID {
+		// handleChallenge refuses these; never start a game against oneself
+		delete(h.challenges, challenge.ID)
+		h.sendError(user, ErrSelfChallenge)
+		return
+	} else if !h.canJoinGame(user) || !h.canJoinGame(challenge.FromUser) {
+		// Either side may have started another game since the challenge
+		// was sent
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	// Consume the challenge before the game starts, so a repeated accept
+	// (double click, client retry) finds nothing left to accept
+	delete(h.challenges, challenge.ID)
+	h.logChallenge(challenge, ChallengeAccepted)
+	game := h.createGame(challenge.FromUser, challenge.ToUser, challenge.Settings, nil)
+
+	// Broadcast updated user list
+	h.broadcastUserList()
+
+	h.logger.Info("game_start", "game_id", game.ID, "player1", challenge.FromUser.Username, "player2", challenge.ToUser.Username, "challenge_id", challenge.ID)
+}
+
+// handleOpenChallenge creates a challenge anyone in the lobby can accept and
+// announces it to everyone else
+func (h *Hub) handleOpenChallenge(from *User, msg *Message) {
+	if !h.canJoinGame(from) {
+		h.sendError(from, ErrUserInGame)
+		return
+	}
+
+	// One open challenge per user at a time
+	for _, c := range h.challenges {
+		if c.FromUser.ID == from.ID && c.ToUser == nil {
+			h.sendError(from, ErrChallengePending)
+			return
+		}
+	}
+
+	note := sanitizeText(msg.Note)
+	if utf8.RuneCountInString(note) > MAX_NOTE_LENGTH {
+		h.sendError(from, ErrNoteTooLong)
+		return
+	}
+
+	settings := GameSettings{
+		RoundWinTarget: msg.RoundWinTarget,
+		BestOf:         msg.BestOf,
+		GameMode:       msg.GameMode,
+		HideBalance:    msg.HideBalance,
+		MaxSteps:       msg.MaxSteps,
+		InitialBudget:  msg.InitialBudget,
+	}
+	if code := settings.validate(h.config.SuddenDeath); code != "" {
+		h.sendError(from, code)
+		return
+	}
+	expiry, code := h.requestedExpiry(msg)
+	if code != "" {
+		h.sendError(from, code)
 		return
 	}
 
-	if challenge.ToUser.ID != user.ID {
-		log.Printf("User %s tried to accept challenge not meant for them", user.Username)
+	challenge := &Challenge{
+		ID:        uuid.New().String(),
+		FromUser:  from,
+		Lobby:     from.Lobby,
+		Timestamp: h.now(),
+		Note:      note,
+		Settings:  settings,
+		Expiry:    expiry,
+	}
+	h.challenges[challenge.ID] = challenge
+	h.logChallenge(challenge, ChallengeSent)
+
+	h.sendToUser(from, &Message{Type: "open_challenge_created", ChallengeID: challenge.ID})
+	h.sendToLobby(challenge.Lobby, &Message{
+		Type:           "open_challenge_available",
+		ChallengeID:    challenge.ID,
+		FromUserID:     from.ID,
+		FromUsername:   from.Username,
+		Note:           note,
+		RoundWinTarget: settings.RoundWinTarget,
+		MaxSteps:       settings.MaxSteps,
+		InitialBudget:  settings.InitialBudget,
+		BestOf:         settings.BestOf,
+		GameMode:       settings.GameMode,
+		HideBalance:    settings.HideBalance,
+		ExpirySeconds:  int(h.challengeExpiry(challenge) / time.Second),
+	}, from)
+
+	h.logger.Info("challenge_create", "challenge_id", challenge.ID, "from", from.Username, "open", true)
+}
+
+// handleCancelChallenge lets the challenger withdraw a pending challenge
+func (h *Hub) handleCancelChallenge(user *User, msg *Message) {
+	challenge, exists := h.challenges[msg.ChallengeID]
+	if !exists || challenge.FromUser.ID != user.ID {
+		h.sendError(user, ErrUnknownChallenge)
 		return
 	}
 
-	// Create new game
+	cancelMsg := Message{Type: "challenge_cancelled", ChallengeID: challenge.ID}
+	if challenge.ToUser == nil {
+		h.sendToLobby(challenge.Lobby, &cancelMsg, user)
+	} else {
+		h.sendToUser(challenge.ToUser, &cancelMsg)
+	}
+
+	h.logChallenge(challenge, ChallengeCancelled)
+	delete(h.challenges, challenge.ID)
+	h.logger.Info("challenge_cancel", "challenge_id", challenge.ID, "from", user.Username)
+}
+
+// createGame starts a new game between two users, sends game_start to both
+// and opens the first round. The game continues the given series, or starts
+// a new one when nil and settings ask for a best-of-N.
+func (h *Hub) createGame(player1, player2 *User, settings GameSettings, series *Series) *Game {
 	gameID := uuid.New().String()
+	seed := rand.Int63()
 	game := &Game{
 		ID:             gameID,
-		Player1:        challenge.FromUser,
-		Player2:        challenge.ToUser,
+		Player1:        player1,
+		Player2:        player2,
 		Turn:           1,
 		CurrentRound:   1,
 		Status:         "WAITING_FOR_BIDS",
 		Player1Pos:     0,
 		Player2Pos:     0,
-		Player1Balance: INITIAL_BUDGET,
-		Player2Balance: INITIAL_BUDGET,
 		Player1Bid:     nil,
 		Player2Bid:     nil,
 		GameOver:       false,
 		Winner:         0,
 		History:        []RoundHistory{},
-		StartTime:      time.Now(),
+		Settings:       settings,
+		MaxRounds:      h.config.MaxRounds,
+		Seed:           seed,
+		EventCards:     h.config.EventCards,
+		eventRNG:       newEventRNG(seed),
+		StartTime:      h.now(),
+	}
+	if game.Settings.MaxSteps == 0 {
+		game.Settings.MaxSteps = h.config.MaxSteps
+	}
+	if game.Settings.InitialBudget == 0 {
+		game.Settings.InitialBudget = h.config.InitialBudget
+	}
+	game.Player1Balance = game.initialBudget()
+	game.Player2Balance = game.initialBudget()
+	game.Player1Color, game.Player2Color = gameColors(h.config.Palette, player1.ID, player2.ID)
+	if series == nil && settings.BestOf > 1 {
+		series = &Series{BestOf: settings.BestOf, Settings: settings, Decider: h.deciderSettings(settings)}
+	}
+	if series != nil {
+		series.Games++
+		game.Series = series
 	}
 	h.games[gameID] = game
 
 	// Mark users as in game
-	challenge.FromUser.InGame = true
-	challenge.FromUser.GameID = gameID
-	challenge.ToUser.InGame = true
-	challenge.ToUser.GameID = gameID
+	player1.joinGame(gameID)
+	player2.joinGame(gameID)
+	for _, player := range []*User{player1, player2} {
+		if !h.canJoinGame(player) {
+			h.dequeueMatch(player)
+		}
+	}
 
 	// Send game start to both players
+	config := h.gameConfig(game)
 	p1Msg := Message{
 		Type:             "game_start",
 		GameID:           gameID,
-		OpponentID:       challenge.ToUser.ID,
-		OpponentUsername: challenge.ToUser.Username,
+		OpponentID:       player2.ID,
+		OpponentUsername: player2.Username,
 		YourPlayer:       1,
+		GameConfig:       &config,
+		Color:            game.Player1Color,
+		OpponentColor:    game.Player2Color,
+		Series:           series.score(),
 	}
-	h.sendToUser(challenge.FromUser, &p1Msg)
+	h.sendToUser(player1, &p1Msg)
 
 	p2Msg := Message{
 		Type:             "game_start",
 		GameID:           gameID,
-		OpponentID:       challenge.FromUser.ID,
-		OpponentUsername: challenge.FromUser.Username,
+		OpponentID:       player1.ID,
+		OpponentUsername: player1.Username,
 		YourPlayer:       2,
+		GameConfig:       &config,
+		Color:            game.Player2Color,
+		OpponentColor:    game.Player1Color,
+		Series:           series.score(),
 	}
-	h.sendToUser(challenge.ToUser, &p2Msg)
+	h.sendToUser(player2, &p2Msg)
 
 	// Send initial waiting_for_bids state to both
-	h.sendWaitingForBids(game)
-
-	// Clean up challenge
-	delete(h.challenges, msg.ChallengeID)
-
-	// Broadcast updated user list
-	h.broadcastUserList()
-
-	log.Printf("Game started: %s vs %s (Game ID: %s)", challenge.FromUser.Username, challenge.ToUser.Username, gameID)
+	h.openRound(game)
+	if !game.GameOver {
+		h.sendBoardState(game, player1)
+		h.sendBoardState(game, player2)
+	}
+	return game
 }
 
 func (h *Hub) handleDeclineChallenge(user *User, msg *Message) {
@@ -273,7 +1052,7 @@ func (h *Hub) handleDeclineChallenge(user *User, msg *Message) {
 		return
 	}
 
-	if challenge.ToUser.ID != user.ID {
+	if challenge.ToUser == nil || challenge.ToUser.ID != user.ID {
 		return
 	}
 
@@ -284,24 +1063,54 @@ func (h *Hub) handleDeclineChallenge(user *User, msg *Message) {
 	}
 	h.sendToUser(challenge.FromUser, &declineMsg)
 
+	h.logChallenge(challenge, ChallengeDeclined)
 	delete(h.challenges, msg.ChallengeID)
-	log.Printf("Challenge declined: %s declined %s", user.Username, challenge.FromUser.Username)
+	h.logger.Info("challenge_decline", "challenge_id", challenge.ID, "from", challenge.FromUser.Username, "to", user.Username)
+}
+
+// requestedExpiry returns the expiry a challenge message asks for, 0 for the
+// server default, or an error code when it is out of the allowed range
+func (h *Hub) requestedExpiry(msg *Message) (time.Duration, string) {
+	if msg.ExpirySeconds == 0 {
+		return 0, ""
+	}
+	if msg.ExpirySeconds < 0 || msg.ExpirySeconds > int(h.config.MaxChallengeExpiry/time.Second) {
+		return 0, ErrInvalidExpiry
+	}
+	expiry := time.Duration(msg.ExpirySeconds) * time.Second
+	if expiry < h.config.MinChallengeExpiry {
+		return 0, ErrInvalidExpiry
+	}
+	return expiry, ""
+}
+
+// challengeExpiry returns how long the challenge stays open
+func (h *Hub) challengeExpiry(challenge *Challenge) time.Duration {
+	if challenge.Expiry > 0 {
+		return challenge.Expiry
+	}
+	return h.config.ChallengeExpiry
 }
 
 func (h *Hub) checkExpiredChallenges() {
-	now := time.Now()
+	now := h.now()
 	for challengeID, challenge := range h.challenges {
-		if now.Sub(challenge.Timestamp) > CHALLENGE_EXPIRY*time.Second {
+		if now.Sub(challenge.Timestamp) > h.challengeExpiry(challenge) {
 			// Notify the sender that their challenge expired
 			expireMsg := Message{
 				Type:        "challenge_expired",
 				ChallengeID: challengeID,
-				Username:    challenge.ToUser.Username,
+			}
+			if challenge.ToUser != nil {
+				expireMsg.Username = challenge.ToUser.Username
+			} else {
+				h.sendToLobby(challenge.Lobby, &Message{Type: "challenge_cancelled", ChallengeID: challengeID}, challenge.FromUser)
 			}
 			h.sendToUser(challenge.FromUser, &expireMsg)
 
+			h.logChallenge(challenge, ChallengeExpired)
 			delete(h.challenges, challengeID)
-			log.Printf("Challenge expired: %s -> %s", challenge.FromUser.Username, challenge.ToUser.Username)
+			h.logger.Info("challenge_expire", "challenge_id", challengeID, "from", challenge.FromUser.Username, "to", expireMsg.Username)
 		}
 	}
 }
@@ -324,9 +1133,19 @@ func (h *Hub) handleSubmitBid(user *User, msg *Message) {
 		return
 	}
 
-	// Validate bid
-	if msg.Bid < 0 {
-		h.sendError(user, "Bid must be non-negative")
+	// A finished game stays around briefly for rematches; its state is final
+	if game.GameOver {
+		h.sendError(user, ErrGameOver)
+		return
+	}
+
+	// Bids are only accepted while the round is open
+	if game.Status == "PAUSED" {
+		h.sendError(user, ErrGamePaused)
+		return
+	}
+	if game.Status != "WAITING_FOR_BIDS" {
+		h.sendError(user, ErrRoundNotOpen)
 		return
 	}
 
@@ -338,196 +1157,739 @@ func (h *Hub) handleSubmitBid(user *User, msg *Message) {
 		balance = game.Player2Balance
 	}
 
-	if msg.Bid > balance {
-		h.sendError(user, "Bid exceeds your balance")
+	// A bid is either an absolute amount or a percentage of the balance
+	bid := msg.Bid
+	if msg.BidPercent != nil {
+		if msg.Bid != 0 {
+			h.sendError(user, ErrBidAndPercent)
+			return
+		}
+		if *msg.BidPercent < 0 || *msg.BidPercent > 100 {
+			h.sendError(user, ErrBidPercentRange)
+			return
+		}
+		bid = percentOfBalance(balance, *msg.BidPercent)
+	}
+
+	// Validate bid
+	if bid < 0 {
+		h.sendError(user, ErrBidNegative)
+		return
+	}
+
+	if bid > balance {
+		h.sendError(user, ErrBidExceedsBalance)
 		return
 	}
 
-	// Store bid
+	// Store bid, noting which player's bid the server received first. Until
+	// the opponent bids, a new submission replaces the player's earlier bid.
+	if game.FirstBidder == 0 {
+		game.FirstBidder = playerNum
+	}
+	changed := false
 	if playerNum == 1 {
-		bid := msg.Bid
+		changed = game.Player1Bid != nil
 		game.Player1Bid = &bid
 	} else {
-		bid := msg.Bid
+		changed = game.Player2Bid != nil
 		game.Player2Bid = &bid
 	}
 
-	log.Printf("Bid submitted in game %s: Player %d bid %d", game.ID, playerNum, msg.Bid)
+	if changed {
+		h.logger.Info("bid", "game_id", game.ID, "round", game.CurrentRound, "player", playerNum, "bid", bid, "changed", true)
+	} else {
+		h.logger.Info("bid", "game_id", game.ID, "round", game.CurrentRound, "player", playerNum, "bid", bid)
+	}
 
 	// Check if both bids are submitted
 	if game.Player1Bid != nil && game.Player2Bid != nil {
 		game.Status = "RESOLVING"
 		h.resolveRound(game)
+		return
 	}
-}
-
-func (h *Hub) resolveRound(game *Game) {
-	p1Bid := *game.Player1Bid
-	p2Bid := *game.Player2Bid
 
-	// Deduction (both lose their bid regardless of outcome)
-	game.Player1Balance -= p1Bid
-	game.Player2Balance -= p2Bid
-
-	// Movement determination
-	var result string
-	var p1NewPos = game.Player1Pos
-	var p2NewPos = game.Player2Pos
-
-	if p1Bid > p2Bid {
-		p1NewPos++
-		result = "P1_WINS_ROUND"
-	} else if p2Bid > p1Bid {
-		p2NewPos++
-		result = "P2_WINS_ROUND"
-	} else {
-		result = "DRAW"
+	if changed {
+		return // The opponent already knows a bid is in
 	}
 
-	// Update positions
-	game.Player1Pos = p1NewPos
-	game.Player2Pos = p2NewPos
-
-	// Record history
-	history := RoundHistory{
-		Turn:     game.CurrentRound,
-		P1Bid:    p1Bid,
-		P2Bid:    p2Bid,
-		P1NewPos: p1NewPos,
-		P2NewPos: p2NewPos,
-		Result:   result,
+	// Tell the opponent they're the one holding up the round, without the amount
+	opponent := game.Player2
+	if playerNum == 2 {
+		opponent = game.Player1
 	}
-	game.History = append(game.History, history)
+	h.sendToUser(opponent, &Message{Type: "bid_committed", GameID: game.ID, BidReady: playerNum})
+}
 
-	// Send round result to both players
-	resultMsg := Message{
-		Type:        "round_result",
-		GameID:      game.ID,
+// percentOfBalance converts a 0-100 percentage of balance to a bid, rounding down
+func percentOfBalance(balance, percent int) int {
+	return balance * percent / 100
+}
+
+func (h *Hub) resolveRound(game *Game) {
+	// Defensive: never resolve without both bids, reopen the round instead
+	if game.Player1Bid == nil || game.Player2Bid == nil {
+		h.logger.Error("missing_bid", "game_id", game.ID, "round", game.CurrentRound,
+			"p1_bid_set", game.Player1Bid != nil, "p2_bid_set", game.Player2Bid != nil)
+		game.Status = "WAITING_FOR_BIDS"
+		return
+	}
+
+	p1Bid := *game.Player1Bid
+	p2Bid := *game.Player2Bid
+
+	state := game.engineState()
+	outcome := gameengine.ResolveRound(h.engineRules(game), &state, p1Bid, p2Bid)
+	game.setEngineState(state)
+	result := outcome.Result
+	p1NewPos, p2NewPos := game.Player1Pos, game.Player2Pos
+	switch outcome.Grace {
+	case 1:
+		h.logger.Info("grace_bid", "game_id", game.ID, "user", game.Player1.Username)
+	case 2:
+		h.logger.Info("grace_bid", "game_id", game.ID, "user", game.Player2.Username)
+	}
+
+	// Record history
+	history := RoundHistory{
 		Turn:        game.CurrentRound,
 		P1Bid:       p1Bid,
 		P2Bid:       p2Bid,
-		P1Position:  p1NewPos,
-		P2Position:  p2NewPos,
-		P1Balance:   game.Player1Balance,
-		P2Balance:   game.Player2Balance,
+		P1NewPos:    p1NewPos,
+		P2NewPos:    p2NewPos,
 		Result:      result,
+		FirstBidder: game.FirstBidder,
+		TimedOut:    game.TimedOut,
 	}
-	h.sendToUser(game.Player1, &resultMsg)
-	h.sendToUser(game.Player2, &resultMsg)
+	game.History = append(game.History, history)
+
+	// Send round result to both players
+	h.sendGameState(game, "round_result", func(msg *Message) {
+		msg.P1Bid = p1Bid
+		msg.P2Bid = p2Bid
+		msg.Result = result
+		msg.TimedOut = game.TimedOut
+	})
 
-	log.Printf("Round %d result: P1 bid %d, P2 bid %d, Result: %s, Positions: P1=%d, P2=%d",
-		game.CurrentRound, p1Bid, p2Bid, result, p1NewPos, p2NewPos)
+	h.logger.Info("round_result", "game_id", game.ID, "round", game.CurrentRound,
+		"p1_bid", p1Bid, "p2_bid", p2Bid, "result", result, "p1_position", p1NewPos, "p2_position", p2NewPos)
 
 	// Check win condition
 	winner, reason := h.checkWinCondition(game)
 	if winner > 0 {
-		game.GameOver = true
-		game.Winner = winner
-		game.EndTime = time.Now()
-		game.Status = "GAME_OVER"
+		h.finishGame(game, winner, reason)
+	} else {
+		if h.config.RevealAckTimeout > 0 {
+			// Hold the next round until both clients finish the reveal
+			game.Status = "REVEALING"
+			game.RevealDeadline = h.now().Add(h.config.RevealAckTimeout)
+			game.Player1Revealed = false
+			game.Player2Revealed = game.Ghost != nil || game.Player2.IsBot // nothing to animate
+		} else {
+			h.startNextRound(game)
+		}
+	}
+}
 
-		endMsg := Message{
-			Type:   "game_end",
-			GameID: game.ID,
-			Winner: winner,
-			Reason: reason,
+// gameConfig describes the rules in effect for the game
+func (h *Hub) gameConfig(game *Game) GameConfig {
+	return GameConfig{
+		MaxSteps:         game.maxSteps(),
+		InitialBudget:    game.initialBudget(),
+		RoundWinTarget:   game.Settings.RoundWinTarget,
+		EventCards:       game.EventCards,
+		RevealAckSeconds: int(h.config.RevealAckTimeout / time.Second),
+		BidTimeoutSeconds: int(h.config.BidTimeout / time.Second),
+		MinTotalBid:      h.config.MinTotalBid,
+		MinTotalBidRound: h.config.MinTotalBidRound,
+		GraceBid:         h.config.GraceBid,
+		MaxRounds:        game.MaxRounds,
+		BestOf:           game.Settings.BestOf,
+		GameMode:         game.gameMode(),
+		HideBalance:      game.Settings.HideBalance,
+		TieBreaks:        h.config.TieBreaks,
+	}
+}
+
+// startNextRound advances the round counter and opens it for bidding
+func (h *Hub) startNextRound(game *Game) {
+	game.CurrentRound++
+	h.openRound(game)
+}
+
+// openRound clears the bids, draws the round's event card if the variant is
+// on, and asks both players for bids
+func (h *Hub) openRound(game *Game) {
+	h.expireDrawOffer(game)
+	h.expirePauseRequest(game)
+	game.Player1Bid = nil
+	game.Player2Bid = nil
+	game.FirstBidder = 0
+	game.TimedOut = 0
+	game.Status = "WAITING_FOR_BIDS"
+	game.RoundStart = h.now()
+	if h.config.BidTimeout > 0 {
+		game.BidDeadline = game.RoundStart.Add(h.config.BidTimeout)
+	}
+	game.LastThinkingPulse = time.Time{}
+	if game.EventCards {
+		game.Event = drawEvent(game.eventRNG)
+	}
+
+	// Send waiting for bids state
+	h.sendWaitingForBids(game)
+
+	h.autoFold(game)
+	h.playGhost(game)
+	h.playBot(game)
+}
+
+// autoFold submits a 0 bid for auto-fold players who can no longer reach the
+// finish while their opponent still can. If both are out of the race nobody
+// folds, so the game can still end by bankruptcy.
+func (h *Hub) autoFold(game *Game) {
+	p1Out := isEliminated(game.Player1Pos, game.Player1Balance, game.maxSteps())
+	p2Out := isEliminated(game.Player2Pos, game.Player2Balance, game.maxSteps())
+	if game.Player1.AutoFold && p1Out && !p2Out {
+		h.logger.Info("auto_fold", "game_id", game.ID, "user", game.Player1.Username)
+		h.handleSubmitBid(game.Player1, &Message{GameID: game.ID, Bid: 0})
+	} else if game.Player2.AutoFold && p2Out && !p1Out {
+		h.logger.Info("auto_fold", "game_id", game.ID, "user", game.Player2.Username)
+		h.handleSubmitBid(game.Player2, &Message{GameID: game.ID, Bid: 0})
+	}
+}
+
+// isEliminated reports whether a player can't reach the finish even in the
+// best case, where every remaining round is won with a bid of 1
+func isEliminated(position, balance, maxSteps int) bool {
+	return balance < maxSteps-position
+}
+
+// handleSetUsername renames the user after checking the username rules and
+// that no other connected user has the name, ignoring case
+func (h *Hub) handleSetUsername(user *User, msg *Message) {
+	name := strings.TrimSpace(msg.Username)
+	if code := validateUsername(name); code != "" {
+		h.sendError(user, code)
+		return
+	}
+	for _, other := range h.users {
+		if other.ID != user.ID && strings.EqualFold(other.Username, name) {
+			h.sendError(user, ErrUsernameTaken)
+			return
 		}
-		h.sendToUser(game.Player1, &endMsg)
-		h.sendToUser(game.Player2, &endMsg)
+	}
 
-		// Mark players as not in game
-		game.Player1.InGame = false
-		game.Player1.GameID = ""
-		game.Player2.InGame = false
-		game.Player2.GameID = ""
+	h.logger.Info("user_rename", "user_id", user.ID, "from", user.Username, "to", name)
+	user.Username = name
+	if user.Profile != nil {
+		user.Profile.Username = name
+		if err := h.store.SaveProfile(user.Profile); err != nil {
+			h.logger.Error("profile_save_failed", "profile_id", user.Profile.ID, "error", err)
+		}
+	}
+	h.sendToUser(user, &Message{Type: "username_changed", UserID: user.ID, Username: name})
+	h.broadcastUserList()
+}
 
-		// Broadcast updated user list
-		h.broadcastUserList()
+// handleJoinLobby moves the user to a named lobby, which scopes their user
+// list and open challenges
+func (h *Hub) handleJoinLobby(user *User, msg *Message) {
+	lobby := sanitizeText(msg.Lobby)
+	if lobby == "" || utf8.RuneCountInString(lobby) > MAX_LOBBY_NAME_LENGTH {
+		h.sendError(user, ErrInvalidLobby)
+		return
+	}
 
-		// Remove game after a delay
-		go func() {
-			time.Sleep(10 * time.Second)
-			delete(h.games, game.ID)
-		}()
+	// Quick matches are made within a lobby
+	h.dequeueMatch(user)
+	user.Lobby = lobby
+	h.sendToUser(user, &Message{Type: "lobby_joined", Lobby: lobby})
+	h.broadcastUserList()
+}
+
+// handleSetAutoFold toggles the user's auto-fold preference
+func (h *Hub) handleSetAutoFold(user *User, msg *Message) {
+	user.AutoFold = msg.Enabled
+}
+
+// handleRevealDone records that a player's client finished animating the
+// round result, opening the next round once both have
+func (h *Hub) handleRevealDone(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.Status != "REVEALING" {
+		return
+	}
 
-		log.Printf("Game %s ended: Winner=%d, Reason=%s", game.ID, winner, reason)
+	if game.Player1.ID == user.ID {
+		game.Player1Revealed = true
+	} else if game.Player2.ID == user.ID {
+		game.Player2Revealed = true
 	} else {
-		// Continue to next round
-		game.CurrentRound++
-		game.Player1Bid = nil
-		game.Player2Bid = nil
-		game.Status = "WAITING_FOR_BIDS"
+		return
+	}
 
-		// Send waiting for bids state
-		h.sendWaitingForBids(game)
+	if game.Player1Revealed && game.Player2Revealed {
+		h.startNextRound(game)
 	}
 }
 
-func (h *Hub) checkWinCondition(game *Game) (int, string) {
-	// Check if either player reached MAX_STEPS
-	if game.Player1Pos >= MAX_STEPS {
-		return 1, "Reached final step"
+// sendThinkingPulses tells a player who has bid that their opponent is still
+// deciding, at most once per ThinkingPulseInterval, with the seconds elapsed
+// since the round opened
+func (h *Hub) sendThinkingPulses() {
+	if h.config.ThinkingPulseInterval <= 0 {
+		return
+	}
+	now := h.now()
+	for _, game := range h.games {
+		if game.Status != "WAITING_FOR_BIDS" || (game.Player1Bid == nil) == (game.Player2Bid == nil) {
+			continue
+		}
+		if now.Sub(game.LastThinkingPulse) < h.config.ThinkingPulseInterval {
+			continue
+		}
+		game.LastThinkingPulse = now
+
+		waiting := game.Player1
+		if game.Player1Bid == nil {
+			waiting = game.Player2
+		}
+		h.sendToUser(waiting, &Message{
+			Type:    "opponent_thinking",
+			GameID:  game.ID,
+			Elapsed: int(now.Sub(game.RoundStart) / time.Second),
+		})
 	}
-	if game.Player2Pos >= MAX_STEPS {
-		return 2, "Reached final step"
+}
+
+// checkRevealTimeouts opens the next round for games whose clients didn't
+// acknowledge the reveal in time
+func (h *Hub) checkRevealTimeouts() {
+	now := h.now()
+	for _, game := range h.games {
+		if game.Status == "REVEALING" && !now.Before(game.RevealDeadline) {
+			h.logger.Info("reveal_ack_timeout", "game_id", game.ID)
+			h.startNextRound(game)
+		}
 	}
+}
 
-	// Check for bankruptcy stalemate
-	if game.Player1Balance == 0 && game.Player2Balance == 0 {
-		if game.Player1Pos > game.Player2Pos {
-			return 1, "Bankruptcy stalemate - higher position wins"
-		} else if game.Player2Pos > game.Player1Pos {
-			return 2, "Bankruptcy stalemate - higher position wins"
-		} else {
-			return 3, "Bankruptcy stalemate - draw"
+// checkBidTimeouts resolves rounds whose bid deadline has passed, counting
+// each missing bid as 0
+func (h *Hub) checkBidTimeouts() {
+	now := h.now()
+	for _, game := range h.games {
+		if game.Status != "WAITING_FOR_BIDS" || game.BidDeadline.IsZero() || now.Before(game.BidDeadline) {
+			continue
+		}
+		if game.Player1Bid == nil {
+			zero := 0
+			game.Player1Bid = &zero
+			game.TimedOut |= 1
+		}
+		if game.Player2Bid == nil {
+			zero := 0
+			game.Player2Bid = &zero
+			game.TimedOut |= 2
+		}
+		h.logger.Info("bid_timeout", "game_id", game.ID, "round", game.CurrentRound, "timed_out", game.TimedOut)
+		game.Status = "RESOLVING"
+		h.resolveRound(game)
+	}
+}
+
+// playerNumber returns 1 or 2 for a player in the game, 0 for anyone else
+func playerNumber(game *Game, user *User) int {
+	if game.Player1.ID == user.ID {
+		return 1
+	} else if game.Player2.ID == user.ID {
+		return 2
+	}
+	return 0
+}
+
+// handleRequestPause asks the opponent to agree to a break. The request
+// stands until the opponent accepts or declines it, or the next round opens.
+func (h *Hub) handleRequestPause(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || (game.Status != "WAITING_FOR_BIDS" && game.Status != "REVEALING") {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 || game.PauseRequestedBy != 0 {
+		return
+	}
+
+	game.PauseRequestedBy = playerNum
+	opponent := game.Player1
+	if playerNum == 1 {
+		opponent = game.Player2
+	}
+	h.sendToUser(opponent, &Message{Type: "pause_requested", GameID: game.ID})
+}
+
+// handleAcceptPause pauses the game once the opponent of the requester agrees.
+// A paused game rejects bids and its timers stand still until resumed.
+func (h *Hub) handleAcceptPause(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.PauseRequestedBy == 0 {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 || playerNum == game.PauseRequestedBy {
+		return
+	}
+	game.PauseRequestedBy = 0
+	if game.Status != "WAITING_FOR_BIDS" && game.Status != "REVEALING" {
+		return
+	}
+
+	game.PausedFrom = game.Status
+	game.PausedAt = h.now()
+	game.Status = "PAUSED"
+
+	pausedMsg := Message{Type: "game_paused", GameID: game.ID}
+	h.sendToUser(game.Player1, &pausedMsg)
+	h.sendToUser(game.Player2, &pausedMsg)
+	h.logger.Info("game_pause", "game_id", game.ID)
+}
+
+// handleDeclinePause turns down the opponent's pause request
+func (h *Hub) handleDeclinePause(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.PauseRequestedBy == 0 {
+		return
+	}
+	playerNum := playerNumber(game, user)
+	if playerNum == 0 || playerNum == game.PauseRequestedBy {
+		return
+	}
+
+	requester := game.Player1
+	if game.PauseRequestedBy == 2 {
+		requester = game.Player2
+	}
+	game.PauseRequestedBy = 0
+	h.sendToUser(requester, &Message{Type: "pause_declined", GameID: game.ID})
+}
+
+// expirePauseRequest withdraws an unanswered pause request, telling both
+// players
+func (h *Hub) expirePauseRequest(game *Game) {
+	if game.PauseRequestedBy == 0 {
+		return
+	}
+	game.PauseRequestedBy = 0
+	expiredMsg := Message{Type: "pause_request_expired", GameID: game.ID}
+	h.sendToUser(game.Player1, &expiredMsg)
+	h.sendToUser(game.Player2, &expiredMsg)
+}
+
+// handleResume lets either player end the pause
+func (h *Hub) handleResume(user *User, msg *Message) {
+	game, exists := h.games[msg.GameID]
+	if !exists || game.Status != "PAUSED" || playerNumber(game, user) == 0 {
+		return
+	}
+	h.resumeGame(game)
+}
+
+// resumeGame restores the status the game was paused in, pushing its timers
+// back by the time spent paused
+func (h *Hub) resumeGame(game *Game) {
+	paused := h.now().Sub(game.PausedAt)
+	game.Status = game.PausedFrom
+	game.PausedFrom = ""
+	game.RoundStart = game.RoundStart.Add(paused)
+	if game.Status == "REVEALING" {
+		game.RevealDeadline = game.RevealDeadline.Add(paused)
+	} else if !game.BidDeadline.IsZero() {
+		game.BidDeadline = game.BidDeadline.Add(paused)
+	}
+
+	resumedMsg := Message{Type: "game_resumed", GameID: game.ID}
+	h.sendToUser(game.Player1, &resumedMsg)
+	h.sendToUser(game.Player2, &resumedMsg)
+	h.sendBoardState(game, game.Player1)
+	h.sendBoardState(game, game.Player2)
+	h.logger.Info("game_resume", "game_id", game.ID, "paused", paused)
+}
+
+// checkPauseTimeouts resumes games paused for longer than MaxPauseDuration
+func (h *Hub) checkPauseTimeouts() {
+	if h.config.MaxPauseDuration <= 0 {
+		return
+	}
+	now := h.now()
+	for _, game := range h.games {
+		if game.Status == "PAUSED" && now.Sub(game.PausedAt) >= h.config.MaxPauseDuration {
+			h.logger.Info("pause_expired", "game_id", game.ID)
+			h.resumeGame(game)
 		}
 	}
+}
+
+// checkWinCondition asks the rules engine whether the game is over,
+// returning the winner (0 while it goes on) and the reason code
+func (h *Hub) checkWinCondition(game *Game) (int, string) {
+	return gameengine.CheckWin(h.engineRules(game), game.engineState())
+}
 
-	// Check if both players are at position 0 with 0 balance (edge case)
-	if game.Player1Pos == 0 && game.Player2Pos == 0 && game.Player1Balance == 0 && game.Player2Balance == 0 {
-		return 3, "No moves possible - draw"
+// sendGameEnd notifies both players and any spectators of the result, with
+// the reason text localized per recipient
+func (h *Hub) sendGameEnd(game *Game) {
+	for _, player := range []*User{game.Player1, game.Player2} {
+		endMsg := Message{
+			Type:       "game_end",
+			GameID:     game.ID,
+			Winner:     game.Winner,
+			Reason:     translate(player.Locale, game.Reason),
+			ReasonCode: game.Reason,
+			Dominance:  game.DominanceScore,
+			ResultHash: game.ResultHash,
+			History:    game.History,
+		}
+		h.sendToUser(player, &endMsg)
 	}
 
-	return 0, ""
+	for _, spectator := range h.spectators[game.ID] {
+		h.sendToClient(spectator, &Message{
+			Type:       "game_end",
+			GameID:     game.ID,
+			Winner:     game.Winner,
+			Reason:     translate(spectator.user.Locale, game.Reason),
+			ReasonCode: game.Reason,
+			Dominance:  game.DominanceScore,
+			ResultHash: game.ResultHash,
+			History:    game.History,
+		})
+	}
 }
 
 func (h *Hub) sendWaitingForBids(game *Game) {
+	secondsLeft := 0
+	if !game.BidDeadline.IsZero() {
+		secondsLeft = int(math.Ceil(game.BidDeadline.Sub(h.now()).Seconds()))
+	}
+	h.logger.Debug("waiting_for_bids", "game_id", game.ID, "round", game.CurrentRound)
+	h.sendGameState(game, "waiting_for_bids", func(msg *Message) {
+		msg.SecondsLeft = secondsLeft
+	})
+}
+
+// buildStateFor returns the game state as seen by a player (1 or 2), or by
+// spectators (0). Players get the shared P1/P2 fields plus the same figures
+// from their own side; with HideBalance the opponent's balance is left out
+// of both.
+func buildStateFor(game *Game, playerNum int) Message {
 	msg := Message{
-		Type:        "waiting_for_bids",
-		GameID:      game.ID,
-		Turn:        game.CurrentRound,
-		P1Balance:   game.Player1Balance,
-		P2Balance:   game.Player2Balance,
-		P1Position:  game.Player1Pos,
-		P2Position:  game.Player2Pos,
+		GameID:     game.ID,
+		Turn:       game.CurrentRound,
+		Event:      game.Event,
+		P1Balance:  game.Player1Balance,
+		P2Balance:  game.Player2Balance,
+		P1Position: game.Player1Pos,
+		P2Position: game.Player2Pos,
+		SpectatorCount: game.SpectatorCount,
 	}
-	log.Printf("Sending waiting_for_bids to both players for game %s", game.ID)
-	h.sendToUser(game.Player1, &msg)
-	h.sendToUser(game.Player2, &msg)
+	switch playerNum {
+	case 1:
+		msg.YourBalance, msg.OpponentBalance = game.Player1Balance, game.Player2Balance
+		msg.YourPosition, msg.OpponentPosition = game.Player1Pos, game.Player2Pos
+		if game.Settings.HideBalance {
+			msg.P2Balance, msg.OpponentBalance = 0, 0
+		}
+	case 2:
+		msg.YourBalance, msg.OpponentBalance = game.Player2Balance, game.Player1Balance
+		msg.YourPosition, msg.OpponentPosition = game.Player2Pos, game.Player1Pos
+		if game.Settings.HideBalance {
+			msg.P1Balance, msg.OpponentBalance = 0, 0
+		}
+	}
+	return msg
+}
+
+// boardState returns the board_state snapshot of the game for a player (1
+// or 2) or spectators (0): everything buildStateFor gives plus the track
+// length and whose bids the open round still awaits. It is the one message
+// a client can redraw the whole board from.
+func boardState(game *Game, playerNum int) Message {
+	msg := buildStateFor(game, playerNum)
+	msg.Type = "board_state"
+	msg.MaxSteps = game.maxSteps()
+	if game.Status == "WAITING_FOR_BIDS" {
+		if game.Player1Bid == nil {
+			msg.PendingBids = append(msg.PendingBids, 1)
+		}
+		if game.Player2Bid == nil {
+			msg.PendingBids = append(msg.PendingBids, 2)
+		}
+	}
+	return msg
+}
+
+// sendBoardState sends a player of the game their board_state snapshot
+func (h *Hub) sendBoardState(game *Game, user *User) {
+	msg := boardState(game, playerNumber(game, user))
+	h.sendToUser(user, &msg)
 }
 
+// sendGameState sends each player their own view of the game state and
+// spectators the shared one, as msgType messages; fill adds the fields
+// specific to the message type.
+func (h *Hub) sendGameState(game *Game, msgType string, fill func(msg *Message)) {
+	for i, player := range []*User{game.Player1, game.Player2} {
+		msg := buildStateFor(game, i+1)
+		msg.Type = msgType
+		fill(&msg)
+		h.sendToUser(player, &msg)
+	}
+	if len(h.spectators[game.ID]) > 0 {
+		msg := buildStateFor(game, 0)
+		msg.Type = msgType
+		fill(&msg)
+		h.sendToSpectators(game, &msg)
+	}
+}
+
+// handleRematch records a rematch request for a finished game. If the
+// opponent already asked within RematchWindow the rematch starts at once;
+// otherwise the opponent is sent rematch_received and can answer with their
+// own rematch, accept_rematch or decline_rematch.
 func (h *Hub) handleRematch(user *User, msg *Message) {
-	game, exists := h.games[msg.GameID]
+	now := h.now()
+	h.pruneRematches()
+
+	pending, exists := h.rematches[msg.GameID]
 	if !exists {
+		game, exists := h.games[msg.GameID]
+		if !exists || !game.GameOver || game.Ghost != nil || (game.Series != nil && !game.Series.Over) {
+			return
+		}
+		pending = &pendingRematch{game: game, requested: make(map[string]time.Time)}
+		h.rematches[msg.GameID] = pending
+	}
+	game := pending.game
+
+	var opponent *User
+	if game.Player1.ID == user.ID {
+		opponent = game.Player2
+	} else if game.Player2.ID == user.ID {
+		opponent = game.Player1
+	} else {
+		return
+	}
+	pending.requested[user.ID] = now
+	if opponent.IsBot {
+		// The bot is always up for another game
+		pending.requested[opponent.ID] = now
+	}
+
+	if _, bothAsked := pending.requested[opponent.ID]; !bothAsked {
+		// Send rematch request to opponent
+		rematchMsg := Message{
+			Type:       "rematch_received",
+			GameID:     msg.GameID,
+			FromUserID: user.ID,
+		}
+		h.sendToUser(opponent, &rematchMsg)
+		return
+	}
+
+	h.startRematch(pending, user, opponent)
+}
+
+// handleAcceptRematch answers the opponent's pending rematch request
+func (h *Hub) handleAcceptRematch(user *User, msg *Message) {
+	h.pruneRematches()
+	pending, opponent := h.pendingRematchFor(user, msg.GameID)
+	if pending == nil {
+		h.sendError(user, ErrNoRematchRequest)
+		return
+	}
+	h.startRematch(pending, user, opponent)
+}
+
+// handleDeclineRematch turns down the opponent's pending rematch request
+func (h *Hub) handleDeclineRematch(user *User, msg *Message) {
+	h.pruneRematches()
+	pending, opponent := h.pendingRematchFor(user, msg.GameID)
+	if pending == nil {
 		return
 	}
+	delete(h.rematches, msg.GameID)
+	h.sendError(opponent, ErrRematchDeclined)
+}
 
+// pendingRematchFor returns the rematch of the game that the user's opponent
+// has asked for, along with that opponent, or nil if there is none
+func (h *Hub) pendingRematchFor(user *User, gameID string) (*pendingRematch, *User) {
+	pending, exists := h.rematches[gameID]
+	if !exists {
+		return nil, nil
+	}
+	game := pending.game
 	var opponent *User
 	if game.Player1.ID == user.ID {
 		opponent = game.Player2
 	} else if game.Player2.ID == user.ID {
 		opponent = game.Player1
 	} else {
+		return nil, nil
+	}
+	if _, asked := pending.requested[opponent.ID]; !asked {
+		return nil, nil
+	}
+	return pending, opponent
+}
+
+// startRematch replaces a finished game with a new one between the same
+// players in the same seats, once both have agreed
+func (h *Hub) startRematch(pending *pendingRematch, user, opponent *User) {
+	game := pending.game
+	delete(h.rematches, game.ID)
+	if !opponent.IsBot && (h.users[opponent.ID] != opponent || !opponent.AbsentSince.IsZero()) {
+		h.sendError(user, ErrOpponentLeft)
+		return
+	}
+	if !h.canJoinGame(user) || !h.canJoinGame(opponent) {
+		h.sendError(user, ErrUserInGame)
 		return
 	}
 
-	// Send rematch request to opponent
-	rematchMsg := Message{
-		Type:       "rematch_received",
-		GameID:     msg.GameID,
-		FromUserID: user.ID,
+	// The finished game has nothing left to show once the rematch starts
+	delete(h.games, game.ID)
+	delete(h.spectators, game.ID)
+
+	// A rematch of a series replays the series, even after a sudden-death decider
+	settings := game.Settings
+	if game.Series != nil {
+		settings = game.Series.Settings
+	}
+	// A ladder rematch plays the bot on the player's rung, which a win may have raised
+	player2 := game.Player2
+	if player2.BotRung > 0 && game.Player1.Profile != nil {
+		player2 = h.newLadderBot(game.Player1.Profile)
+	}
+	rematch := h.createGame(game.Player1, player2, settings, nil)
+	h.broadcastUserList()
+	h.logger.Info("game_start", "game_id", rematch.ID, "player1", game.Player1.Username, "player2", game.Player2.Username, "rematch_of", game.ID)
+}
+
+// pruneRematches drops rematch requests older than RematchWindow
+func (h *Hub) pruneRematches() {
+	cutoff := h.now().Add(-h.config.RematchWindow)
+	for gameID, pending := range h.rematches {
+		for userID, at := range pending.requested {
+			if at.Before(cutoff) {
+				delete(pending.requested, userID)
+			}
+		}
+		if len(pending.requested) == 0 {
+			delete(h.rematches, gameID)
+		}
 	}
-	h.sendToUser(opponent, &rematchMsg)
 }
 
 func (h *Hub) handleResign(user *User, msg *Message) {
@@ -540,86 +1902,338 @@ func (h *Hub) handleResign(user *User, msg *Message) {
 		return
 	}
 
-	var opponent *User
 	var winner int
 	if game.Player1.ID == user.ID {
-		opponent = game.Player2
 		winner = 2
 	} else if game.Player2.ID == user.ID {
-		opponent = game.Player1
 		winner = 1
 	} else {
 		return
 	}
 
 	// End game with opponent as winner
+	h.finishGame(game, winner, ReasonOpponentResigned)
+}
+
+// finishGame ends the game with the given winner and reason code, records it
+// and notifies both players
+func (h *Hub) finishGame(game *Game, winner int, reason string) {
 	game.GameOver = true
 	game.Winner = winner
-	game.EndTime = time.Now()
+	game.Reason = reason
+	game.EndTime = h.now()
 	game.Status = "GAME_OVER"
-
-	endMsg := Message{
-		Type:   "game_end",
-		GameID: game.ID,
-		Winner: winner,
-		Reason: "Opponent resigned",
-	}
-	h.sendToUser(opponent, &endMsg)
-	h.sendToUser(user, &endMsg)
+	game.DominanceScore = dominanceScore(game)
+	game.ResultHash = resultHash(game.ID, game.History)
+	h.updateRatings(game)
+	h.advanceLadder(game)
+	h.saveGame(game)
+	h.sendGameEnd(game)
+	h.recordSessionResults(game)
 
 	// Mark players as not in game
-	game.Player1.InGame = false
-	game.Player1.GameID = ""
-	game.Player2.InGame = false
-	game.Player2.GameID = ""
+	game.Player1.leaveGame(game.ID)
+	game.Player2.leaveGame(game.ID)
 
 	// Broadcast updated user list
 	h.broadcastUserList()
 
-	// Remove game after a delay
-	go func() {
-		time.Sleep(10 * time.Second)
-		delete(h.games, game.ID)
-	}()
+	h.scheduleGameRemoval(game.ID)
+
+	h.logger.Info("game_end", "game_id", game.ID, "winner", winner, "reason", reason)
+	h.advanceSeries(game)
+	h.autoSpectate(game.Player1)
+	h.autoSpectate(game.Player2)
+}
+
+// scheduleGameRemoval removes a finished game after the retention period.
+// The timer only signals the hub loop, which owns h.games.
+func (h *Hub) scheduleGameRemoval(gameID string) {
+	time.AfterFunc(h.config.FinishedGameRetention, func() {
+		select {
+		case h.removeGame <- gameID:
+		case <-h.stopped:
+		}
+	})
+}
+
+// deleteFinishedGame drops a finished game and its spectators once its
+// retention period is over. A rematch may have removed it already.
+func (h *Hub) deleteFinishedGame(gameID string) {
+	if game, exists := h.games[gameID]; exists && game.GameOver {
+		delete(h.games, gameID)
+		delete(h.spectators, gameID)
+	}
+}
+
+// dominanceScore rates how decisively a game was won from 0 (draw or
+// nail-biter) to 100 (finished with the opponent still at the start and the
+// whole budget unspent). Half comes from the final position gap and half
+// from the winner's remaining budget.
+func dominanceScore(game *Game) int {
+	var gap, remaining int
+	switch game.Winner {
+	case 1:
+		gap = game.Player1Pos - game.Player2Pos
+		remaining = game.Player1Balance
+	case 2:
+		gap = game.Player2Pos - game.Player1Pos
+		remaining = game.Player2Balance
+	default:
+		return 0
+	}
+	if gap < 0 {
+		gap = 0
+	}
+	if gap > game.maxSteps() {
+		gap = game.maxSteps()
+	}
+	return 50*gap/game.maxSteps() + 50*remaining/game.initialBudget()
 }
 
 // Utility methods
 
+// logWire logs a protocol message at debug level, redacting free text if configured
+func (h *Hub) logWire(direction string, user *User, msg *Message) {
+	logged := *msg
+	if h.config.WireLogRedact && logged.Note != "" {
+		logged.Note = "[redacted]"
+	}
+	if h.config.WireLogRedact && logged.Text != "" {
+		logged.Text = "[redacted]"
+	}
+	data, _ := json.Marshal(&logged)
+
+	userID := ""
+	if user != nil {
+		userID = user.ID
+	}
+	h.logger.Debug("wire", "dir", direction, "type", msg.Type, "user_id", userID, "game_id", msg.GameID, "message", string(data))
+}
+
+// isUsernameTaken reports whether a connected user already has the name,
+// ignoring case as set_username does
+func (h *Hub) isUsernameTaken(name string) bool {
+	for _, user := range h.users {
+		if strings.EqualFold(user.Username, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// findUserByName resolves a username typed by a player: an exact match
+// wins, otherwise a single case-insensitive one. Users hidden by a private
+// room or game can't be found. It returns an error code if no user or
+// several users match.
+func (h *Hub) findUserByName(name string) (*User, string) {
+	var matches []*User
+	for _, user := range h.users {
+		if h.isHidden(user) {
+			continue
+		}
+		if user.Username == name {
+			return user, ""
+		}
+		if strings.EqualFold(user.Username, name) {
+			matches = append(matches, user)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, ErrUnknownUsername
+	case 1:
+		return matches[0], ""
+	}
+	return nil, ErrAmbiguousUsername
+}
+
+// canJoinGame reports whether the user may start another game without going
+// over the per-user limit
+func (h *Hub) canJoinGame(user *User) bool {
+	return h.config.MaxGamesPerUser <= 0 || len(user.GameIDs) < h.config.MaxGamesPerUser
+}
+
+// sanitizeText strips control characters and surrounding whitespace from
+// user-supplied text before it is relayed to other clients
+func sanitizeText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
+
+// logChallenge appends a challenge event to the store's challenge log
+func (h *Hub) logChallenge(challenge *Challenge, event string) {
+	entry := &ChallengeLogEntry{
+		ChallengeID:  challenge.ID,
+		Event:        event,
+		FromUserID:   challenge.FromUser.ID,
+		FromUsername: challenge.FromUser.Username,
+		Time:         h.now(),
+	}
+	if challenge.ToUser != nil {
+		entry.ToUserID = challenge.ToUser.ID
+		entry.ToUsername = challenge.ToUser.Username
+	}
+	if err := h.store.LogChallenge(entry); err != nil {
+		h.logger.Error("challenge_log_failed", "challenge_id", challenge.ID, "error", err)
+	}
+}
+
+// saveGame records a finished game in the store
+func (h *Hub) saveGame(game *Game) {
+	if err := h.store.SaveGame(newGameRecord(game)); err != nil {
+		h.logger.Error("game_save_failed", "game_id", game.ID, "error", err)
+	}
+}
+
 func (h *Hub) sendToClient(client *Client, msg *Message) {
+	// A proxy user's client has no connection here: go through their home server
+	if client.user != nil && client.user.Peer != "" {
+		h.sendToUser(client.user, msg)
+		return
+	}
+	if h.config.WireLog {
+		h.logWire("out", client.user, msg)
+	}
+	if client.overflowed {
+		return
+	}
 	data, _ := json.Marshal(msg)
-	client.send <- data
+	queue := client.send
+	if client.lobby != nil && h.isLobbyMessage(msg.Type) {
+		queue = client.lobby
+	}
+	select {
+	case queue <- data:
+	default:
+		// A client that can't keep up must not stall the hub for everyone:
+		// stop sending to it and drop it on a later pass of the loop
+		client.overflowed = true
+		if client.user != nil {
+			h.logger.Warn("send_buffer_full", "user_id", client.user.ID, "user", client.user.Username)
+		} else {
+			h.logger.Warn("send_buffer_full")
+		}
+		go func() {
+			select {
+			case h.unregister <- client:
+			case <-h.stopped:
+			}
+		}()
+	}
+}
+
+// isLobbyMessage reports whether a message type is sent at lobby priority
+func (h *Hub) isLobbyMessage(msgType string) bool {
+	for _, t := range h.config.LobbyMessageTypes {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *Hub) sendToUser(user *User, msg *Message) {
-	if user != nil && user.Client != nil {
+	if user == nil {
+		return
+	}
+	// Remote users are reached through their home server
+	if user.Peer != "" {
+		if h.federator != nil {
+			h.federator.relay(user, msg)
+		}
+		return
+	}
+	if user.Client != nil {
 		h.sendToClient(user.Client, msg)
 	}
 }
 
-func (h *Hub) sendError(user *User, errorMsg string) {
+// sendToLobby sends a message to every user in the lobby except the given ones
+func (h *Hub) sendToLobby(lobby string, msg *Message, except ...*User) {
+	for _, user := range h.users {
+		skip := user.Lobby != lobby
+		for _, u := range except {
+			if u.ID == user.ID {
+				skip = true
+			}
+		}
+		if !skip {
+			h.sendToUser(user, msg)
+		}
+	}
+}
+
+// sendError sends a catalog code to the user along with its localized text
+func (h *Hub) sendError(user *User, code string) {
 	msg := Message{
-		Type:     "error",
-		Username: errorMsg,
+		Type:      "error",
+		Error:     translate(user.Locale, code),
+		ErrorCode: code,
 	}
 	h.sendToUser(user, &msg)
 }
 
+// sendClientError sends a catalog code to a connection, which may not have
+// a user yet when it is waitlisted
+func (h *Hub) sendClientError(client *Client, code string) {
+	locale := normalizeLocale(client.locale)
+	if client.user != nil {
+		locale = client.user.Locale
+	}
+	h.sendToClient(client, &Message{Type: "error", Error: translate(locale, code), ErrorCode: code})
+}
+
+// broadcastUserList schedules a users_update for everyone. Calls within the
+// batch window are coalesced; the list is built when the broadcast is sent, so
+// the final state is always current.
 func (h *Hub) broadcastUserList() {
-	users := make([]UserInfo, 0, len(h.users))
+	if h.config.UserListBatchWindow <= 0 {
+		h.flushUserList()
+		return
+	}
+	if h.userListPending == nil {
+		h.userListPending = time.After(h.config.UserListBatchWindow)
+	}
+}
+
+// flushUserList sends every connected user the list of users in their lobby
+func (h *Hub) flushUserList() {
+	lobbies := make(map[string][]UserInfo)
 	for _, user := range h.users {
-		users = append(users, UserInfo{
-			UserID:   user.ID,
-			Username: user.Username,
-			InGame:   user.InGame,
+		if h.isHidden(user) {
+			continue
+		}
+		lobbies[user.Lobby] = append(lobbies[user.Lobby], UserInfo{
+			UserID:     user.ID,
+			Username:   user.Username,
+			InGame:     user.InGame,
+			Available:  h.canJoinGame(user),
+			Server:     user.Peer,
+			Lobby:      user.Lobby,
+			Color:      userColor(h.config.Palette, user.ID),
+			AvatarSeed: avatarSeed(user.ID),
+			Rating:     user.rating(),
 		})
 	}
 
-	msg := Message{
-		Type:  "users_update",
-		Users: users,
+	// Remote users get their list from their own server
+	for _, user := range h.users {
+		if user.Peer == "" {
+			msg := Message{
+				Type:  "users_update",
+				Users: lobbies[user.Lobby],
+			}
+			h.sendToUser(user, &msg)
+		}
 	}
 
-	for _, user := range h.users {
-		h.sendToUser(user, &msg)
+	if h.federator != nil {
+		h.federator.sendPresence()
 	}
 }
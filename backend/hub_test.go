@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 )
@@ -36,6 +41,54 @@ func MockGame(id string, p1, p2 *User) *Game {
 	}
 }
 
+// newTestClient connects a client without a websocket so handlers can be driven directly
+func newTestClient(h *Hub) *Client {
+	client := &Client{hub: h, send: make(chan []byte, 256)}
+	h.clients[client] = true
+	h.handleConnect(client)
+	return client
+}
+
+// drainMessages returns all messages queued for the client
+func drainMessages(client *Client) []Message {
+	var msgs []Message
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				return msgs
+			}
+			var msg Message
+			json.Unmarshal(data, &msg)
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// lastMessageOfType returns the most recent queued message of the given type
+func lastMessageOfType(msgs []Message, msgType string) *Message {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Type == msgType {
+			return &msgs[i]
+		}
+	}
+	return nil
+}
+
+// startTestGame challenges c2 from c1, accepts it and returns the new game
+func startTestGame(t *testing.T, h *Hub, c1, c2 *Client) *Game {
+	t.Helper()
+	return startTestGameWith(t, h, c1, c2, Message{})
+}
+
+// playRound submits both bids for the current round
+func playRound(h *Hub, game *Game, c1, c2 *Client, p1Bid, p2Bid int) {
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: p1Bid})
+	h.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: p2Bid})
+}
+
 // TestBidValidation tests that bids are validated correctly
 func TestBidValidation(t *testing.T) {
 	tests := []struct {
@@ -64,238 +117,90 @@ func TestBidValidation(t *testing.T) {
 	}
 }
 
-// TestAllPayMechanic tests that both players lose their bid regardless of outcome
-func TestAllPayMechanic(t *testing.T) {
+// TestRoundResolutionModes tests what each game mode charges for a round
+// while moving the pawns the same way
+func TestRoundResolutionModes(t *testing.T) {
 	tests := []struct {
-		name           string
-		p1Bid          int
-		p2Bid          int
-		p1Balance      int
-		p2Balance      int
-		expectedP1Bal  int
-		expectedP2Bal  int
+		name  string
+		mode  string
+		p1Bid int
+		p2Bid int
+		p1Pay int
+		p2Pay int
+		p1Pos int
+		p2Pos int
 	}{
-		{
-			name:           "P1 wins round",
-			p1Bid:          5,
-			p2Bid:          3,
-			p1Balance:      20,
-			p2Balance:      20,
-			expectedP1Bal:  15, // 20 - 5
-			expectedP2Bal:  17, // 20 - 3
-		},
-		{
-			name:           "P2 wins round",
-			p1Bid:          2,
-			p2Bid:          7,
-			p1Balance:      20,
-			p2Balance:      20,
-			expectedP1Bal:  18, // 20 - 2
-			expectedP2Bal:  13, // 20 - 7
-		},
-		{
-			name:           "Draw - both bid 0",
-			p1Bid:          0,
-			p2Bid:          0,
-			p1Balance:      20,
-			p2Balance:      20,
-			expectedP1Bal:  20, // 20 - 0
-			expectedP2Bal:  20, // 20 - 0
-		},
-		{
-			name:           "Draw - both bid same non-zero",
-			p1Bid:          5,
-			p2Bid:          5,
-			p1Balance:      20,
-			p2Balance:      20,
-			expectedP1Bal:  15, // 20 - 5
-			expectedP2Bal:  15, // 20 - 5
-		},
-		{
-			name:           "All-in P1 wins",
-			p1Bid:          20,
-			p2Bid:          10,
-			p1Balance:      20,
-			p2Balance:      20,
-			expectedP1Bal:  0,  // 20 - 20
-			expectedP2Bal:  10, // 20 - 10
-		},
+		{"All-pay P1 wins", "", 7, 4, 7, 4, 1, 0},
+		{"All-pay P2 wins", GameModeAllPay, 2, 6, 2, 6, 0, 1},
+		{"All-pay draw", GameModeAllPay, 5, 5, 5, 5, 0, 0},
+		{"Second-price P1 wins", GameModeSecondPrice, 7, 4, 4, 0, 1, 0},
+		{"Second-price P2 wins", GameModeSecondPrice, 2, 6, 0, 2, 0, 1},
+		{"Second-price uncontested", GameModeSecondPrice, 3, 0, 0, 0, 1, 0},
+		{"Second-price draw", GameModeSecondPrice, 5, 5, 5, 5, 0, 0},
+		{"First-price P1 wins", GameModeFirstPrice, 7, 4, 7, 0, 1, 0},
+		{"First-price P2 wins", GameModeFirstPrice, 2, 6, 0, 6, 0, 1},
+		{"First-price draw", GameModeFirstPrice, 5, 5, 0, 0, 0, 0},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Simulate the all-pay deduction
-			p1Bal := tt.p1Balance - tt.p1Bid
-			p2Bal := tt.p2Balance - tt.p2Bid
-
-			if p1Bal != tt.expectedP1Bal {
-				t.Errorf("P1 balance: got %d, want %d", p1Bal, tt.expectedP1Bal)
-			}
-			if p2Bal != tt.expectedP2Bal {
-				t.Errorf("P2 balance: got %d, want %d", p2Bal, tt.expectedP2Bal)
-			}
-		})
-	}
-}
-
-// TestRoundResolution tests who advances based on bids
-func TestRoundResolution(t *testing.T) {
-	tests := []struct {
-		name         string
-		p1Bid        int
-		p2Bid        int
-		expectedPos1 int
-		expectedPos2 int
-		expectedResult string
-	}{
-		{
-			name:         "P1 wins with higher bid",
-			p1Bid:        5,
-			p2Bid:        3,
-			expectedPos1: 1,
-			expectedPos2: 0,
-			expectedResult: "P1_WINS_ROUND",
-		},
-		{
-			name:         "P2 wins with higher bid",
-			p1Bid:        2,
-			p2Bid:        7,
-			expectedPos1: 0,
-			expectedPos2: 1,
-			expectedResult: "P2_WINS_ROUND",
-		},
-		{
-			name:         "Draw - equal bids",
-			p1Bid:        5,
-			p2Bid:        5,
-			expectedPos1: 0,
-			expectedPos2: 0,
-			expectedResult: "DRAW",
-		},
-		{
-			name:         "Draw - both bid 0",
-			p1Bid:        0,
-			p2Bid:        0,
-			expectedPos1: 0,
-			expectedPos2: 0,
-			expectedResult: "DRAW",
-		},
-	}
+			hub := newHub(DefaultConfig())
+			c1 := newTestClient(hub)
+			c2 := newTestClient(hub)
+			game := startTestGameWith(t, hub, c1, c2, Message{GameMode: tt.mode})
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Simulate movement determination
-			p1Pos, p2Pos := 0, 0
-			var result string
-
-			if tt.p1Bid > tt.p2Bid {
-				p1Pos = 1
-				result = "P1_WINS_ROUND"
-			} else if tt.p2Bid > tt.p1Bid {
-				p2Pos = 1
-				result = "P2_WINS_ROUND"
-			} else {
-				result = "DRAW"
-			}
+			playRound(hub, game, c1, c2, tt.p1Bid, tt.p2Bid)
 
-			if p1Pos != tt.expectedPos1 {
-				t.Errorf("P1 position: got %d, want %d", p1Pos, tt.expectedPos1)
+			if game.Player1Balance != INITIAL_BUDGET-tt.p1Pay || game.Player2Balance != INITIAL_BUDGET-tt.p2Pay {
+				t.Errorf("balances: got %d/%d, want %d/%d", game.Player1Balance, game.Player2Balance,
+					INITIAL_BUDGET-tt.p1Pay, INITIAL_BUDGET-tt.p2Pay)
 			}
-			if p2Pos != tt.expectedPos2 {
-				t.Errorf("P2 position: got %d, want %d", p2Pos, tt.expectedPos2)
+			if game.Player1Spent != tt.p1Pay || game.Player2Spent != tt.p2Pay {
+				t.Errorf("spent: got %d/%d, want %d/%d", game.Player1Spent, game.Player2Spent, tt.p1Pay, tt.p2Pay)
 			}
-			if result != tt.expectedResult {
-				t.Errorf("Result: got %s, want %s", result, tt.expectedResult)
+			if game.Player1Pos != tt.p1Pos || game.Player2Pos != tt.p2Pos {
+				t.Errorf("positions: got %d/%d, want %d/%d", game.Player1Pos, game.Player2Pos, tt.p1Pos, tt.p2Pos)
 			}
 		})
 	}
+
+	t.Run("Unknown mode rejected", func(t *testing.T) {
+		hub := newHub(DefaultConfig())
+		c1 := newTestClient(hub)
+		c2 := newTestClient(hub)
+		hub.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, GameMode: "dutch"})
+		if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrInvalidGameMode {
+			t.Errorf("expected %s error, got %+v", ErrInvalidGameMode, errMsg)
+		}
+	})
 }
 
-// TestWinCondition tests the win conditions
-func TestWinCondition(t *testing.T) {
-	tests := []struct {
-		name        string
-		p1Pos       int
-		p2Pos       int
-		p1Bal       int
-		p2Bal       int
-		expectedWin int // 0 = continue, 1 = p1 wins, 2 = p2 wins, 3 = draw
-	}{
-		{
-			name:        "P1 reaches finish",
-			p1Pos:       3,
-			p2Pos:       1,
-			p1Bal:       10,
-			p2Bal:       10,
-			expectedWin: 1,
-		},
-		{
-			name:        "P2 reaches finish",
-			p1Pos:       1,
-			p2Pos:       3,
-			p1Bal:       10,
-			p2Bal:       10,
-			expectedWin: 2,
-		},
-		{
-			name:        "Game continues - neither at finish",
-			p1Pos:       1,
-			p2Pos:       1,
-			p1Bal:       10,
-			p2Bal:       10,
-			expectedWin: 0,
-		},
-		{
-			name:        "Bankruptcy stalemate - P1 higher position",
-			p1Pos:       2,
-			p2Pos:       1,
-			p1Bal:       0,
-			p2Bal:       0,
-			expectedWin: 1,
-		},
-		{
-			name:        "Bankruptcy stalemate - P2 higher position",
-			p1Pos:       1,
-			p2Pos:       2,
-			p1Bal:       0,
-			p2Bal:       0,
-			expectedWin: 2,
-		},
-		{
-			name:        "Bankruptcy stalemate - equal position draw",
-			p1Pos:       1,
-			p2Pos:       1,
-			p1Bal:       0,
-			p2Bal:       0,
-			expectedWin: 3,
-		},
-	}
+// TestFirstPriceBankruptcy tests that in the first-price mode one player
+// running out of coins doesn't end the game: the other can still outbid them
+func TestFirstPriceBankruptcy(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGameWith(t, hub, c1, c2, Message{GameMode: GameModeFirstPrice})
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Simulate win condition check
-			var winner int
-
-			if tt.p1Pos >= MAX_STEPS {
-				winner = 1
-			} else if tt.p2Pos >= MAX_STEPS {
-				winner = 2
-			} else if tt.p1Bal == 0 && tt.p2Bal == 0 {
-				if tt.p1Pos > tt.p2Pos {
-					winner = 1
-				} else if tt.p2Pos > tt.p1Pos {
-					winner = 2
-				} else {
-					winner = 3
-				}
-			} else {
-				winner = 0
-			}
+	playRound(hub, game, c1, c2, INITIAL_BUDGET, 3)
+	if game.Player1Balance != 0 || game.Player2Balance != INITIAL_BUDGET {
+		t.Fatalf("balances: got %d/%d, want 0/%d", game.Player1Balance, game.Player2Balance, INITIAL_BUDGET)
+	}
+	if game.GameOver {
+		t.Fatal("one player being broke must not end the game")
+	}
 
-			if winner != tt.expectedWin {
-				t.Errorf("Winner: got %d, want %d", winner, tt.expectedWin)
-			}
-		})
+	// P2 walks to the finish bidding 1 a round
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(hub, game, c1, c2, 0, 1)
+	}
+	end := lastMessageOfType(drainMessages(c1), "game_end")
+	if end == nil || end.Winner != 2 || end.ReasonCode != ReasonReachedFinalStep {
+		t.Errorf("P2 should win by reaching the final step, got %+v", end)
+	}
+	if game.Player2Balance != INITIAL_BUDGET-MAX_STEPS {
+		t.Errorf("P2 balance: got %d, want %d", game.Player2Balance, INITIAL_BUDGET-MAX_STEPS)
 	}
 }
 
@@ -420,3 +325,1865 @@ func TestHistoryRecording(t *testing.T) {
 		t.Errorf("History result: got %s, want P1_WINS_ROUND", game.History[0].Result)
 	}
 }
+
+// TestMinTotalBidForfeit tests that a perpetual zero bidder forfeits at the threshold round
+func TestMinTotalBidForfeit(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.MinTotalBid = 3
+	hub.config.MinTotalBidRound = 3
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	// P1 never bids; P2 spends 3 over three rounds without reaching the finish
+	playRound(hub, game, c1, c2, 0, 2)
+	playRound(hub, game, c1, c2, 0, 0)
+	if game.GameOver {
+		t.Fatal("game should not end before the threshold round")
+	}
+	playRound(hub, game, c1, c2, 0, 1)
+
+	if !game.GameOver || game.Winner != 2 {
+		t.Fatalf("expected P1 to forfeit at round 3, got GameOver=%v Winner=%d", game.GameOver, game.Winner)
+	}
+	if game.Player2Spent != 3 || game.Player1Spent != 0 {
+		t.Errorf("spent: P1=%d (want 0), P2=%d (want 3)", game.Player1Spent, game.Player2Spent)
+	}
+	end := lastMessageOfType(drainMessages(c1), "game_end")
+	if end == nil || end.Reason != "Minimum total bid not met" {
+		t.Errorf("expected forfeit game_end, got %+v", end)
+	}
+}
+
+// waitForMessage reads from the client's queue until a message of the given type arrives
+func waitForMessage(t *testing.T, client *Client, msgType string) Message {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-client.send:
+			var msg Message
+			json.Unmarshal(data, &msg)
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", msgType)
+			return Message{}
+		}
+	}
+}
+
+// TestPanicRecovery tests that a panicking handler doesn't kill the hub
+func TestPanicRecovery(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.messageHook = func(client *Client, msg *Message) {
+		if msg.Type == "boom" {
+			panic("injected handler failure")
+		}
+	}
+	go hub.run(context.Background())
+
+	c1 := &Client{hub: hub, send: make(chan []byte, 256)}
+	c2 := &Client{hub: hub, send: make(chan []byte, 256)}
+	hub.register <- c1
+	hub.register <- c2
+	waitForMessage(t, c1, "welcome")
+	welcome := waitForMessage(t, c2, "welcome")
+
+	hub.handleMessage <- &MessageWrapper{client: c1, message: &Message{Type: "boom"}}
+	errMsg := waitForMessage(t, c1, "error")
+	if errMsg.Error != translate(defaultLocale, ErrInternal) {
+		t.Errorf("error text: got %q", errMsg.Error)
+	}
+
+	// The hub must still be processing messages
+	hub.handleMessage <- &MessageWrapper{client: c1, message: &Message{Type: "challenge", TargetUserID: welcome.UserID}}
+	waitForMessage(t, c2, "challenge_received")
+}
+
+// TestUserListBatching tests that rapid joins are coalesced into fewer broadcasts
+func TestUserListBatching(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.UserListBatchWindow = 50 * time.Millisecond
+	go hub.run(context.Background())
+
+	clients := make([]*Client, 10)
+	for i := range clients {
+		clients[i] = &Client{hub: hub, send: make(chan []byte, 256)}
+		hub.register <- clients[i]
+	}
+
+	// Wait for a broadcast carrying every user, counting updates along the way
+	first := clients[0]
+	updates := 0
+	timeout := time.After(2 * time.Second)
+	for {
+		var msg Message
+		select {
+		case data := <-first.send:
+			json.Unmarshal(data, &msg)
+		case <-timeout:
+			t.Fatal("timed out waiting for a complete users_update")
+		}
+		if msg.Type != "users_update" {
+			continue
+		}
+		updates++
+		if len(msg.Users) == len(clients) {
+			break
+		}
+	}
+
+	if updates >= len(clients) {
+		t.Errorf("expected fewer than %d broadcasts, got %d", len(clients), updates)
+	}
+}
+
+// TestChallengeNote tests that notes are sanitized and relayed, and over-length notes rejected
+func TestChallengeNote(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+
+	hub.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, Note: "  good luck\n\x07!  "})
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if received == nil || received.Note != "good luck!" {
+		t.Fatalf("expected sanitized note, got %+v", received)
+	}
+
+	c3 := newTestClient(hub)
+	long := strings.Repeat("é", MAX_NOTE_LENGTH+1)
+	hub.handleClientMessage(c3, &Message{Type: "challenge", TargetUserID: c2.user.ID, Note: long})
+	if lastMessageOfType(drainMessages(c2), "challenge_received") != nil {
+		t.Error("challenge with an over-length note should not be delivered")
+	}
+	if lastMessageOfType(drainMessages(c3), "error") == nil {
+		t.Error("sender of an over-length note should get an error")
+	}
+}
+
+// TestMultiGameMode tests that a user below the concurrent-game limit can
+// accept another challenge while playing, and one at the limit can't
+func TestMultiGameMode(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	c3 := newTestClient(hub)
+	first := startTestGame(t, hub, c1, c2)
+
+	// Default single-game mode refuses challenges to a busy user
+	hub.handleClientMessage(c3, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	if lastMessageOfType(drainMessages(c2), "challenge_received") != nil {
+		t.Fatal("busy user should not receive challenges in single-game mode")
+	}
+
+	hub.config.MaxGamesPerUser = 2
+	second := startTestGame(t, hub, c3, c2)
+	if first.ID == second.ID {
+		t.Fatal("expected a second, distinct game")
+	}
+	if !c2.user.GameIDs[first.ID] || !c2.user.GameIDs[second.ID] {
+		t.Errorf("user should be in both games, got %v", c2.user.GameIDs)
+	}
+
+	// At the limit of two the user is reported busy and refuses a third
+	drainMessages(c1)
+	hub.flushUserList()
+	update := lastMessageOfType(drainMessages(c1), "users_update")
+	if update == nil {
+		t.Fatal("expected a users_update")
+	}
+	for _, info := range update.Users {
+		if info.UserID == c2.user.ID && info.Available {
+			t.Error("a user at the game limit should not be reported available")
+		}
+	}
+	c4 := newTestClient(hub)
+	hub.handleClientMessage(c4, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	if lastMessageOfType(drainMessages(c2), "challenge_received") != nil {
+		t.Error("a user at the game limit should not receive challenges")
+	}
+
+	// Finishing one game leaves the user in the other
+	hub.handleClientMessage(c1, &Message{Type: "resign", GameID: first.ID})
+	if !c2.user.InGame || c2.user.GameIDs[first.ID] || !c2.user.GameIDs[second.ID] {
+		t.Errorf("after first game ends: InGame=%v GameIDs=%v", c2.user.InGame, c2.user.GameIDs)
+	}
+}
+
+// TestRevealAckBothPlayers tests that the next round opens once both clients acknowledge the reveal
+func TestRevealAckBothPlayers(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.RevealAckTimeout = 5 * time.Second
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	playRound(hub, game, c1, c2, 3, 1)
+	msgs := drainMessages(c1)
+	if lastMessageOfType(msgs, "round_result") == nil || lastMessageOfType(msgs, "waiting_for_bids") != nil {
+		t.Fatal("round_result should be sent without opening the next round")
+	}
+	if game.Status != "REVEALING" {
+		t.Fatalf("status: got %s, want REVEALING", game.Status)
+	}
+
+	// Bids are refused until the next round opens
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	if game.Player1Bid == nil || *game.Player1Bid != 3 {
+		t.Error("bid during reveal should be rejected")
+	}
+
+	hub.handleClientMessage(c1, &Message{Type: "reveal_done", GameID: game.ID})
+	if game.Status != "REVEALING" {
+		t.Fatal("one acknowledgment should not open the next round")
+	}
+	hub.handleClientMessage(c2, &Message{Type: "reveal_done", GameID: game.ID})
+
+	next := lastMessageOfType(drainMessages(c2), "waiting_for_bids")
+	if next == nil || next.Turn != 2 || game.Status != "WAITING_FOR_BIDS" {
+		t.Errorf("expected round 2 to open, got %+v (status %s)", next, game.Status)
+	}
+}
+
+// TestRevealAckTimeout tests that the next round opens when acknowledgments don't arrive in time
+func TestRevealAckTimeout(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	now := time.Now()
+	hub.now = func() time.Time { return now }
+	hub.config.RevealAckTimeout = 5 * time.Second
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	playRound(hub, game, c1, c2, 3, 1)
+	hub.handleClientMessage(c1, &Message{Type: "reveal_done", GameID: game.ID})
+
+	now = now.Add(4 * time.Second)
+	hub.checkRevealTimeouts()
+	if game.Status != "REVEALING" {
+		t.Fatal("next round should not open before the timeout")
+	}
+
+	now = now.Add(2 * time.Second)
+	hub.checkRevealTimeouts()
+	if game.Status != "WAITING_FOR_BIDS" || game.CurrentRound != 2 {
+		t.Errorf("expected round 2 after timeout, got status %s round %d", game.Status, game.CurrentRound)
+	}
+	if lastMessageOfType(drainMessages(c2), "waiting_for_bids") == nil {
+		t.Error("waiting_for_bids should be sent after the timeout")
+	}
+}
+
+// TestDominanceScore tests that a blowout scores higher than a nail-biter
+func TestDominanceScore(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+
+	// Blowout: P1 wins every round cheaply
+	blowout := startTestGame(t, hub, c1, c2)
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(hub, blowout, c1, c2, 1, 0)
+	}
+
+	// Nail-biter: trading rounds with big bids until P1 scrapes the win
+	nailBiter := startTestGame(t, hub, c1, c2)
+	playRound(hub, nailBiter, c1, c2, 4, 5)
+	playRound(hub, nailBiter, c1, c2, 5, 4)
+	playRound(hub, nailBiter, c1, c2, 4, 5)
+	playRound(hub, nailBiter, c1, c2, 5, 4)
+	playRound(hub, nailBiter, c1, c2, 2, 1)
+
+	if !blowout.GameOver || !nailBiter.GameOver || blowout.Winner != 1 || nailBiter.Winner != 1 {
+		t.Fatalf("both games should be won by P1: blowout=%d close=%d", blowout.Winner, nailBiter.Winner)
+	}
+	if blowout.DominanceScore <= nailBiter.DominanceScore {
+		t.Errorf("blowout score %d should exceed nail-biter score %d", blowout.DominanceScore, nailBiter.DominanceScore)
+	}
+
+	end := lastMessageOfType(drainMessages(c1), "game_end")
+	if end == nil || end.Dominance != nailBiter.DominanceScore {
+		t.Errorf("game_end should carry the dominance score, got %+v", end)
+	}
+	record, err := hub.store.LoadGame(blowout.ID)
+	if err != nil || record.DominanceScore != blowout.DominanceScore {
+		t.Errorf("stored dominance score: got %+v, %v", record, err)
+	}
+}
+
+// TestAutoFold tests that an auto-fold player stops contesting once eliminated
+func TestAutoFold(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	hub.handleClientMessage(c1, &Message{Type: "set_auto_fold", Enabled: true})
+	game := startTestGame(t, hub, c1, c2)
+
+	playRound(hub, game, c1, c2, 10, 11)
+	if game.Player1Bid != nil {
+		t.Fatal("P1 can still win and should not auto-fold")
+	}
+
+	// P1 is left with 1 to cover 2 steps while P2 still has 9
+	playRound(hub, game, c1, c2, 9, 0)
+	if game.Player1Bid == nil || *game.Player1Bid != 0 {
+		t.Fatal("eliminated P1 should auto-submit a 0 bid")
+	}
+
+	hub.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	if game.CurrentRound != 4 || game.Player2Pos != 2 {
+		t.Fatalf("round 3 should resolve on P2's bid: round=%d P2 pos=%d", game.CurrentRound, game.Player2Pos)
+	}
+	if game.Player1Bid == nil || *game.Player1Bid != 0 {
+		t.Error("P1 should keep auto-folding in round 4")
+	}
+	hub.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	if !game.GameOver || game.Winner != 2 {
+		t.Errorf("P2 should win, got GameOver=%v Winner=%d", game.GameOver, game.Winner)
+	}
+}
+
+// TestWireLog tests that inbound messages are logged only when the wire log is on
+func TestWireLog(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		var buf bytes.Buffer
+		hub := newHub(DefaultConfig())
+		hub.logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		hub.config.WireLog = enabled
+		c1 := newTestClient(hub)
+		c2 := newTestClient(hub)
+		game := startTestGame(t, hub, c1, c2)
+		buf.Reset()
+
+		hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+		logged := strings.Contains(buf.String(), "type=submit_bid") &&
+			strings.Contains(buf.String(), "user_id="+c1.user.ID) &&
+			strings.Contains(buf.String(), "game_id="+game.ID)
+		if logged != enabled {
+			t.Errorf("wire log enabled=%v: submit_bid logged=%v\n%s", enabled, logged, buf.String())
+		}
+	}
+}
+
+// TestWaitlistPromotion tests that a waitlisted connection is promoted when a slot frees up
+func TestWaitlistPromotion(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.MaxConnections = 2
+	hub.config.WaitlistSize = 1
+
+	clients := make([]*Client, 4)
+	for i := range clients {
+		clients[i] = &Client{hub: hub, send: make(chan []byte, 256)}
+		hub.handleRegister(clients[i])
+	}
+
+	waiting := drainMessages(clients[2])
+	if len(waiting) != 1 || waiting[0].Type != "waitlisted" || waiting[0].Position != 1 {
+		t.Fatalf("third connection should be waitlisted at position 1, got %+v", waiting)
+	}
+	rejected := drainMessages(clients[3])
+	if len(rejected) != 1 || rejected[0].Type != "error" {
+		t.Fatalf("fourth connection should be refused, got %+v", rejected)
+	}
+	if _, open := <-clients[3].send; open {
+		t.Error("refused connection should have its send channel closed")
+	}
+
+	hub.handleUnregister(clients[0])
+	if lastMessageOfType(drainMessages(clients[2]), "welcome") == nil {
+		t.Fatal("waitlisted connection should be welcomed when a slot frees")
+	}
+	if !hub.clients[clients[2]] || len(hub.waitlist) != 0 {
+		t.Errorf("promoted client should be active and the waitlist empty")
+	}
+}
+
+// TestWaitlistedMessagesDropped tests that a waitlisted connection, which
+// has no user yet, can't act before it is admitted
+func TestWaitlistedMessagesDropped(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.MaxConnections = 1
+	hub.config.WaitlistSize = 1
+	handled := make(chan string, 8)
+	hub.messageHook = func(client *Client, msg *Message) {
+		handled <- msg.Type
+	}
+	go hub.run(context.Background())
+	defer hub.shutdown()
+
+	admitted := &Client{hub: hub, send: make(chan []byte, 256)}
+	waiting := &Client{hub: hub, send: make(chan []byte, 256)}
+	hub.register <- admitted
+	welcome := waitForMessage(t, admitted, "welcome")
+	hub.register <- waiting
+	waitForMessage(t, waiting, "waitlisted")
+
+	hub.handleMessage <- &MessageWrapper{client: waiting, message: &Message{Type: "challenge", TargetUserID: welcome.UserID}}
+	hub.handleMessage <- &MessageWrapper{client: admitted, message: &Message{Type: "leaderboard"}}
+	if got := <-handled; got != "leaderboard" {
+		t.Errorf("only the admitted client's message should be handled, got %q first", got)
+	}
+	waitForMessage(t, admitted, "leaderboard")
+	for _, msg := range drainMessages(waiting) {
+		t.Errorf("waitlisted client should get no reply, got %+v", msg)
+	}
+	for _, msg := range drainMessages(admitted) {
+		if msg.Type == "challenge_received" {
+			t.Error("a waitlisted client must not be able to challenge")
+		}
+	}
+}
+
+// startTestGameWith is startTestGame with extra challenge options
+func startTestGameWith(t *testing.T, h *Hub, c1, c2 *Client, challenge Message) *Game {
+	t.Helper()
+	challenge.Type = "challenge"
+	challenge.TargetUserID = c2.user.ID
+	h.handleClientMessage(c1, &challenge)
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if received == nil {
+		t.Fatalf("challenge_received not sent, challenger got %+v", drainMessages(c1))
+	}
+	h.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: received.ChallengeID})
+	start := lastMessageOfType(drainMessages(c1), "game_start")
+	drainMessages(c2)
+	if start == nil {
+		t.Fatal("game_start not sent")
+	}
+	return h.games[start.GameID]
+}
+
+// TestRoundWinTarget tests the first-to-N round-wins victory condition
+func TestRoundWinTarget(t *testing.T) {
+	t.Run("Draws don't count", func(t *testing.T) {
+		hub := newHub(DefaultConfig())
+		c1 := newTestClient(hub)
+		c2 := newTestClient(hub)
+		game := startTestGameWith(t, hub, c1, c2, Message{RoundWinTarget: 2})
+
+		playRound(hub, game, c1, c2, 2, 1) // P1
+		playRound(hub, game, c1, c2, 1, 1) // draw
+		playRound(hub, game, c1, c2, 0, 0) // draw
+		playRound(hub, game, c1, c2, 1, 3) // P2
+		playRound(hub, game, c1, c2, 2, 2) // draw
+		if game.GameOver {
+			t.Fatal("no player has reached 2 round wins yet")
+		}
+		playRound(hub, game, c1, c2, 3, 1) // P1
+
+		if !game.GameOver || game.Winner != 1 || game.Reason != ReasonRoundWinTarget {
+			t.Errorf("expected P1 to win on round wins, got Winner=%d Reason=%s", game.Winner, game.Reason)
+		}
+		if game.Player1RoundWins != 2 || game.Player2RoundWins != 1 {
+			t.Errorf("round wins: got %d/%d, want 2/1", game.Player1RoundWins, game.Player2RoundWins)
+		}
+	})
+
+	t.Run("Positions are ignored", func(t *testing.T) {
+		hub := newHub(DefaultConfig())
+		c1 := newTestClient(hub)
+		c2 := newTestClient(hub)
+		game := startTestGameWith(t, hub, c1, c2, Message{RoundWinTarget: 4})
+
+		for i := 0; i < MAX_STEPS; i++ {
+			playRound(hub, game, c1, c2, 1, 0)
+		}
+		if game.GameOver {
+			t.Fatal("reaching the final step should not end a round-win game")
+		}
+		playRound(hub, game, c1, c2, 1, 0)
+		if !game.GameOver || game.Winner != 1 {
+			t.Errorf("expected P1 to win on the 4th round win, got Winner=%d", game.Winner)
+		}
+	})
+
+	t.Run("Out of range target", func(t *testing.T) {
+		hub := newHub(DefaultConfig())
+		c1 := newTestClient(hub)
+		c2 := newTestClient(hub)
+		hub.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, RoundWinTarget: MAX_ROUND_WIN_TARGET + 1})
+		if lastMessageOfType(drainMessages(c1), "error") == nil {
+			t.Error("out of range round-win target should be rejected")
+		}
+	})
+}
+
+// TestResolveRoundMissingBid tests that resolving with a nil bid is aborted safely
+func TestResolveRoundMissingBid(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	game := MockGame("test-game", MockUser("p1", "Player1"), MockUser("p2", "Player2"))
+	bid := 5
+	game.Player2Bid = &bid
+	game.Status = "RESOLVING"
+
+	hub.resolveRound(game)
+
+	if game.Status != "WAITING_FOR_BIDS" {
+		t.Errorf("status: got %s, want WAITING_FOR_BIDS", game.Status)
+	}
+	if game.Player1Balance != INITIAL_BUDGET || game.Player2Balance != INITIAL_BUDGET || len(game.History) != 0 {
+		t.Error("an aborted resolution must not change balances or history")
+	}
+	if game.Player2Bid == nil || *game.Player2Bid != 5 {
+		t.Error("the submitted bid should be kept")
+	}
+}
+
+// TestLobbyIdleDisconnect tests that idle lobby users are disconnected once past the threshold
+func TestLobbyIdleDisconnect(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	now := time.Now()
+	hub.now = func() time.Time { return now }
+	hub.config.LobbyIdleTimeout = 5 * time.Minute
+
+	idle := newTestClient(hub)
+	active := newTestClient(hub)
+	p1 := newTestClient(hub)
+	p2 := newTestClient(hub)
+	game := startTestGame(t, hub, p1, p2)
+	spectator := newTestClient(hub)
+	hub.handleClientMessage(spectator, &Message{Type: "spectate", GameID: game.ID})
+	queued := newTestClient(hub)
+	hub.handleClientMessage(queued, &Message{Type: "quick_match"})
+
+	now = now.Add(4 * time.Minute)
+	hub.handleClientMessage(active, &Message{Type: "set_auto_fold"})
+	hub.checkIdleUsers()
+	if !hub.clients[idle] {
+		t.Fatal("user should not be disconnected before the threshold")
+	}
+
+	now = now.Add(2 * time.Minute)
+	hub.checkIdleUsers()
+
+	if hub.clients[idle] {
+		t.Error("idle lobby user should be disconnected")
+	}
+	if idle.closeReason != CloseIdleTimeout {
+		t.Errorf("close reason: got %q, want %q", idle.closeReason, CloseIdleTimeout)
+	}
+	if kicked := lastMessageOfType(drainMessages(idle), "kicked_idle"); kicked == nil || kicked.Elapsed != 300 {
+		t.Errorf("idle user should be told before being disconnected, got %+v", kicked)
+	}
+	if _, exists := hub.users[idle.user.ID]; exists {
+		t.Error("idle user should be removed from the lobby")
+	}
+	if !hub.clients[active] {
+		t.Error("recently active user should stay connected")
+	}
+	if !hub.clients[p1] || !hub.clients[p2] {
+		t.Error("users in a game should never be idle-disconnected")
+	}
+	if !hub.clients[spectator] || !hub.clients[queued] {
+		t.Error("spectators and users waiting for a quick match should never be idle-disconnected")
+	}
+}
+
+// TestGraceBid tests that a leader who runs out of balance gets a single grace bid of 1
+func TestGraceBid(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.GraceBid = true
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	playRound(h, game, c1, c2, 19, 0)
+	playRound(h, game, c1, c2, 1, 0)
+	if game.Player1Balance != 1 || !game.Player1GraceUsed {
+		t.Fatalf("leader at 0 balance should get a grace bid: balance=%d used=%v",
+			game.Player1Balance, game.Player1GraceUsed)
+	}
+	result := lastMessageOfType(drainMessages(c1), "round_result")
+	if result == nil || result.P1Balance != 1 {
+		t.Errorf("round_result should show the granted balance, got %+v", result)
+	}
+
+	// Spending the grace bid leaves the leader at 0 again, with no second grant
+	playRound(h, game, c1, c2, 1, 2)
+	if game.Player1Pos <= game.Player2Pos {
+		t.Fatalf("player 1 should still lead: P1=%d P2=%d", game.Player1Pos, game.Player2Pos)
+	}
+	if game.Player1Balance != 0 {
+		t.Errorf("grace bid should only be granted once, balance=%d", game.Player1Balance)
+	}
+	if game.GameOver {
+		t.Error("game should continue while player 2 still has balance")
+	}
+}
+
+// TestFirstBidder tests that round history records whose bid arrived first
+func TestFirstBidder(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	playRound(h, game, c1, c2, 1, 2)
+	h.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 2})
+
+	if len(game.History) != 2 {
+		t.Fatalf("expected 2 rounds of history, got %d", len(game.History))
+	}
+	if game.History[0].FirstBidder != 1 {
+		t.Errorf("round 1 FirstBidder: got %d, want 1", game.History[0].FirstBidder)
+	}
+	if game.History[1].FirstBidder != 2 {
+		t.Errorf("round 2 FirstBidder: got %d, want 2", game.History[1].FirstBidder)
+	}
+}
+
+// TestChallengeLog tests that a challenge and its decline are logged to the store
+func TestChallengeLog(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if received == nil {
+		t.Fatal("challenge_received not sent")
+	}
+	h.handleClientMessage(c2, &Message{Type: "decline_challenge", ChallengeID: received.ChallengeID})
+
+	entries, err := h.store.ChallengeLog()
+	if err != nil {
+		t.Fatalf("ChallengeLog: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log rows, got %d", len(entries))
+	}
+	for i, event := range []string{ChallengeSent, ChallengeDeclined} {
+		entry := entries[i]
+		if entry.Event != event || entry.ChallengeID != received.ChallengeID ||
+			entry.FromUserID != c1.user.ID || entry.ToUserID != c2.user.ID {
+			t.Errorf("row %d: got %+v, want %s from %s to %s", i, entry, event, c1.user.ID, c2.user.ID)
+		}
+	}
+
+	report := findChallengeAbuse(entries, 1, 1.0)
+	if len(report) != 1 || report[0].UserID != c1.user.ID || report[0].Declined != 1 {
+		t.Errorf("challenger should be reported with a full decline rate, got %+v", report)
+	}
+}
+
+// TestPercentOfBalance tests the conversion of a bid percentage to an amount
+func TestPercentOfBalance(t *testing.T) {
+	tests := []struct {
+		balance, percent, want int
+	}{
+		{20, 0, 0},
+		{20, 50, 10},
+		{20, 100, 20},
+		{7, 50, 3}, // floored
+		{3, 33, 0},
+		{0, 100, 0},
+	}
+	for _, tt := range tests {
+		if got := percentOfBalance(tt.balance, tt.percent); got != tt.want {
+			t.Errorf("percentOfBalance(%d, %d) = %d, want %d", tt.balance, tt.percent, got, tt.want)
+		}
+	}
+}
+
+// TestBidPercent tests percentage bids and the rejection of ambiguous or out-of-range ones
+func TestBidPercent(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	percent := 25
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3, BidPercent: &percent})
+	if game.Player1Bid != nil {
+		t.Fatal("a message with both bid and bidPercent should be rejected")
+	}
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrBidAndPercent) {
+		t.Errorf("expected %s error, got %+v", ErrBidAndPercent, errMsg)
+	}
+
+	tooMuch := 101
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, BidPercent: &tooMuch})
+	if game.Player1Bid != nil {
+		t.Fatal("a percentage above 100 should be rejected")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, BidPercent: &percent})
+	if game.Player1Bid == nil || *game.Player1Bid != 5 {
+		t.Fatalf("25%% of 20 should bid 5, got %v", game.Player1Bid)
+	}
+}
+
+// TestOpenChallengeFirstClaimWins tests that the first user to accept an open challenge gets the game
+func TestOpenChallengeFirstClaimWins(t *testing.T) {
+	h := newHub(DefaultConfig())
+	creator := newTestClient(h)
+	first := newTestClient(h)
+	second := newTestClient(h)
+	drainMessages(creator)
+	drainMessages(first)
+	drainMessages(second)
+
+	h.handleClientMessage(creator, &Message{Type: "open_challenge", Note: "anyone?"})
+	created := lastMessageOfType(drainMessages(creator), "open_challenge_created")
+	if created == nil {
+		t.Fatal("creator should get open_challenge_created")
+	}
+	for _, c := range []*Client{first, second} {
+		available := lastMessageOfType(drainMessages(c), "open_challenge_available")
+		if available == nil || available.ChallengeID != created.ChallengeID || available.FromUserID != creator.user.ID {
+			t.Fatalf("lobby user should see the open challenge, got %+v", available)
+		}
+	}
+
+	h.handleClientMessage(first, &Message{Type: "accept_challenge", ChallengeID: created.ChallengeID})
+	start := lastMessageOfType(drainMessages(first), "game_start")
+	if start == nil || start.OpponentID != creator.user.ID {
+		t.Fatalf("first accepter should start a game with the creator, got %+v", start)
+	}
+	if lastMessageOfType(drainMessages(second), "challenge_taken") == nil {
+		t.Error("other lobby users should be told the challenge was taken")
+	}
+
+	h.handleClientMessage(second, &Message{Type: "accept_challenge", ChallengeID: created.ChallengeID})
+	if second.user.InGame || len(h.games) != 1 {
+		t.Errorf("a late accept should not start a game: inGame=%v games=%d", second.user.InGame, len(h.games))
+	}
+}
+
+// TestOpenChallengeCancel tests that the creator can withdraw an open challenge
+func TestOpenChallengeCancel(t *testing.T) {
+	h := newHub(DefaultConfig())
+	creator := newTestClient(h)
+	other := newTestClient(h)
+
+	h.handleClientMessage(creator, &Message{Type: "open_challenge"})
+	created := lastMessageOfType(drainMessages(creator), "open_challenge_created")
+	if created == nil {
+		t.Fatal("creator should get open_challenge_created")
+	}
+
+	// Only the creator can cancel
+	h.handleClientMessage(other, &Message{Type: "cancel_challenge", ChallengeID: created.ChallengeID})
+	if _, exists := h.challenges[created.ChallengeID]; !exists {
+		t.Fatal("another user should not be able to cancel the challenge")
+	}
+
+	drainMessages(other)
+	h.handleClientMessage(creator, &Message{Type: "cancel_challenge", ChallengeID: created.ChallengeID})
+	if _, exists := h.challenges[created.ChallengeID]; exists {
+		t.Fatal("cancelled challenge should be removed")
+	}
+	cancelled := lastMessageOfType(drainMessages(other), "challenge_cancelled")
+	if cancelled == nil || cancelled.ChallengeID != created.ChallengeID {
+		t.Errorf("lobby should be told the challenge was cancelled, got %+v", cancelled)
+	}
+
+	h.handleClientMessage(other, &Message{Type: "accept_challenge", ChallengeID: created.ChallengeID})
+	if len(h.games) != 0 {
+		t.Error("a cancelled challenge should not be acceptable")
+	}
+}
+
+// TestCancelDirectedChallenge tests that a challenger can withdraw a challenge sent to one user
+func TestCancelDirectedChallenge(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if received == nil {
+		t.Fatal("challenge_received not sent")
+	}
+
+	// The recipient can't cancel it, and neither can anyone cancel an unknown one
+	for _, cancel := range []struct {
+		client      *Client
+		challengeID string
+	}{{c2, received.ChallengeID}, {c1, "no-such-challenge"}} {
+		h.handleClientMessage(cancel.client, &Message{Type: "cancel_challenge", ChallengeID: cancel.challengeID})
+		if errMsg := lastMessageOfType(drainMessages(cancel.client), "error"); errMsg == nil || errMsg.ErrorCode != ErrUnknownChallenge {
+			t.Errorf("expected %s error, got %+v", ErrUnknownChallenge, errMsg)
+		}
+	}
+	if _, exists := h.challenges[received.ChallengeID]; !exists {
+		t.Fatal("only the challenger should be able to cancel")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "cancel_challenge", ChallengeID: received.ChallengeID})
+	if _, exists := h.challenges[received.ChallengeID]; exists {
+		t.Fatal("cancelled challenge should be removed")
+	}
+	if cancelled := lastMessageOfType(drainMessages(c2), "challenge_cancelled"); cancelled == nil || cancelled.ChallengeID != received.ChallengeID {
+		t.Errorf("recipient should be told the challenge was cancelled, got %+v", cancelled)
+	}
+}
+
+// TestChallengeDisconnectNotifiesOtherParty tests that whichever side of a
+// challenge disconnects, the other side is told it's gone
+func TestChallengeDisconnectNotifiesOtherParty(t *testing.T) {
+	for _, leaver := range []string{"challenger", "recipient"} {
+		t.Run(leaver, func(t *testing.T) {
+			h := newHub(DefaultConfig())
+			h.config.ReconnectGrace = 0
+			from := newTestClient(h)
+			to := newTestClient(h)
+			h.handleClientMessage(from, &Message{Type: "challenge", TargetUserID: to.user.ID})
+			received := lastMessageOfType(drainMessages(to), "challenge_received")
+			if received == nil {
+				t.Fatal("challenge_received not sent")
+			}
+			drainMessages(from)
+
+			leaving, staying := from, to
+			if leaver == "recipient" {
+				leaving, staying = to, from
+			}
+			h.handleUnregister(leaving)
+
+			if len(h.challenges) != 0 {
+				t.Fatal("challenge should be removed")
+			}
+			expired := lastMessageOfType(drainMessages(staying), "challenge_expired")
+			if expired == nil || expired.ChallengeID != received.ChallengeID {
+				t.Errorf("remaining party should get challenge_expired, got %+v", expired)
+			}
+		})
+	}
+}
+
+// TestOpponentThinkingPulses tests that a player who has bid gets opponent_thinking pulses
+func TestOpponentThinkingPulses(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.ThinkingPulseInterval = 2 * time.Second
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	// No pulses while nobody has bid
+	now = now.Add(3 * time.Second)
+	h.sendThinkingPulses()
+	if lastMessageOfType(drainMessages(c1), "opponent_thinking") != nil {
+		t.Fatal("no pulse expected before any bid")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 2})
+	h.sendThinkingPulses()
+	pulse := lastMessageOfType(drainMessages(c1), "opponent_thinking")
+	if pulse == nil || pulse.GameID != game.ID || pulse.Elapsed != 3 {
+		t.Fatalf("submitter should get a pulse with the elapsed time, got %+v", pulse)
+	}
+	if lastMessageOfType(drainMessages(c2), "opponent_thinking") != nil {
+		t.Error("the player still deciding should not get pulses")
+	}
+
+	// Pulses are spaced by the interval
+	now = now.Add(time.Second)
+	h.sendThinkingPulses()
+	if lastMessageOfType(drainMessages(c1), "opponent_thinking") != nil {
+		t.Error("pulse sent before the interval elapsed")
+	}
+	now = now.Add(time.Second)
+	h.sendThinkingPulses()
+	if pulse := lastMessageOfType(drainMessages(c1), "opponent_thinking"); pulse == nil || pulse.Elapsed != 5 {
+		t.Errorf("expected a second pulse at 5s, got %+v", pulse)
+	}
+
+	// Pulses stop once both bids are in
+	h.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	drainMessages(c1)
+	now = now.Add(5 * time.Second)
+	h.sendThinkingPulses()
+	if lastMessageOfType(drainMessages(c1), "opponent_thinking") != nil {
+		t.Error("no pulse expected once the round resolved")
+	}
+}
+
+// TestPauseAndResume tests pausing by mutual consent and resuming
+func TestPauseAndResume(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c1, &Message{Type: "request_pause", GameID: game.ID})
+	if lastMessageOfType(drainMessages(c2), "pause_requested") == nil {
+		t.Fatal("opponent should be asked to pause")
+	}
+
+	// The requester can't accept their own request
+	h.handleClientMessage(c1, &Message{Type: "accept_pause", GameID: game.ID})
+	if game.Status == "PAUSED" {
+		t.Fatal("the game should only pause with the opponent's consent")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "accept_pause", GameID: game.ID})
+	if game.Status != "PAUSED" {
+		t.Fatalf("status: got %s, want PAUSED", game.Status)
+	}
+	if lastMessageOfType(drainMessages(c1), "game_paused") == nil {
+		t.Error("players should be told the game is paused")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 2})
+	if game.Player1Bid != nil {
+		t.Fatal("bids should be rejected while paused")
+	}
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrGamePaused) {
+		t.Errorf("expected %s error, got %+v", ErrGamePaused, errMsg)
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "resume", GameID: game.ID})
+	if game.Status != "WAITING_FOR_BIDS" {
+		t.Fatalf("status after resume: got %s, want WAITING_FOR_BIDS", game.Status)
+	}
+	if lastMessageOfType(drainMessages(c1), "game_resumed") == nil {
+		t.Error("players should be told the game resumed")
+	}
+	playRound(h, game, c1, c2, 2, 1)
+	if game.Player1Pos != 1 {
+		t.Errorf("bids should be accepted again after resume, P1 pos=%d", game.Player1Pos)
+	}
+}
+
+// TestPauseRequestLapses tests that a pause request can be declined and
+// lapses when the next round opens, so it never blocks a later request
+func TestPauseRequestLapses(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c1, &Message{Type: "request_pause", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "decline_pause", GameID: game.ID})
+	if game.PauseRequestedBy != 0 {
+		t.Fatal("a declined request should be cleared")
+	}
+	if lastMessageOfType(drainMessages(c1), "pause_declined") == nil {
+		t.Error("the requester should be told the pause was declined")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "request_pause", GameID: game.ID})
+	playRound(h, game, c1, c2, 1, 0)
+	if game.PauseRequestedBy != 0 {
+		t.Fatal("an unanswered request should lapse when the next round opens")
+	}
+	if lastMessageOfType(drainMessages(c2), "pause_request_expired") == nil {
+		t.Error("players should be told the request lapsed")
+	}
+	h.handleClientMessage(c2, &Message{Type: "accept_pause", GameID: game.ID})
+	if game.Status == "PAUSED" {
+		t.Fatal("a lapsed request can't be accepted")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "request_pause", GameID: game.ID})
+	if lastMessageOfType(drainMessages(c1), "pause_requested") == nil {
+		t.Error("a new request should go through once the old one lapsed")
+	}
+}
+
+// TestPauseTimeoutAutoResume tests that a pause ends on its own after MaxPauseDuration
+func TestPauseTimeoutAutoResume(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 5 * time.Second
+	h.config.MaxPauseDuration = time.Minute
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	// Pause during the reveal so the reveal timer has to stand still
+	playRound(h, game, c1, c2, 2, 1)
+	deadline := game.RevealDeadline
+	h.handleClientMessage(c1, &Message{Type: "request_pause", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "accept_pause", GameID: game.ID})
+	if game.Status != "PAUSED" {
+		t.Fatalf("status: got %s, want PAUSED", game.Status)
+	}
+
+	now = now.Add(30 * time.Second)
+	h.checkPauseTimeouts()
+	h.checkRevealTimeouts()
+	if game.Status != "PAUSED" {
+		t.Fatalf("game should stay paused before the maximum, got %s", game.Status)
+	}
+
+	now = now.Add(30 * time.Second)
+	h.checkPauseTimeouts()
+	if game.Status != "REVEALING" {
+		t.Fatalf("game should auto-resume to REVEALING, got %s", game.Status)
+	}
+	if !game.RevealDeadline.Equal(deadline.Add(time.Minute)) {
+		t.Errorf("reveal deadline should move by the paused time: got %v, want %v", game.RevealDeadline, deadline.Add(time.Minute))
+	}
+	if lastMessageOfType(drainMessages(c2), "game_resumed") == nil {
+		t.Error("players should be told the game resumed")
+	}
+}
+
+// TestGameMessagesBeatLobbyUpdates tests that game messages are delivered ahead of queued lobby updates
+func TestGameMessagesBeatLobbyUpdates(t *testing.T) {
+	h := newHub(DefaultConfig())
+	client := &Client{hub: h, send: make(chan []byte, 256), lobby: make(chan []byte, 256)}
+
+	for i := 0; i < 5; i++ {
+		h.sendToClient(client, &Message{Type: "users_update"})
+	}
+	h.sendToClient(client, &Message{Type: "round_result", GameID: "g"})
+
+	var order []string
+	for {
+		data, ok, ready := client.nextQueued()
+		if !ready {
+			break
+		}
+		if !ok {
+			t.Fatal("send channel should still be open")
+		}
+		var msg Message
+		json.Unmarshal(data, &msg)
+		order = append(order, msg.Type)
+	}
+
+	if len(order) != 6 {
+		t.Fatalf("expected 6 messages, got %v", order)
+	}
+	if order[0] != "round_result" {
+		t.Errorf("game message should be delivered first, got order %v", order)
+	}
+}
+
+// TestMutualRematch tests that both players asking for a rematch within the window starts a new game
+func TestMutualRematch(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(h, game, c1, c2, 2, 1)
+	}
+	drainMessages(c1)
+	drainMessages(c2)
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	if lastMessageOfType(drainMessages(c2), "rematch_received") == nil {
+		t.Fatal("opponent should be asked for a rematch")
+	}
+	if lastMessageOfType(drainMessages(c1), "game_start") != nil {
+		t.Fatal("a single request should not start a game")
+	}
+
+	now = now.Add(5 * time.Second)
+	h.handleClientMessage(c2, &Message{Type: "rematch", GameID: game.ID})
+	start1 := lastMessageOfType(drainMessages(c1), "game_start")
+	start2 := lastMessageOfType(drainMessages(c2), "game_start")
+	if start1 == nil || start2 == nil || start1.GameID != start2.GameID || start1.GameID == game.ID {
+		t.Fatalf("both players should be put in a new game: %+v %+v", start1, start2)
+	}
+	if len(h.rematches) != 0 {
+		t.Error("the rematch request should be cleared once started")
+	}
+}
+
+// TestRematchWindowExpires tests that a request outside the window falls back to asking again
+func TestRematchWindowExpires(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+	drainMessages(c1)
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	now = now.Add(h.config.RematchWindow + time.Second)
+	h.handleClientMessage(c2, &Message{Type: "rematch", GameID: game.ID})
+
+	if lastMessageOfType(drainMessages(c2), "game_start") != nil {
+		t.Fatal("a stale request should not start a rematch")
+	}
+	if lastMessageOfType(drainMessages(c1), "rematch_received") == nil {
+		t.Error("the late requester's opponent should be asked instead")
+	}
+}
+
+// TestLobbyScopedUserList tests that users only see user-list updates for their own lobby
+func TestLobbyScopedUserList(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.UserListBatchWindow = 0
+	a := newTestClient(h)
+	b := newTestClient(h)
+
+	h.handleClientMessage(b, &Message{Type: "join_lobby", Lobby: "beginners"})
+	if joined := lastMessageOfType(drainMessages(b), "lobby_joined"); joined == nil || joined.Lobby != "beginners" {
+		t.Fatalf("expected lobby_joined, got %+v", joined)
+	}
+	drainMessages(a)
+
+	// Once a newcomer moves to beginners, main's list no longer shows them
+	c := newTestClient(h)
+	h.handleClientMessage(c, &Message{Type: "join_lobby", Lobby: "beginners"})
+
+	mainUpdate := lastMessageOfType(drainMessages(a), "users_update")
+	if mainUpdate == nil {
+		t.Fatal("main lobby should get a users_update")
+	}
+	for _, info := range mainUpdate.Users {
+		if info.UserID == b.user.ID || info.UserID == c.user.ID {
+			t.Errorf("main lobby should not list %s from beginners", info.Username)
+		}
+	}
+
+	update := lastMessageOfType(drainMessages(b), "users_update")
+	if update == nil || len(update.Users) != 2 {
+		t.Fatalf("beginners lobby should list its 2 users, got %+v", update)
+	}
+
+	// Open challenges stay in their lobby too
+	h.handleClientMessage(b, &Message{Type: "open_challenge"})
+	if lastMessageOfType(drainMessages(a), "open_challenge_available") != nil {
+		t.Error("an open challenge should not reach other lobbies")
+	}
+	if lastMessageOfType(drainMessages(c), "open_challenge_available") == nil {
+		t.Error("an open challenge should reach its own lobby")
+	}
+
+	h.handleClientMessage(b, &Message{Type: "leave_lobby"})
+	if b.user.Lobby != DEFAULT_LOBBY {
+		t.Errorf("leave_lobby should return to %s, got %s", DEFAULT_LOBBY, b.user.Lobby)
+	}
+}
+
+// TestConnectedLifecycle tests that a fresh connection gets welcome followed by connected
+func TestConnectedLifecycle(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c := newTestClient(h)
+
+	msgs := drainMessages(c)
+	if len(msgs) < 2 || msgs[0].Type != "welcome" || msgs[1].Type != LifecycleConnected {
+		t.Fatalf("expected welcome then connected, got %+v", msgs)
+	}
+	if msgs[1].UserID != c.user.ID {
+		t.Errorf("connected should carry the user id, got %q", msgs[1].UserID)
+	}
+}
+
+// TestReconnectWithinGrace tests that a dropped player can pick their game back up with their session token
+func TestReconnectWithinGrace(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	user := c1.user
+	welcome := lastMessageOfType(drainMessages(c1), "welcome")
+	if welcome.ActiveGameID != "" {
+		t.Errorf("a new session has no game to return to, got %q", welcome.ActiveGameID)
+	}
+	token := welcome.Token
+	game := startTestGame(t, h, c1, c2)
+	drainMessages(c2)
+
+	h.handleUnregister(c1)
+	if _, ok := h.games[game.ID]; !ok {
+		t.Fatal("game should survive a dropped connection during the grace period")
+	}
+	if _, ok := h.users[user.ID]; !ok {
+		t.Fatal("absent user should be kept during the grace period")
+	}
+	if lastMessageOfType(drainMessages(c2), "opponent_absent") == nil {
+		t.Error("opponent should be told the player dropped")
+	}
+
+	now = now.Add(10 * time.Second)
+	h.checkAbsentUsers()
+	c3 := newTestClient(h)
+	fresh := c3.user
+	h.handleClientMessage(c3, &Message{Type: "quick_match"})
+	drainMessages(c3)
+	h.handleClientMessage(c3, &Message{Type: "resume", Token: token})
+	if c3.user != user || user.Client != c3 {
+		t.Fatal("new connection should be attached to the original user")
+	}
+	if _, ok := h.users[fresh.ID]; ok {
+		t.Error("the placeholder user for the new connection should be removed")
+	}
+	if h.isQueued(fresh) {
+		t.Error("the placeholder user should leave the quick-match queue")
+	}
+	msgs := drainMessages(c3)
+	if welcome := lastMessageOfType(msgs, "welcome"); welcome == nil || welcome.UserID != user.ID || welcome.SessionStats == nil {
+		t.Errorf("the welcome should describe the resumed user, got %+v", welcome)
+	} else if welcome.ActiveGameID != game.ID {
+		t.Errorf("the welcome should point back to the live game, got %q", welcome.ActiveGameID)
+	}
+	if lastMessageOfType(msgs, LifecycleReconnected) == nil {
+		t.Error("client should be told it reconnected")
+	}
+	if lastMessageOfType(msgs, "waiting_for_bids") == nil {
+		t.Error("client should be sent the current round")
+	}
+	if board := lastMessageOfType(msgs, "board_state"); board == nil || board.GameID != game.ID || board.YourBalance != INITIAL_BUDGET {
+		t.Errorf("client should be sent the board, got %+v", board)
+	}
+	if lastMessageOfType(drainMessages(c2), "opponent_reconnected") == nil {
+		t.Error("opponent should be told the player is back")
+	}
+
+	playRound(h, game, c3, c2, 2, 1)
+	if game.Player1Pos != 1 {
+		t.Errorf("reconnected player should be able to bid, P1 pos=%d", game.Player1Pos)
+	}
+}
+
+// TestRejoinGame tests that a dropped player can rejoin by game and user ID
+// within the grace period, and nobody else can take their seat
+func TestRejoinGame(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	user := c1.user
+	game := startTestGame(t, h, c1, c2)
+	rejoin := &Message{Type: "rejoin_game", GameID: game.ID, UserID: user.ID}
+
+	// Not while the player is still connected
+	intruder := newTestClient(h)
+	h.handleClientMessage(intruder, rejoin)
+	if errMsg := lastMessageOfType(drainMessages(intruder), "error"); errMsg == nil || errMsg.ErrorCode != ErrCannotRejoin {
+		t.Errorf("a connected player's seat can't be rejoined, got %+v", errMsg)
+	}
+
+	h.handleUnregister(c1)
+	c3 := newTestClient(h)
+	h.handleClientMessage(c3, &Message{Type: "rejoin_game", GameID: game.ID, UserID: c2.user.ID})
+	if c3.user == c2.user {
+		t.Fatal("the opponent's seat can't be taken while they are connected")
+	}
+	drainMessages(c3)
+	h.handleClientMessage(c3, rejoin)
+	if c3.user != user || user.Client != c3 || !user.AbsentSince.IsZero() {
+		t.Fatal("the new connection should be attached to the dropped player")
+	}
+	msgs := drainMessages(c3)
+	if welcome := lastMessageOfType(msgs, "welcome"); welcome == nil || welcome.ActiveGameID != game.ID {
+		t.Errorf("the welcome should point back to the game, got %+v", welcome)
+	}
+	if board := lastMessageOfType(msgs, "board_state"); board == nil || board.GameID != game.ID {
+		t.Errorf("the rejoined player should be sent the board, got %+v", board)
+	}
+	playRound(h, game, c3, c2, 2, 1)
+	if game.Player1Pos != 1 {
+		t.Errorf("the rejoined player should be able to bid, P1 pos=%d", game.Player1Pos)
+	}
+}
+
+// TestReconnectGraceExpires tests that the game ends once an absent player runs out of time
+func TestReconnectGraceExpires(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.ReconnectGrace = 30 * time.Second
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	user := c1.user
+	token := lastMessageOfType(drainMessages(c1), "welcome").Token
+	game := startTestGame(t, h, c1, c2)
+	drainMessages(c2)
+
+	h.handleUnregister(c1)
+	now = now.Add(31 * time.Second)
+	h.checkAbsentUsers()
+	if _, ok := h.games[game.ID]; ok {
+		t.Fatal("game should end when the grace period runs out")
+	}
+	if _, ok := h.users[user.ID]; ok {
+		t.Error("absent user should be removed when the grace period runs out")
+	}
+	if lastMessageOfType(drainMessages(c2), "opponent_disconnected") == nil {
+		t.Error("opponent should be told the player left")
+	}
+
+	c3 := newTestClient(h)
+	h.handleClientMessage(c3, &Message{Type: "resume", Token: token})
+	if lastMessageOfType(drainMessages(c3), LifecycleSessionExpired) == nil {
+		t.Error("a late reconnect should be told the session expired")
+	}
+}
+
+// TestAcceptRematch tests the explicit accept and decline answers to a rematch request
+func TestAcceptRematch(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+
+	// Nothing to accept before the opponent asks
+	drainMessages(c2)
+	h.handleClientMessage(c2, &Message{Type: "accept_rematch", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrNoRematchRequest) {
+		t.Fatalf("expected %s error, got %+v", ErrNoRematchRequest, errMsg)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "decline_rematch", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrRematchDeclined) {
+		t.Fatalf("expected %s error, got %+v", ErrRematchDeclined, errMsg)
+	}
+	if len(h.rematches) != 0 {
+		t.Fatal("a declined request should be cleared")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "accept_rematch", GameID: game.ID})
+	start1 := lastMessageOfType(drainMessages(c1), "game_start")
+	start2 := lastMessageOfType(drainMessages(c2), "game_start")
+	if start1 == nil || start2 == nil || start1.GameID != start2.GameID {
+		t.Fatalf("both players should be put in a new game: %+v %+v", start1, start2)
+	}
+	if start1.YourPlayer != 1 || start2.YourPlayer != 2 {
+		t.Errorf("seats should be kept, got %d and %d", start1.YourPlayer, start2.YourPlayer)
+	}
+	if _, ok := h.games[game.ID]; ok {
+		t.Error("the finished game should be removed once the rematch starts")
+	}
+	rematch := h.games[start1.GameID]
+	if rematch.Player1Balance != INITIAL_BUDGET || rematch.Player1Pos != 0 || rematch.CurrentRound != 1 {
+		t.Errorf("rematch should start fresh, got balance=%d pos=%d round=%d", rematch.Player1Balance, rematch.Player1Pos, rematch.CurrentRound)
+	}
+}
+
+// TestAcceptRematchOpponentLeft tests accepting a rematch from a player who has gone
+func TestAcceptRematchOpponentLeft(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	h.handleUnregister(c1)
+	drainMessages(c2)
+
+	h.handleClientMessage(c2, &Message{Type: "accept_rematch", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrOpponentLeft) {
+		t.Fatalf("expected %s error, got %+v", ErrOpponentLeft, errMsg)
+	}
+}
+
+// TestBidTimeout tests that missing bids count as 0 once the round's deadline passes
+func TestBidTimeout(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.BidTimeout = 20 * time.Second
+	h.config.RevealAckTimeout = 0
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	// A client catching up mid-round sees the time that's left
+	now = now.Add(5 * time.Second)
+	h.sendWaitingForBids(game)
+	if wait := lastMessageOfType(drainMessages(c1), "waiting_for_bids"); wait == nil || wait.SecondsLeft != 15 {
+		t.Fatalf("waiting_for_bids should carry the countdown, got %+v", wait)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+	now = now.Add(14 * time.Second)
+	h.checkBidTimeouts()
+	if game.Player2Bid != nil {
+		t.Fatal("round should stay open until the deadline")
+	}
+
+	now = now.Add(time.Second)
+	h.checkBidTimeouts()
+	result := lastMessageOfType(drainMessages(c2), "round_result")
+	if result == nil || result.P2Bid != 0 || result.Result != "P1_WINS_ROUND" || result.TimedOut != 2 {
+		t.Fatalf("player 2 should bid 0 by timeout, got %+v", result)
+	}
+
+	// Neither player bids: a double timeout resolves with both bids 0
+	now = now.Add(20 * time.Second)
+	h.checkBidTimeouts()
+	last := game.History[len(game.History)-1]
+	if last.TimedOut != 3 || last.P1Bid != 0 || last.P2Bid != 0 {
+		t.Errorf("double timeout should be recorded with both bids 0, got %+v", last)
+	}
+}
+
+// TestChallengeGameParameters tests per-game step and budget settings chosen with a challenge
+func TestChallengeGameParameters(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+
+	for _, bad := range []Message{{MaxSteps: 1}, {MaxSteps: 11}, {InitialBudget: 4}, {InitialBudget: 201}} {
+		bad.Type = "challenge"
+		bad.TargetUserID = c2.user.ID
+		h.handleClientMessage(c1, &bad)
+		if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil {
+			t.Errorf("challenge with maxSteps=%d initialBudget=%d should be rejected", bad.MaxSteps, bad.InitialBudget)
+		}
+	}
+	if len(h.challenges) != 0 {
+		t.Fatalf("rejected challenges should not be stored, got %d", len(h.challenges))
+	}
+
+	game := startTestGameWith(t, h, c1, c2, Message{MaxSteps: 5, InitialBudget: 50})
+	if game.Player1Balance != 50 || game.Player2Balance != 50 {
+		t.Fatalf("balances: got %d/%d, want 50/50", game.Player1Balance, game.Player2Balance)
+	}
+	if config := h.gameConfig(game); config.MaxSteps != 5 || config.InitialBudget != 50 {
+		t.Errorf("game config should report the chosen parameters, got %+v", config)
+	}
+
+	for i := 0; i < 4; i++ {
+		playRound(h, game, c1, c2, 2, 1)
+	}
+	if game.GameOver {
+		t.Fatalf("game should not end before step 5, P1 pos=%d", game.Player1Pos)
+	}
+	playRound(h, game, c1, c2, 2, 1)
+	if !game.GameOver || game.Winner != 1 || game.Reason != ReasonReachedFinalStep {
+		t.Errorf("P1 should win at step 5, got Winner=%d Reason=%s", game.Winner, game.Reason)
+	}
+}
+
+// TestShutdown tests that shutdown closes every connection and aborts games in progress
+func TestShutdown(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	go h.run(context.Background())
+
+	h.shutdown()
+	for _, c := range []*Client{c1, c2} {
+		msgs := drainMessages(c)
+		if lastMessageOfType(msgs, "server_shutdown") == nil {
+			t.Error("clients should be told the server is shutting down")
+		}
+		if end := lastMessageOfType(msgs, "game_end"); end == nil || end.ReasonCode != ReasonServerShutdown {
+			t.Errorf("games in progress should end as aborted, got %+v", end)
+		}
+		if _, open := <-c.send; open {
+			t.Error("send channel should be closed")
+		}
+	}
+	if record, err := h.store.LoadGame(game.ID); err != nil || record.ReasonCode != ReasonServerShutdown {
+		t.Errorf("aborted game should be saved, got %+v, %v", record, err)
+	}
+
+	// A second call returns at once
+	h.shutdown()
+}
+
+// TestRunStopsOnContextCancel tests that cancelling the run context stops the hub loop
+func TestRunStopsOnContextCancel(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c := newTestClient(h)
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.run(ctx)
+	cancel()
+
+	select {
+	case <-h.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("run should return once the context is cancelled")
+	}
+	if lastMessageOfType(drainMessages(c), "server_shutdown") == nil {
+		t.Error("clients should be told the server is shutting down")
+	}
+}
+
+// TestFinishedGameRemovedByHubLoop tests that finished games are removed on
+// the hub goroutine; run with -race to check for concurrent map access
+func TestFinishedGameRemovedByHubLoop(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.FinishedGameRetention = 10 * time.Millisecond
+	// Count games from the hub goroutine itself
+	gameCount := make(chan int, 1)
+	hub.messageHook = func(client *Client, msg *Message) {
+		if msg.Type == "count_games" {
+			gameCount <- len(hub.games)
+		}
+	}
+	go hub.run(context.Background())
+	defer hub.shutdown()
+
+	c1 := &Client{hub: hub, send: make(chan []byte, 256)}
+	c2 := &Client{hub: hub, send: make(chan []byte, 256)}
+	hub.register <- c1
+	hub.register <- c2
+	waitForMessage(t, c1, "welcome")
+	welcome := waitForMessage(t, c2, "welcome")
+
+	hub.handleMessage <- &MessageWrapper{client: c1, message: &Message{Type: "challenge", TargetUserID: welcome.UserID}}
+	received := waitForMessage(t, c2, "challenge_received")
+	hub.handleMessage <- &MessageWrapper{client: c2, message: &Message{Type: "accept_challenge", ChallengeID: received.ChallengeID}}
+	start := waitForMessage(t, c1, "game_start")
+	hub.handleMessage <- &MessageWrapper{client: c1, message: &Message{Type: "resign", GameID: start.GameID}}
+	waitForMessage(t, c1, "game_end")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.handleMessage <- &MessageWrapper{client: c1, message: &Message{Type: "count_games"}}
+		if <-gameCount == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("finished game was not removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestRoundLimit tests that a game of endless zero bids ends at the round cap
+func TestRoundLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(game *Game)
+		wantWinner int
+	}{
+		{"Higher position wins", func(game *Game) { game.Player2Pos = 1 }, 2},
+		{"Then higher balance", func(game *Game) { game.Player1Balance = 15 }, 2},
+		{"Otherwise a draw", func(game *Game) {}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub(DefaultConfig())
+			h.config.MaxRounds = 5
+			h.config.RevealAckTimeout = 0
+			c1 := newTestClient(h)
+			c2 := newTestClient(h)
+			game := startTestGame(t, h, c1, c2)
+			tt.setup(game)
+
+			for i := 0; i < 4; i++ {
+				playRound(h, game, c1, c2, 0, 0)
+			}
+			if game.GameOver {
+				t.Fatalf("game ended before the round cap at round %d", game.CurrentRound)
+			}
+			playRound(h, game, c1, c2, 0, 0)
+			if !game.GameOver || game.Reason != ReasonRoundLimit || game.Winner != tt.wantWinner {
+				t.Errorf("got GameOver=%v Winner=%d Reason=%s, want winner %d by %s",
+					game.GameOver, game.Winner, game.Reason, tt.wantWinner, ReasonRoundLimit)
+			}
+		})
+	}
+}
+
+// TestBidCommitted tests that the opponent learns a bid is in, but not its amount
+func TestBidCommitted(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 7})
+	committed := lastMessageOfType(drainMessages(c1), "bid_committed")
+	if committed == nil || committed.BidReady != 2 || committed.GameID != game.ID {
+		t.Fatalf("opponent should be told player 2 has bid, got %+v", committed)
+	}
+	if committed.Bid != 0 || committed.P2Bid != 0 {
+		t.Error("bid_committed must not reveal the amount")
+	}
+	if lastMessageOfType(drainMessages(c2), "bid_committed") != nil {
+		t.Error("the bidder should not be sent bid_committed")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+	if lastMessageOfType(drainMessages(c2), "bid_committed") != nil {
+		t.Error("the round resolves once both bids are in, with no bid_committed")
+	}
+}
+
+// TestHideBalance tests that with hideBalance each player only sees their
+// own balance while spectators see both
+func TestHideBalance(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	game := startTestGameWith(t, h, c1, c2, Message{HideBalance: true})
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	drainMessages(watcher)
+
+	playRound(h, game, c1, c2, 5, 3)
+	p1Msgs, p2Msgs, watcherMsgs := drainMessages(c1), drainMessages(c2), drainMessages(watcher)
+	for _, msgType := range []string{"round_result", "waiting_for_bids"} {
+		if msg := lastMessageOfType(p1Msgs, msgType); msg == nil || msg.P1Balance != INITIAL_BUDGET-5 || msg.P2Balance != 0 || msg.OpponentBalance != 0 {
+			t.Errorf("P1 %s should only carry P1's balance, got %+v", msgType, msg)
+		}
+		if msg := lastMessageOfType(p2Msgs, msgType); msg == nil || msg.P1Balance != 0 || msg.P2Balance != INITIAL_BUDGET-3 || msg.OpponentBalance != 0 {
+			t.Errorf("P2 %s should only carry P2's balance, got %+v", msgType, msg)
+		}
+		if msg := lastMessageOfType(watcherMsgs, msgType); msg == nil || msg.P1Balance != INITIAL_BUDGET-5 || msg.P2Balance != INITIAL_BUDGET-3 {
+			t.Errorf("spectator %s should carry both balances, got %+v", msgType, msg)
+		}
+	}
+}
+
+// TestPlayerStateViews tests that each player's state messages carry the
+// game from their own side and spectators only get the shared fields
+func TestPlayerStateViews(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	drainMessages(watcher)
+
+	playRound(h, game, c1, c2, 5, 3)
+	p1Msgs, p2Msgs, watcherMsgs := drainMessages(c1), drainMessages(c2), drainMessages(watcher)
+	for _, msgType := range []string{"round_result", "waiting_for_bids"} {
+		p1 := lastMessageOfType(p1Msgs, msgType)
+		if p1 == nil || p1.YourBalance != INITIAL_BUDGET-5 || p1.OpponentBalance != INITIAL_BUDGET-3 ||
+			p1.YourPosition != 1 || p1.OpponentPosition != 0 {
+			t.Errorf("P1 %s: got %+v", msgType, p1)
+		}
+		p2 := lastMessageOfType(p2Msgs, msgType)
+		if p2 == nil || p2.YourBalance != INITIAL_BUDGET-3 || p2.OpponentBalance != INITIAL_BUDGET-5 ||
+			p2.YourPosition != 0 || p2.OpponentPosition != 1 {
+			t.Errorf("P2 %s: got %+v", msgType, p2)
+		}
+		if p1 != nil && p2 != nil && (p1.P1Balance != p2.P1Balance || p1.P2Position != p2.P2Position) {
+			t.Errorf("%s should keep the shared P1/P2 fields the same for both players", msgType)
+		}
+		spectator := lastMessageOfType(watcherMsgs, msgType)
+		if spectator == nil || spectator.YourBalance != 0 || spectator.OpponentBalance != 0 || spectator.P1Position != 1 {
+			t.Errorf("spectator %s should only carry the shared fields, got %+v", msgType, spectator)
+		}
+	}
+}
+
+// TestChallengeByUsername tests picking the challenge target by name
+func TestChallengeByUsername(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	h.handleClientMessage(c2, &Message{Type: "set_username", Username: "bravebadger1"})
+	// Names differing only in case can still meet, e.g. through a federated
+	// user named on another server
+	c3 := newTestClient(h)
+	c3.user.Username = "BraveBadger1"
+	drainMessages(c2)
+	drainMessages(c3)
+
+	expectError := func(name, code string) {
+		t.Helper()
+		h.handleClientMessage(c1, &Message{Type: "challenge", TargetUsername: name})
+		if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != code {
+			t.Errorf("challenging %q: expected %s error, got %+v", name, code, errMsg)
+		}
+	}
+	expectError("Bob", ErrUnknownUsername)
+	expectError("BRAVEBADGER1", ErrAmbiguousUsername)
+
+	// An exact match wins over case-insensitive ones
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUsername: "BraveBadger1"})
+	if received := lastMessageOfType(drainMessages(c3), "challenge_received"); received == nil || received.FromUserID != c1.user.ID {
+		t.Errorf("BraveBadger1 should get the challenge, got %+v", received)
+	}
+	if lastMessageOfType(drainMessages(c2), "challenge_received") != nil {
+		t.Error("bravebadger1 must not get a challenge meant for BraveBadger1")
+	}
+
+	// Once unique, any capitalisation finds the player
+	h.handleClientMessage(c2, &Message{Type: "set_username", Username: "Alice"})
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUsername: "alice"})
+	if received := lastMessageOfType(drainMessages(c2), "challenge_received"); received == nil || received.FromUserID != c1.user.ID {
+		t.Errorf("Alice should get the challenge, got %+v", received)
+	}
+}
+
+// TestBoardState tests the board snapshot sent at game start and to spectators
+func TestBoardState(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, MaxSteps: 5})
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	h.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: received.ChallengeID})
+
+	board := lastMessageOfType(drainMessages(c2), "board_state")
+	if board == nil {
+		t.Fatal("board_state should be sent at game start")
+	}
+	if board.MaxSteps != 5 || board.Turn != 1 || board.YourBalance != INITIAL_BUDGET || board.OpponentBalance != INITIAL_BUDGET {
+		t.Errorf("board_state: got %+v", board)
+	}
+	if len(board.PendingBids) != 2 {
+		t.Errorf("both bids should be pending, got %v", board.PendingBids)
+	}
+	game := h.games[board.GameID]
+	drainMessages(c1)
+
+	h.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 4})
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	board = lastMessageOfType(drainMessages(watcher), "board_state")
+	if board == nil || board.MaxSteps != 5 || board.P1Balance != INITIAL_BUDGET || board.YourBalance != 0 {
+		t.Fatalf("spectator should get the shared board, got %+v", board)
+	}
+	if len(board.PendingBids) != 1 || board.PendingBids[0] != 2 {
+		t.Errorf("only P2's bid should be pending, got %v", board.PendingBids)
+	}
+}
+
+// TestSlowClientDropped tests that a client that stops reading is dropped
+// instead of blocking the hub
+func TestSlowClientDropped(t *testing.T) {
+	h := newHub(DefaultConfig())
+	go h.run(context.Background())
+	defer h.shutdown()
+
+	// welcome fills the buffer; the next message finds it full
+	slow := &Client{hub: h, send: make(chan []byte, 1)}
+	h.register <- slow
+	fast := &Client{hub: h, send: make(chan []byte, 256)}
+	h.register <- fast
+	waitForMessage(t, fast, "welcome")
+
+	h.handleMessage <- &MessageWrapper{client: fast, message: &Message{Type: "leaderboard"}}
+	waitForMessage(t, fast, "leaderboard")
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, open := <-slow.send:
+			if !open {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the slow client should be unregistered")
+		}
+	}
+}
+
+// TestDoubleAccept tests that accepting a challenge twice starts one game,
+// and that a player already in a game can't accept another challenge
+func TestDoubleAccept(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	h.handleClientMessage(c3, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	var ids []string
+	for _, msg := range drainMessages(c2) {
+		if msg.Type == "challenge_received" {
+			ids = append(ids, msg.ChallengeID)
+		}
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected two challenges, got %d", len(ids))
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: ids[0]})
+	h.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: ids[0]})
+	starts := 0
+	for _, msg := range drainMessages(c2) {
+		if msg.Type == "game_start" {
+			starts++
+		}
+	}
+	if starts != 1 || len(h.games) != 1 {
+		t.Fatalf("a double accept should start one game, got %d game_start and %d games", starts, len(h.games))
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: ids[1]})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.ErrorCode != ErrUserInGame {
+		t.Errorf("accepting while in a game should fail with %s, got %+v", ErrUserInGame, errMsg)
+	}
+	if len(h.games) != 1 || c3.user.InGame {
+		t.Errorf("the second challenge should not start a game: games=%d", len(h.games))
+	}
+}
+
+// TestSelfChallenge tests that a user can't challenge, or play, themselves
+func TestSelfChallenge(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	drainMessages(c1)
+
+	for _, msg := range []Message{
+		{Type: "challenge", TargetUserID: c1.user.ID},
+		{Type: "challenge", TargetUsername: c1.user.Username},
+	} {
+		h.handleClientMessage(c1, &msg)
+		if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrSelfChallenge {
+			t.Errorf("%+v: expected %s, got %+v", msg, ErrSelfChallenge, errMsg)
+		}
+	}
+	if len(h.challenges) != 0 {
+		t.Fatalf("no challenge should be created, got %d", len(h.challenges))
+	}
+
+	// A self-challenge that slipped through is refused on accept
+	h.challenges["self"] = &Challenge{ID: "self", FromUser: c1.user, ToUser: c1.user, Timestamp: time.Now()}
+	h.handleClientMessage(c1, &Message{Type: "accept_challenge", ChallengeID: "self"})
+	if len(h.games) != 0 || c1.user.InGame {
+		t.Error("accepting a self-challenge must not start a game")
+	}
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrSelfChallenge {
+		t.Errorf("expected %s on accept, got %+v", ErrSelfChallenge, errMsg)
+	}
+}
+
+// TestChallengeExpiry tests that a challenge expires after the sender's
+// chosen expiry, within the configured range, or the server default
+func TestChallengeExpiry(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	drainMessages(c1)
+	drainMessages(c2)
+
+	for _, seconds := range []int{5, -1, 3600} {
+		h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, ExpirySeconds: seconds})
+		if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrInvalidExpiry {
+			t.Errorf("expiry %ds: expected %s, got %+v", seconds, ErrInvalidExpiry, errMsg)
+		}
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, ExpirySeconds: 15})
+	quick := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if quick == nil || quick.ExpirySeconds != 15 {
+		t.Fatalf("challenge_received should carry the chosen expiry, got %+v", quick)
+	}
+	h.handleClientMessage(c3, &Message{Type: "challenge", TargetUserID: c2.user.ID})
+	standard := lastMessageOfType(drainMessages(c2), "challenge_received")
+	if standard == nil || standard.ExpirySeconds != CHALLENGE_EXPIRY {
+		t.Fatalf("challenge_received should carry the default expiry, got %+v", standard)
+	}
+
+	now = now.Add(20 * time.Second)
+	h.checkExpiredChallenges()
+	if _, ok := h.challenges[quick.ChallengeID]; ok {
+		t.Error("the 15s challenge should have expired after 20s")
+	}
+	if _, ok := h.challenges[standard.ChallengeID]; !ok {
+		t.Error("the default challenge should still be open after 20s")
+	}
+	if lastMessageOfType(drainMessages(c1), "challenge_expired") == nil {
+		t.Error("the sender should be told the challenge expired")
+	}
+}
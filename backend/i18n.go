@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+
+	"quevadis/gameengine"
+)
+
+const defaultLocale = "en"
+
+// Stable codes for server-generated text. Clients should branch on the code;
+// the human text is looked up in the catalog for the user's locale.
+const (
+	// game_end reasons; the ones the rules decide come from the engine
+	ReasonReachedFinalStep  = gameengine.ReasonReachedFinalStep
+	ReasonStalemateWin      = gameengine.ReasonStalemateWin
+	ReasonStalemateDraw     = gameengine.ReasonStalemateDraw
+	ReasonNoMovesDraw       = gameengine.ReasonNoMovesDraw
+	ReasonMinTotalBidNotMet = gameengine.ReasonMinTotalBidNotMet
+	ReasonMinTotalBidDraw   = gameengine.ReasonMinTotalBidDraw
+	ReasonOpponentResigned  = "OPPONENT_RESIGNED"
+	ReasonRoundWinTarget    = gameengine.ReasonRoundWinTarget
+	ReasonStalemateTieBreak = gameengine.ReasonStalemateTieBreak
+	ReasonServerShutdown    = "SERVER_SHUTDOWN"
+	ReasonRoundLimit        = gameengine.ReasonRoundLimit
+	ReasonDrawAgreed        = "DRAW_AGREED"
+	ReasonAdminAbort        = "ADMIN_ABORT"
+	ReasonOpponentLeft      = "OPPONENT_DISCONNECTED"
+
+	// error messages
+	ErrUserInGame            = "USER_IN_GAME"
+	ErrChallengePending      = "CHALLENGE_PENDING"
+	ErrSelfChallenge         = "SELF_CHALLENGE"
+	ErrUnknownChallenge      = "CHALLENGE_NOT_FOUND"
+	ErrBidNegative           = "BID_NEGATIVE"
+	ErrBidExceedsBalance     = "BID_EXCEEDS_BALANCE"
+	ErrInternal              = "INTERNAL_ERROR"
+	ErrNoteTooLong           = "NOTE_TOO_LONG"
+	ErrInvalidExpiry         = "INVALID_EXPIRY"
+	ErrChatTooLong           = "CHAT_TOO_LONG"
+	ErrChatRateLimited       = "CHAT_RATE_LIMITED"
+	ErrInvalidEmote          = "INVALID_EMOTE"
+	ErrEmoteRateLimited      = "EMOTE_RATE_LIMITED"
+	ErrRoundNotOpen          = "ROUND_NOT_OPEN"
+	ErrGameOver              = "GAME_OVER"
+	ErrServerFull            = "SERVER_FULL"
+	ErrInvalidRoundWinTarget = "INVALID_ROUND_WIN_TARGET"
+	ErrInvalidMaxSteps       = "INVALID_MAX_STEPS"
+	ErrInvalidInitialBudget  = "INVALID_INITIAL_BUDGET"
+	ErrBidAndPercent         = "BID_AND_PERCENT_SET"
+	ErrBidPercentRange       = "BID_PERCENT_OUT_OF_RANGE"
+	ErrGamePaused            = "GAME_PAUSED"
+	ErrUnknownGame           = "GAME_NOT_FOUND"
+	ErrInvalidLobby          = "INVALID_LOBBY"
+	ErrUsernameLength        = "USERNAME_LENGTH"
+	ErrUsernameChars         = "USERNAME_INVALID_CHARS"
+	ErrUsernameTaken         = "USERNAME_TAKEN"
+	ErrSessionExpired        = "TOKEN_EXPIRED"
+	ErrSessionInvalid        = "TOKEN_INVALID"
+	ErrCannotRejoin          = "CANNOT_REJOIN"
+	ErrGameNotLive           = "GAME_NOT_LIVE"
+	ErrSpectatorLimit        = "SPECTATOR_LIMIT_REACHED"
+	ErrLadderNeedsLogin      = "LADDER_NEEDS_LOGIN"
+	ErrNoRematchRequest      = "NO_REMATCH_REQUEST"
+	ErrRematchDeclined       = "REMATCH_DECLINED"
+	ErrOpponentLeft          = "OPPONENT_LEFT"
+	ErrLeaderboardOrder      = "INVALID_LEADERBOARD_ORDER"
+	ErrUnknownRoom           = "ROOM_NOT_FOUND"
+	ErrUnknownUsername       = "USERNAME_NOT_FOUND"
+	ErrAmbiguousUsername     = "USERNAME_AMBIGUOUS"
+	ErrRateLimited           = "RATE_LIMITED"
+	ErrBadMessage            = "BAD_MESSAGE"
+	ErrVersionMismatch       = "VERSION_MISMATCH"
+	ErrAuthInvalid           = "AUTH_INVALID"
+	ErrAuthExpired           = "AUTH_EXPIRED"
+	ErrInvalidBestOf         = "INVALID_BEST_OF"
+	ErrInvalidGameMode       = "INVALID_GAME_MODE"
+	ErrDrawOfferLimit        = "DRAW_OFFER_LIMIT"
+)
+
+// catalog maps locale -> code -> human text
+var catalog = map[string]map[string]string{
+	"en": {
+		ReasonReachedFinalStep:   "Reached final step",
+		ReasonStalemateWin:       "Bankruptcy stalemate - higher position wins",
+		ReasonStalemateDraw:      "Bankruptcy stalemate - draw",
+		ReasonNoMovesDraw:        "No moves possible - draw",
+		ReasonMinTotalBidNotMet:  "Minimum total bid not met",
+		ReasonMinTotalBidDraw:    "Minimum total bid not met - draw",
+		ReasonOpponentResigned:   "Opponent resigned",
+		ReasonRoundWinTarget:     "Reached the round-win target",
+		ReasonStalemateTieBreak:  "Bankruptcy stalemate - won on tie-break",
+		ReasonServerShutdown:     "Game aborted: the server is shutting down",
+		ReasonRoundLimit:         "Round limit reached",
+		ReasonDrawAgreed:         "Draw agreed",
+		ReasonAdminAbort:         "Game aborted by a server operator",
+		ReasonOpponentLeft:       "Opponent left the game",
+		ErrUserInGame:            "User is already in a game",
+		ErrChallengePending:      "You already have a pending challenge to this user",
+		ErrSelfChallenge:         "You can't challenge yourself",
+		ErrUnknownChallenge:      "No pending challenge of yours with that ID",
+		ErrBidNegative:           "Bid must be non-negative",
+		ErrBidExceedsBalance:     "Bid exceeds your balance",
+		ErrInternal:              "Internal server error",
+		ErrNoteTooLong:           "Challenge note is too long (max 140 characters)",
+		ErrInvalidExpiry:         "Challenge expiry is outside the allowed range",
+		ErrChatTooLong:           "Chat message is too long (max 500 characters)",
+		ErrChatRateLimited:       "You're sending messages too quickly",
+		ErrInvalidEmote:          "Unknown emote",
+		ErrEmoteRateLimited:      "Too many emotes this round",
+		ErrRoundNotOpen:          "Bids are not being accepted right now",
+		ErrGameOver:              "Game is over",
+		ErrServerFull:            "The server is full, please try again later",
+		ErrInvalidRoundWinTarget: "Round-win target must be between 0 and 20",
+		ErrInvalidMaxSteps:       "Steps to win must be between 2 and 10",
+		ErrInvalidInitialBudget:  "Starting budget must be between 5 and 200",
+		ErrBidAndPercent:         "Set either a bid or a bid percentage, not both",
+		ErrBidPercentRange:       "Bid percentage must be between 0 and 100",
+		ErrGamePaused:            "The game is paused",
+		ErrUnknownGame:           "No recorded game with that ID",
+		ErrInvalidLobby:          "Lobby names must be 1 to 32 characters",
+		ErrUsernameLength:        "Usernames must be 3 to 20 characters",
+		ErrUsernameChars:         "Usernames may only contain letters, digits, '_', '-' and '.'",
+		ErrUsernameTaken:         "That username is already taken",
+		ErrSessionExpired:        "Your session has expired",
+		ErrSessionInvalid:        "Invalid session token",
+		ErrCannotRejoin:          "That game can't be rejoined from this connection",
+		ErrGameNotLive:           "No game in progress with that ID",
+		ErrSpectatorLimit:        "That game has as many spectators as it can take",
+		ErrLadderNeedsLogin:      "Log in to climb the practice ladder",
+		ErrNoRematchRequest:      "Your opponent hasn't asked for a rematch",
+		ErrRematchDeclined:       "Your opponent declined the rematch",
+		ErrOpponentLeft:          "Your opponent has left",
+		ErrLeaderboardOrder:      "Leaderboard order must be rating, wins or winrate",
+		ErrUnknownRoom:           "No open room with that code",
+		ErrUnknownUsername:       "No player online with that name",
+		ErrAmbiguousUsername:     "Several players match that name, type it exactly",
+		ErrRateLimited:           "You are sending messages too fast; some were ignored",
+		ErrBadMessage:            "Message could not be understood and was ignored",
+		ErrVersionMismatch:       "This client is not compatible with the server; please reload the page",
+		ErrAuthInvalid:           "Your login is not valid; please log in again",
+		ErrAuthExpired:           "Your login has expired; please log in again",
+		ErrInvalidBestOf:         "Series length must be up to 7 games, and odd unless ties go to sudden death",
+		ErrInvalidGameMode:       "Game mode must be all_pay, second_price or first_price",
+		ErrDrawOfferLimit:        "You can only offer a draw once per round",
+	},
+	"fr": {
+		ReasonReachedFinalStep:   "Dernière marche atteinte",
+		ReasonStalemateWin:       "Impasse par faillite - la position la plus haute gagne",
+		ReasonStalemateDraw:      "Impasse par faillite - match nul",
+		ReasonNoMovesDraw:        "Aucun coup possible - match nul",
+		ReasonMinTotalBidNotMet:  "Mise totale minimale non atteinte",
+		ReasonMinTotalBidDraw:    "Mise totale minimale non atteinte - match nul",
+		ReasonOpponentResigned:   "L'adversaire a abandonné",
+		ReasonRoundWinTarget:     "Objectif de manches gagnées atteint",
+		ReasonStalemateTieBreak:  "Impasse par faillite - victoire au départage",
+		ReasonServerShutdown:     "Partie interrompue : arrêt du serveur",
+		ReasonRoundLimit:         "Nombre maximal de manches atteint",
+		ReasonDrawAgreed:         "Nul par accord mutuel",
+		ReasonAdminAbort:         "Partie interrompue par un administrateur",
+		ReasonOpponentLeft:       "L'adversaire a quitté la partie",
+		ErrUserInGame:            "Ce joueur est déjà en partie",
+		ErrChallengePending:      "Vous avez déjà un défi en attente pour ce joueur",
+		ErrSelfChallenge:         "Vous ne pouvez pas vous défier vous-même",
+		ErrUnknownChallenge:      "Aucun défi en attente de votre part avec cet identifiant",
+		ErrBidNegative:           "La mise doit être positive ou nulle",
+		ErrBidExceedsBalance:     "La mise dépasse votre solde",
+		ErrInternal:              "Erreur interne du serveur",
+		ErrNoteTooLong:           "Le message du défi est trop long (140 caractères max)",
+		ErrInvalidExpiry:         "La durée du défi est hors de la plage autorisée",
+		ErrChatTooLong:           "Le message est trop long (500 caractères max)",
+		ErrChatRateLimited:       "Vous envoyez des messages trop rapidement",
+		ErrInvalidEmote:          "Émoticône inconnue",
+		ErrEmoteRateLimited:      "Trop d'émoticônes pour cette manche",
+		ErrRoundNotOpen:          "Les mises ne sont pas acceptées pour le moment",
+		ErrGameOver:              "La partie est terminée",
+		ErrServerFull:            "Le serveur est plein, veuillez réessayer plus tard",
+		ErrInvalidRoundWinTarget: "L'objectif de manches gagnées doit être compris entre 0 et 20",
+		ErrInvalidMaxSteps:       "Le nombre de marches doit être compris entre 2 et 10",
+		ErrInvalidInitialBudget:  "Le budget de départ doit être compris entre 5 et 200",
+		ErrBidAndPercent:         "Indiquez soit une mise, soit un pourcentage, pas les deux",
+		ErrBidPercentRange:       "Le pourcentage de mise doit être compris entre 0 et 100",
+		ErrGamePaused:            "La partie est en pause",
+		ErrUnknownGame:           "Aucune partie enregistrée avec cet identifiant",
+		ErrInvalidLobby:          "Le nom du salon doit comporter de 1 à 32 caractères",
+		ErrUsernameLength:        "Le pseudo doit comporter de 3 à 20 caractères",
+		ErrUsernameChars:         "Le pseudo ne peut contenir que des lettres, des chiffres, '_', '-' et '.'",
+		ErrUsernameTaken:         "Ce pseudo est déjà pris",
+		ErrSessionExpired:        "Votre session a expiré",
+		ErrSessionInvalid:        "Jeton de session invalide",
+		ErrCannotRejoin:          "Impossible de rejoindre cette partie depuis cette connexion",
+		ErrGameNotLive:           "Aucune partie en cours avec cet identifiant",
+		ErrSpectatorLimit:        "Cette partie a atteint son nombre maximal de spectateurs",
+		ErrLadderNeedsLogin:      "Connectez-vous pour gravir l'échelle d'entraînement",
+		ErrNoRematchRequest:      "Votre adversaire n'a pas demandé de revanche",
+		ErrRematchDeclined:       "Votre adversaire a refusé la revanche",
+		ErrOpponentLeft:          "Votre adversaire est parti",
+		ErrLeaderboardOrder:      "Le classement se trie par rating, wins ou winrate",
+		ErrUnknownRoom:           "Aucun salon ouvert avec ce code",
+		ErrUnknownUsername:       "Aucun joueur en ligne avec ce nom",
+		ErrAmbiguousUsername:     "Plusieurs joueurs correspondent à ce nom, saisissez-le exactement",
+		ErrRateLimited:           "Vous envoyez des messages trop vite ; certains ont été ignorés",
+		ErrBadMessage:            "Message incompréhensible, il a été ignoré",
+		ErrVersionMismatch:       "Ce client n'est pas compatible avec le serveur ; veuillez recharger la page",
+		ErrAuthInvalid:           "Votre connexion n'est pas valide ; veuillez vous reconnecter",
+		ErrAuthExpired:           "Votre connexion a expiré ; veuillez vous reconnecter",
+		ErrInvalidBestOf:         "Une série compte au plus 7 parties, en nombre impair sauf si les égalités se jouent en mort subite",
+		ErrInvalidGameMode:       "Le mode de jeu doit être all_pay, second_price ou first_price",
+		ErrDrawOfferLimit:        "Une seule proposition de nul par manche",
+	},
+}
+
+// normalizeLocale reduces a tag like "fr-FR" to a supported catalog locale,
+// falling back to English for anything unknown
+func normalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	if _, ok := catalog[locale]; ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// translate returns the human text for a code, falling back to English and
+// then to the code itself
+func translate(locale, code string) string {
+	if text, ok := catalog[locale][code]; ok {
+		return text
+	}
+	if text, ok := catalog[defaultLocale][code]; ok {
+		return text
+	}
+	return code
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// TestLocalizedGameEnd tests that a French client gets translated reason text with an unchanged code
+func TestLocalizedGameEnd(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := &Client{hub: hub, send: make(chan []byte, 256), locale: "fr-FR"}
+	hub.clients[c1] = true
+	hub.handleConnect(c1)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(hub, game, c1, c2, 2, 1)
+	}
+
+	frEnd := lastMessageOfType(drainMessages(c1), "game_end")
+	enEnd := lastMessageOfType(drainMessages(c2), "game_end")
+	if frEnd == nil || enEnd == nil {
+		t.Fatal("both players should receive game_end")
+	}
+	if frEnd.Reason != "Dernière marche atteinte" {
+		t.Errorf("French reason: got %q", frEnd.Reason)
+	}
+	if enEnd.Reason != "Reached final step" {
+		t.Errorf("English reason: got %q", enEnd.Reason)
+	}
+	if frEnd.ReasonCode != ReasonReachedFinalStep || enEnd.ReasonCode != ReasonReachedFinalStep {
+		t.Errorf("reason codes should be stable: got %q and %q", frEnd.ReasonCode, enEnd.ReasonCode)
+	}
+}
+
+// TestNormalizeLocale tests locale fallback
+func TestNormalizeLocale(t *testing.T) {
+	tests := map[string]string{
+		"":      "en",
+		"fr":    "fr",
+		"FR-ca": "fr",
+		"de":    "en",
+	}
+	for in, want := range tests {
+		if got := normalizeLocale(in); got != want {
+			t.Errorf("normalizeLocale(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
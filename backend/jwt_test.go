@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// signJWT returns an HS256 JWT carrying the claims
+func signJWT(secret []byte, claims jwtClaims) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TestVerifyJWT tests signature, algorithm and validity period checks
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("jwt-secret")
+	now := time.Unix(1700000000, 0)
+	valid := signJWT(secret, jwtClaims{Subject: "alice", ExpiresAt: now.Add(time.Hour).Unix()})
+
+	if sub, err := verifyJWT(secret, valid, now); err != nil || sub != "alice" {
+		t.Errorf("valid token: got %q, %v", sub, err)
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"wrong secret", signJWT([]byte("other"), jwtClaims{Subject: "alice"}), ErrJWTInvalid},
+		{"unsigned", none, ErrJWTInvalid},
+		{"no subject", signJWT(secret, jwtClaims{}), ErrJWTInvalid},
+		{"not yet valid", signJWT(secret, jwtClaims{Subject: "alice", NotBefore: now.Add(time.Minute).Unix()}), ErrJWTInvalid},
+		{"expired", signJWT(secret, jwtClaims{Subject: "alice", ExpiresAt: now.Unix()}), ErrJWTExpired},
+		{"garbage", "not.a.jwt", ErrJWTInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := verifyJWT(secret, tt.token, now); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestJWTAccountIdentity tests that an account keeps its name and rating
+// across connections
+func TestJWTAccountIdentity(t *testing.T) {
+	h := newHub(DefaultConfig())
+	first := &Client{hub: h, send: make(chan []byte, 256), account: "alice"}
+	h.handleRegister(first)
+	h.handleClientMessage(first, &Message{Type: "set_username", Username: "Alice"})
+	first.user.Profile.Rating = 1620
+	h.store.SaveProfile(first.user.Profile)
+	h.handleUnregister(first)
+
+	second := &Client{hub: h, send: make(chan []byte, 256), account: "alice"}
+	h.handleRegister(second)
+	if second.user.Username != "Alice" || second.user.rating() != 1620 {
+		t.Errorf("account should keep its identity, got %s rated %d", second.user.Username, second.user.rating())
+	}
+	anonymous := newTestClient(h)
+	if anonymous.user.Profile != nil {
+		t.Error("a connection without a token should stay anonymous")
+	}
+}
+
+// TestJWTRejected tests that a connection presenting an expired token is
+// closed with a clear reason
+func TestJWTRejected(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.JWTSecret = []byte("jwt-secret")
+	go h.run(context.Background())
+	defer h.shutdown()
+	url := newTestServer(t, h)
+
+	header := http.Header{"Authorization": []string{"Bearer " + signJWT(h.config.JWTSecret, jwtClaims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()})}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var errMsg Message
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatal(err)
+	}
+	if errMsg.Type != "error" || errMsg.ErrorCode != ErrAuthExpired {
+		t.Errorf("expired token should get %s, got %+v", ErrAuthExpired, errMsg)
+	}
+	_, _, err = conn.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Text != CloseAuthExpired {
+		t.Errorf("socket should be closed with the expiry reason, got %v", err)
+	}
+
+	header.Set("Authorization", "Bearer "+signJWT(h.config.JWTSecret, jwtClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}))
+	conn2, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	var welcome Message
+	if err := conn2.ReadJSON(&welcome); err != nil || welcome.Type != "welcome" || welcome.Rating != INITIAL_RATING {
+		t.Errorf("valid token should be welcomed with a rated profile, got %+v, %v", welcome, err)
+	}
+}
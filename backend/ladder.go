@@ -0,0 +1,67 @@
+package main
+
+import "math/rand"
+
+// The practice ladder is a single-player progression against the bot. Each
+// rung is a bot that blunders less often than the one below it; beating the
+// bot on a player's rung moves them up, losing keeps them where they are.
+// The rung is kept on the profile, so only logged-in users can climb.
+
+// ladderRung returns the user's current rung, counted from 1 as sent to
+// clients, or 0 when they have no profile
+func (u *User) ladderRung() int {
+	if u.Profile == nil {
+		return 0
+	}
+	return u.Profile.LadderRung + 1
+}
+
+// ladderBotBid is the bid of the bot on the given rung: with the rung's
+// blunder chance it bids at random, otherwise it plays like botBid
+func ladderBotBid(game *Game, blunder int, rng *rand.Rand) int {
+	if rng.Intn(100) < blunder {
+		return rng.Intn(game.Player2Balance + 1)
+	}
+	return botBid(game, 2, rng)
+}
+
+// newLadderBot creates the bot for the profile's ladder rung. A rung beyond
+// a shortened ladder plays the top bot.
+func (h *Hub) newLadderBot(profile *Profile) *User {
+	bot := newBot()
+	bot.BotRung = min(profile.LadderRung, len(h.config.LadderRungs)-1) + 1
+	return bot
+}
+
+// handlePlayLadder starts a game against the bot on the user's ladder rung
+func (h *Hub) handlePlayLadder(user *User) {
+	if user.Profile == nil {
+		h.sendError(user, ErrLadderNeedsLogin)
+		return
+	}
+	if !h.canJoinGame(user) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	// The rung is on the bot before the first round opens, as the bot bids at once
+	bot := h.newLadderBot(user.Profile)
+	game := h.createGame(user, bot, GameSettings{}, nil)
+	h.broadcastUserList()
+
+	h.logger.Info("game_start", "game_id", game.ID, "player1", user.Username, "player2", "bot", "ladder_rung", bot.BotRung)
+}
+
+// advanceLadder moves the player of a ladder game they won up a rung; a loss
+// or draw keeps their rung
+func (h *Hub) advanceLadder(game *Game) {
+	profile, rung := game.Player1.Profile, game.Player2.BotRung
+	if rung == 0 || profile == nil || game.Winner != 1 || rung >= len(h.config.LadderRungs) || profile.LadderRung >= rung {
+		return
+	}
+	profile.LadderRung = rung
+	if err := h.store.SaveProfile(profile); err != nil {
+		h.logger.Error("profile_save_failed", "profile_id", profile.ID, "error", err)
+	}
+	h.logger.Info("ladder_advanced", "game_id", game.ID, "user", game.Player1.Username, "ladder_rung", profile.LadderRung+1)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestPracticeLadder tests that beating the ladder bot moves the player up a
+// rung and losing to it doesn't
+func TestPracticeLadder(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	h.botRNG = rand.New(rand.NewSource(1))
+	c := newLoggedInClient(h, "ladder-climber-login-token")
+	if welcome := lastMessageOfType(drainMessages(c), "welcome"); welcome == nil || welcome.LadderRung != 1 {
+		t.Fatalf("welcome should put a new profile on rung 1, got %+v", welcome)
+	}
+
+	anon := newTestClient(h)
+	drainMessages(anon)
+	h.handleClientMessage(anon, &Message{Type: "play_ladder"})
+	if errMsg := lastMessageOfType(drainMessages(anon), "error"); errMsg == nil || errMsg.ErrorCode != ErrLadderNeedsLogin {
+		t.Errorf("anonymous users have no rung to climb, got %+v", errMsg)
+	}
+
+	// Outbid the bot every round to win
+	h.handleClientMessage(c, &Message{Type: "play_ladder"})
+	start := lastMessageOfType(drainMessages(c), "game_start")
+	if start == nil {
+		t.Fatal("play_ladder should start a game")
+	}
+	game := h.games[start.GameID]
+	if game.Player2.BotRung != 1 || !game.Player2.IsBot {
+		t.Fatalf("game should be against the bot on the first rung, got %+v", game)
+	}
+	game.Player1Balance = 1000
+	for i := 0; i < 50 && !game.GameOver; i++ {
+		h.handleClientMessage(c, &Message{Type: "submit_bid", GameID: game.ID, Bid: *game.Player2Bid + 1})
+	}
+	if game.Winner != 1 {
+		t.Fatalf("player should beat the bot, winner %d", game.Winner)
+	}
+	if stats := lastMessageOfType(drainMessages(c), "stats_update"); stats == nil || stats.LadderRung != 2 {
+		t.Errorf("stats_update should show rung 2 after a win, got %+v", stats)
+	}
+	if profile, err := h.store.LoadProfile(c.user.Profile.ID); err != nil || profile.LadderRung != 1 {
+		t.Errorf("the new rung should be stored, got %+v, %v", profile, err)
+	}
+
+	// A rematch stays on the ladder, on the new rung; losing keeps the rung
+	h.handleClientMessage(c, &Message{Type: "rematch", GameID: game.ID})
+	game = h.games[lastMessageOfType(drainMessages(c), "game_start").GameID]
+	if game.Player2.BotRung != 2 {
+		t.Fatalf("rematch should be on rung 2, got %d", game.Player2.BotRung)
+	}
+	h.handleClientMessage(c, &Message{Type: "resign", GameID: game.ID})
+	if stats := lastMessageOfType(drainMessages(c), "stats_update"); stats == nil || stats.LadderRung != 2 {
+		t.Errorf("stats_update should still show rung 2 after a loss, got %+v", stats)
+	}
+	if profile, _ := h.store.LoadProfile(c.user.Profile.ID); profile.LadderRung != 1 {
+		t.Errorf("a loss should not change the stored rung, got %d", profile.LadderRung)
+	}
+}
+
+// TestLadderFirstRound tests that the ladder bot plays its rung from the first round
+func TestLadderFirstRound(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.LadderRungs = []int{100}
+	c := newLoggedInClient(h, "ladder-climber-login-token")
+
+	// With every bid a blunder the opening bids are spread over the whole
+	// balance instead of staying near a third of it, as botBid's do
+	spread := false
+	for i := 0; i < 20 && !spread; i++ {
+		h.handlePlayLadder(c.user)
+		game := h.games[lastMessageOfType(drainMessages(c), "game_start").GameID]
+		if bid := *game.Player2Bid; bid < 6 || bid > 8 {
+			spread = true
+		}
+		h.handleClientMessage(c, &Message{Type: "resign", GameID: game.ID})
+	}
+	if !spread {
+		t.Error("the first round should be bid by the ladder bot, not the plain bot")
+	}
+}
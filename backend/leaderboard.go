@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Leaderboard orderings
+const (
+	LeaderboardByRating  = "rating"
+	LeaderboardByWins    = "wins"
+	LeaderboardByWinRate = "winrate"
+)
+
+const (
+	DEFAULT_LEADERBOARD_LIMIT = 10 // entries when no limit is asked for
+	MAX_LEADERBOARD_LIMIT     = 100
+	MIN_WIN_RATE_GAMES        = 5 // rated games needed to rank by win rate
+)
+
+// LeaderboardEntry is one ranked player. The profile ID is left out: it is
+// derived from the player's login token.
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	Username string  `json:"username"`
+	Rating   int     `json:"rating"`
+	Games    int     `json:"games"`
+	Wins     int     `json:"wins"`
+	Losses   int     `json:"losses"`
+	Draws    int     `json:"draws"`
+	WinRate  float64 `json:"winRate"` // Wins over games, 0-1
+}
+
+// leaderboardSubscription is the leaderboard a client asked to be kept up
+// to date with
+type leaderboardSubscription struct {
+	order string
+	limit int
+}
+
+// validLeaderboardOrder reports whether order is a known ordering
+func validLeaderboardOrder(order string) bool {
+	return order == LeaderboardByRating || order == LeaderboardByWins || order == LeaderboardByWinRate
+}
+
+// clampLeaderboardLimit maps a requested limit to the allowed range, with
+// 0 meaning the default
+func clampLeaderboardLimit(limit int) int {
+	if limit <= 0 {
+		return DEFAULT_LEADERBOARD_LIMIT
+	}
+	if limit > MAX_LEADERBOARD_LIMIT {
+		return MAX_LEADERBOARD_LIMIT
+	}
+	return limit
+}
+
+// winRate returns the share of the profile's rated games it won
+func (p *Profile) winRate() float64 {
+	if p.Games == 0 {
+		return 0
+	}
+	return float64(p.Wins) / float64(p.Games)
+}
+
+// rankProfiles sorts profiles by the given order and keeps the top limit.
+// Ties fall back to rating, then profile ID so the ranking is stable.
+func rankProfiles(profiles []Profile, order string, limit int) []Profile {
+	ranked := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		if p.Games == 0 || (order == LeaderboardByWinRate && p.Games < MIN_WIN_RATE_GAMES) {
+			continue
+		}
+		ranked = append(ranked, p)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		switch order {
+		case LeaderboardByWins:
+			if a.Wins != b.Wins {
+				return a.Wins > b.Wins
+			}
+		case LeaderboardByWinRate:
+			if a.winRate() != b.winRate() {
+				return a.winRate() > b.winRate()
+			}
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		return a.ID < b.ID
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// leaderboardEntries numbers ranked profiles for the wire
+func leaderboardEntries(profiles []Profile) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, len(profiles))
+	for i, p := range profiles {
+		entries[i] = LeaderboardEntry{
+			Rank:     i + 1,
+			Username: p.Username,
+			Rating:   p.Rating,
+			Games:    p.Games,
+			Wins:     p.Wins,
+			Losses:   p.Losses,
+			Draws:    p.Draws,
+			WinRate:  p.winRate(),
+		}
+	}
+	return entries
+}
+
+// serveLeaderboard answers GET /leaderboard?order=rating|wins|winrate&limit=N
+func serveLeaderboard(store GameStore, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order := r.URL.Query().Get("order")
+	if order == "" {
+		order = LeaderboardByRating
+	}
+	if !validLeaderboardOrder(order) {
+		http.Error(w, "invalid order", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	profiles, err := store.Leaderboard(order, clampLeaderboardLimit(limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, leaderboardEntries(profiles))
+}
+
+// handleLeaderboard sends the leaderboard to the client. With Enabled set
+// the client also gets it again after every rated game, in the same order
+// and length; without, any such subscription ends.
+func (h *Hub) handleLeaderboard(client *Client, msg *Message) {
+	order := msg.Order
+	if order == "" {
+		order = LeaderboardByRating
+	}
+	if !validLeaderboardOrder(order) {
+		h.sendError(client.user, ErrLeaderboardOrder)
+		return
+	}
+	limit := clampLeaderboardLimit(msg.Limit)
+
+	client.leaderboard = nil
+	if msg.Enabled {
+		client.leaderboard = &leaderboardSubscription{order: order, limit: limit}
+	}
+	h.sendLeaderboard(client, order, limit)
+}
+
+// sendLeaderboard reads the leaderboard from the store and sends it
+func (h *Hub) sendLeaderboard(client *Client, order string, limit int) {
+	profiles, err := h.store.Leaderboard(order, limit)
+	if err != nil {
+		h.logger.Error("leaderboard_read_failed", "order", order, "error", err)
+		return
+	}
+	h.sendToClient(client, &Message{
+		Type:        "leaderboard",
+		Order:       order,
+		Limit:       limit,
+		Leaderboard: leaderboardEntries(profiles),
+	})
+}
+
+// pushLeaderboards resends the leaderboard to subscribed clients after a
+// rated game
+func (h *Hub) pushLeaderboards() {
+	for client := range h.clients {
+		if client.leaderboard != nil {
+			h.sendLeaderboard(client, client.leaderboard.order, client.leaderboard.limit)
+		}
+	}
+}
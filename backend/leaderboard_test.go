@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// leaderboardProfiles is a fixture covering each ordering
+var leaderboardProfiles = []Profile{
+	{ID: "a", Username: "alice", Rating: 1600, Games: 10, Wins: 6, Losses: 4},
+	{ID: "b", Username: "bob", Rating: 1550, Games: 20, Wins: 9, Losses: 10, Draws: 1},
+	{ID: "c", Username: "carol", Rating: 1700, Games: 3, Wins: 3},
+	{ID: "d", Username: "dave", Rating: 1500},
+}
+
+// TestRankProfiles tests each leaderboard ordering and the limit
+func TestRankProfiles(t *testing.T) {
+	tests := []struct {
+		order string
+		limit int
+		want  []string
+	}{
+		{LeaderboardByRating, 10, []string{"carol", "alice", "bob"}},
+		{LeaderboardByWins, 10, []string{"bob", "alice", "carol"}},
+		{LeaderboardByWinRate, 10, []string{"alice", "bob"}}, // carol hasn't played enough
+		{LeaderboardByRating, 1, []string{"carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			ranked := rankProfiles(leaderboardProfiles, tt.order, tt.limit)
+			var got []string
+			for _, p := range ranked {
+				got = append(got, p.Username)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestServeLeaderboard tests the GET /leaderboard query parameters
+func TestServeLeaderboard(t *testing.T) {
+	store := newMemoryStore()
+	for i := range leaderboardProfiles {
+		store.SaveProfile(&leaderboardProfiles[i])
+	}
+
+	rec := httptest.NewRecorder()
+	serveLeaderboard(store, rec, httptest.NewRequest(http.MethodGet, "/leaderboard?order=wins&limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+	var entries []LeaderboardEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Username != "bob" || entries[0].Rank != 1 || entries[1].Username != "alice" {
+		t.Fatalf("entries: got %+v", entries)
+	}
+	if entries[1].WinRate != 0.6 {
+		t.Errorf("win rate: got %v, want 0.6", entries[1].WinRate)
+	}
+
+	rec = httptest.NewRecorder()
+	serveLeaderboard(store, rec, httptest.NewRequest(http.MethodGet, "/leaderboard?order=losses", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown order: got %d, want 400", rec.Code)
+	}
+}
+
+// TestLeaderboardUpdates tests that subscribed clients get the leaderboard again after a rated game
+func TestLeaderboardUpdates(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newLoggedInClient(h, "player-one-login-token")
+	c2 := newLoggedInClient(h, "player-two-login-token")
+	watcher := newTestClient(h)
+	drainMessages(watcher)
+
+	h.handleClientMessage(watcher, &Message{Type: "leaderboard", Order: LeaderboardByWins, Enabled: true})
+	board := lastMessageOfType(drainMessages(watcher), "leaderboard")
+	if board == nil || len(board.Leaderboard) != 0 || board.Limit != DEFAULT_LEADERBOARD_LIMIT {
+		t.Fatalf("nobody has played yet, got %+v", board)
+	}
+
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	board = lastMessageOfType(drainMessages(watcher), "leaderboard")
+	if board == nil || len(board.Leaderboard) != 2 {
+		t.Fatalf("leaderboard should be pushed after the game, got %+v", board)
+	}
+	first, second := board.Leaderboard[0], board.Leaderboard[1]
+	if first.Username != c1.user.Username || first.Wins != 1 || second.Losses != 1 {
+		t.Errorf("leaderboard: got %+v", board.Leaderboard)
+	}
+
+	h.handleClientMessage(watcher, &Message{Type: "leaderboard"})
+	drainMessages(watcher)
+	game = startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+	if lastMessageOfType(drainMessages(watcher), "leaderboard") != nil {
+		t.Error("asking without enabled should end the subscription")
+	}
+
+	h.handleClientMessage(watcher, &Message{Type: "leaderboard", Order: "losses"})
+	if errMsg := lastMessageOfType(drainMessages(watcher), "error"); errMsg == nil || errMsg.ErrorCode != ErrLeaderboardOrder {
+		t.Errorf("unknown order should be rejected, got %+v", errMsg)
+	}
+}
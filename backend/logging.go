@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text" // key=value pairs
+	LogFormatJSON = "json" // one JSON object per line
+)
+
+// newLogger returns a logger writing to w in the given format. Debug level
+// is enabled so the wire log (Config.WireLog) shows up when turned on.
+func newLogger(w io.Writer, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch format {
+	case LogFormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want %s or %s)", format, LogFormatText, LogFormatJSON)
+}
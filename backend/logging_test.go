@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestJSONLogging tests that hub events are logged as JSON objects with
+// structured fields
+func TestJSONLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, LogFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := newHub(DefaultConfig())
+	hub.logger = logger
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+	hub.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+
+	var found bool
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %s", scanner.Text())
+		}
+		if entry["msg"] == "game_end" {
+			found = true
+			if entry["game_id"] != game.ID || entry["winner"] != float64(2) {
+				t.Errorf("game_end fields = %v", entry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no game_end entry in\n%s", buf.String())
+	}
+
+	if _, err := newLogger(&buf, "xml"); err == nil {
+		t.Error("an unknown format should be rejected")
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+"context"
+"errors"
+"flag"
 "log"
+"log/slog"
 "net/http"
 "os"
+"os/signal"
 "strings"
+"syscall"
+"time"
 )
 
 // noCacheMiddleware adds cache-busting headers for JS/CSS files
@@ -21,12 +28,77 @@ w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 }
 
 func main() {
-	hub := newHub()
-	go hub.run()
+	config, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger, err := newLogger(os.Stderr, config.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	started := time.Now()
+	hub := newHub(config)
+	hub.logger = logger
+	// Route the remaining log and slog calls through the same handler
+	slog.SetDefault(logger)
+	var db *sqliteStore
+	if hub.config.DatabasePath != "" {
+		db, err = openSQLiteStore(hub.config.DatabasePath)
+		if err != nil {
+			log.Fatalf("Opening %s: %v", hub.config.DatabasePath, err)
+		}
+		hub.store = db
+	}
+	if hub.config.FederationID != "" {
+		hub.federator = newFederator(hub, hub.config.FederationID)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go hub.run(ctx)
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 serveWs(hub, w, r)
 })
+	http.HandleFunc("/games", func(w http.ResponseWriter, r *http.Request) {
+		serveLiveGames(hub, w, r)
+	})
+	http.HandleFunc("/games/", func(w http.ResponseWriter, r *http.Request) {
+		serveGames(hub.store, w, r)
+	})
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealthz(hub, w, r)
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		serveReadyz(hub, w, r)
+	})
+	http.HandleFunc("/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		serveLeaderboard(hub.store, w, r)
+	})
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		serveStats(hub, started, w, r)
+	})
+	http.HandleFunc("/admin/challenges/abuse", func(w http.ResponseWriter, r *http.Request) {
+		serveChallengeAbuse(hub.store, hub.config.AdminToken, w, r)
+	})
+	http.HandleFunc("/admin/kick", func(w http.ResponseWriter, r *http.Request) {
+		serveAdminAction(hub, AdminKick, w, r)
+	})
+	http.HandleFunc("/admin/abort-game", func(w http.ResponseWriter, r *http.Request) {
+		serveAdminAction(hub, AdminAbortGame, w, r)
+	})
+
+	if hub.config.FederationID != "" {
+		http.HandleFunc("/federation", func(w http.ResponseWriter, r *http.Request) {
+			serveFederation(hub, w, r)
+		})
+		for _, peer := range hub.config.FederationPeers {
+			go dialPeer(hub, peer)
+		}
+	}
 
 	// Determine static files directory
 	// In Docker: files are in /app
@@ -40,10 +112,25 @@ serveWs(hub, w, r)
 	fs := http.FileServer(http.Dir(staticDir))
 	http.Handle("/", noCacheMiddleware(fs))
 
-	log.Println("Server starting on :8080")
-	log.Printf("Serving static files from: %s", staticDir)
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	logger.Info("server_start", "addr", config.ListenAddr, "static_dir", staticDir)
+	server := &http.Server{Addr: config.ListenAddr}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("server_shutdown")
+	hub.shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("http_shutdown_failed", "error", err)
+	}
+	if db != nil {
+		if err := db.Close(); err != nil {
+			logger.Error("database_close_failed", "error", err)
+		}
 	}
 }
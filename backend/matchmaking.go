@@ -0,0 +1,99 @@
+package main
+
+import "time"
+
+// Quick-match queues are kept per lobby: players are only paired with, and
+// only told about, others waiting in the same lobby.
+
+// handleQuickMatch puts the user in their lobby's quick-match queue and
+// pairs the two longest-waiting players as soon as there are two of them
+func (h *Hub) handleQuickMatch(user *User) {
+	if !h.canJoinGame(user) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+	if h.isQueued(user) {
+		return
+	}
+	lobby := user.Lobby
+	user.QueuedSince = h.now()
+	h.matchQueue[lobby] = append(h.matchQueue[lobby], user)
+	h.logger.Info("queue_join", "user", user.Username, "lobby", lobby, "waiting", len(h.matchQueue[lobby]))
+
+	for queue := h.matchQueue[lobby]; len(queue) >= 2; queue = h.matchQueue[lobby] {
+		player1, player2 := queue[0], queue[1]
+		h.setMatchQueue(lobby, queue[2:])
+		game := h.createGame(player1, player2, GameSettings{}, nil)
+		h.logger.Info("game_start", "game_id", game.ID, "player1", player1.Username, "player2", player2.Username, "source", "quick_match")
+	}
+	h.broadcastUserList()
+	h.broadcastQueueSize(lobby)
+}
+
+// dequeueMatch removes the user from the quick-match queue they wait in, if any
+func (h *Hub) dequeueMatch(user *User) {
+	for lobby, queue := range h.matchQueue {
+		for i, queued := range queue {
+			if queued == user {
+				h.setMatchQueue(lobby, append(queue[:i], queue[i+1:]...))
+				h.broadcastQueueSize(lobby)
+				return
+			}
+		}
+	}
+}
+
+// checkQueueTimeouts takes players who have waited QuickMatchTimeout
+// without an opponent out of the queue and sends them quick_match_timeout,
+// so the client can offer to try again
+func (h *Hub) checkQueueTimeouts() {
+	if h.config.QuickMatchTimeout <= 0 {
+		return
+	}
+	now := h.now()
+	var expired []*User
+	for _, queue := range h.matchQueue {
+		for _, user := range queue {
+			if now.Sub(user.QueuedSince) >= h.config.QuickMatchTimeout {
+				expired = append(expired, user)
+			}
+		}
+	}
+	for _, user := range expired {
+		h.logger.Info("queue_timeout", "user", user.Username, "lobby", user.Lobby)
+		h.dequeueMatch(user)
+		h.sendToUser(user, &Message{Type: "quick_match_timeout", Elapsed: int(h.config.QuickMatchTimeout / time.Second)})
+	}
+}
+
+// isQueued reports whether the user is waiting for a quick match
+func (h *Hub) isQueued(user *User) bool {
+	for _, queue := range h.matchQueue {
+		for _, queued := range queue {
+			if queued == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setMatchQueue replaces a lobby's queue, dropping it once empty
+func (h *Hub) setMatchQueue(lobby string, queue []*User) {
+	if len(queue) == 0 {
+		delete(h.matchQueue, lobby)
+		return
+	}
+	h.matchQueue[lobby] = queue
+}
+
+// broadcastQueueSize tells every local user in the lobby how many players
+// there are waiting for a quick match
+func (h *Hub) broadcastQueueSize(lobby string) {
+	msg := &Message{Type: "queue_update", QueueSize: len(h.matchQueue[lobby])}
+	for _, user := range h.users {
+		if user.Peer == "" && user.Lobby == lobby {
+			h.sendToUser(user, msg)
+		}
+	}
+}
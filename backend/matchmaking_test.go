@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestQuickMatchPairsInOrder tests that the two longest-waiting players get a game
+func TestQuickMatchPairsInOrder(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	drainMessages(c1)
+	drainMessages(c2)
+	drainMessages(c3)
+
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	if len(h.matchQueue[DEFAULT_LOBBY]) != 1 {
+		t.Fatalf("queueing twice should count once, got %d waiting", len(h.matchQueue[DEFAULT_LOBBY]))
+	}
+	if update := lastMessageOfType(drainMessages(c3), "queue_update"); update == nil || update.QueueSize != 1 {
+		t.Errorf("everyone should be told the queue size, got %+v", update)
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "quick_match"})
+	if len(h.matchQueue[DEFAULT_LOBBY]) != 0 {
+		t.Fatalf("a pair should leave the queue, got %d waiting", len(h.matchQueue[DEFAULT_LOBBY]))
+	}
+	start := lastMessageOfType(drainMessages(c1), "game_start")
+	if start == nil {
+		t.Fatal("queued players should be put in a game")
+	}
+	game := h.games[start.GameID]
+	if game.Player1 != c1.user || game.Player2 != c2.user {
+		t.Errorf("first in queue should be player 1, got %s vs %s", game.Player1.Username, game.Player2.Username)
+	}
+	if update := lastMessageOfType(drainMessages(c3), "queue_update"); update == nil || update.QueueSize != 0 {
+		t.Errorf("queue size should drop to zero, got %+v", update)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrUserInGame {
+		t.Errorf("a player in a game can't queue, got %+v", errMsg)
+	}
+}
+
+// TestQuickMatchLeave tests that leaving or disconnecting drops a waiting player
+func TestQuickMatchLeave(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	h.handleClientMessage(c1, &Message{Type: "leave_queue"})
+	if len(h.matchQueue[DEFAULT_LOBBY]) != 0 {
+		t.Fatalf("leave_queue should empty the queue, got %d waiting", len(h.matchQueue[DEFAULT_LOBBY]))
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "quick_match"})
+	h.handleDisconnect(c2)
+	if len(h.matchQueue[DEFAULT_LOBBY]) != 0 {
+		t.Fatalf("a disconnected player should leave the queue, got %d waiting", len(h.matchQueue[DEFAULT_LOBBY]))
+	}
+
+	h.handleClientMessage(c3, &Message{Type: "quick_match"})
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	if !c1.user.InGame || !c3.user.InGame {
+		t.Error("the remaining players should be paired")
+	}
+}
+
+// TestQuickMatchPerLobby tests that players are only paired within their lobby
+func TestQuickMatchPerLobby(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	h.handleClientMessage(c2, &Message{Type: "join_lobby", Lobby: "blitz"})
+	drainMessages(c1)
+	drainMessages(c2)
+	drainMessages(c3)
+
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	h.handleClientMessage(c2, &Message{Type: "quick_match"})
+	if c1.user.InGame || c2.user.InGame {
+		t.Fatal("players in different lobbies should not be paired")
+	}
+	if update := lastMessageOfType(drainMessages(c3), "queue_update"); update == nil || update.QueueSize != 1 {
+		t.Errorf("the main lobby should only count its own queue, got %+v", update)
+	}
+	for _, msg := range drainMessages(c1) {
+		if msg.Type == "queue_update" && msg.QueueSize != 1 {
+			t.Errorf("queue updates from another lobby leaked: %+v", msg)
+		}
+	}
+
+	// Changing lobby leaves the old lobby's queue
+	h.handleClientMessage(c1, &Message{Type: "join_lobby", Lobby: "blitz"})
+	if len(h.matchQueue[DEFAULT_LOBBY]) != 0 {
+		t.Errorf("leaving the lobby should leave its queue, got %d waiting", len(h.matchQueue[DEFAULT_LOBBY]))
+	}
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	if !c1.user.InGame || !c2.user.InGame {
+		t.Error("players in the same lobby should be paired")
+	}
+}
+
+// TestQuickMatchTimeout tests that a player left waiting too long is taken
+// out of the queue and told so
+func TestQuickMatchTimeout(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	h.handleClientMessage(c1, &Message{Type: "quick_match"})
+	now = now.Add(time.Minute)
+	h.handleClientMessage(c2, &Message{Type: "join_lobby", Lobby: "blitz"})
+	h.handleClientMessage(c2, &Message{Type: "quick_match"})
+	drainMessages(c1)
+	drainMessages(c2)
+
+	now = now.Add(h.config.QuickMatchTimeout - time.Minute)
+	h.checkQueueTimeouts()
+	if h.isQueued(c1.user) {
+		t.Error("the player should leave the queue after the timeout")
+	}
+	if timeout := lastMessageOfType(drainMessages(c1), "quick_match_timeout"); timeout == nil || timeout.Elapsed != 120 {
+		t.Errorf("the player should be sent quick_match_timeout, got %+v", timeout)
+	}
+	if !h.isQueued(c2.user) || lastMessageOfType(drainMessages(c2), "quick_match_timeout") != nil {
+		t.Error("a player who joined later should keep waiting")
+	}
+}
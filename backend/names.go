@@ -4,6 +4,10 @@ import (
 	"math/rand"
 	"strconv"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
 )
 
 var (
@@ -28,6 +32,41 @@ func GenerateRandomName() string {
 	return adj + animal + strconv.Itoa(number)
 }
 
+// generateUniqueName draws names from generate until one isn't taken. After
+// maxAttempts collisions it appends a short UUID fragment instead, so it never
+// loops forever on a crowded server.
+func generateUniqueName(generate func() string, taken func(string) bool, maxAttempts int) string {
+	name := generate()
+	for attempt := 1; attempt < maxAttempts && taken(name); attempt++ {
+		name = generate()
+	}
+	base := name
+	for taken(name) {
+		name = base + uuid.New().String()[:6]
+	}
+	return name
+}
+
+// Username rules for set_username
+const (
+	MIN_USERNAME_LENGTH = 3
+	MAX_USERNAME_LENGTH = 20
+)
+
+// validateUsername returns an error code if the name breaks the username
+// rules: 3-20 characters of letters, digits, '_', '-' or '.'
+func validateUsername(name string) string {
+	if n := utf8.RuneCountInString(name); n < MIN_USERNAME_LENGTH || n > MAX_USERNAME_LENGTH {
+		return ErrUsernameLength
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			return ErrUsernameChars
+		}
+	}
+	return ""
+}
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+// TestUniqueNameFallback tests that an exhausted name pool falls back to unique suffixed names
+func TestUniqueNameFallback(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	attempts := 0
+	hub.generateName = func() string {
+		attempts++
+		return "BraveBadger1"
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		attempts = 0
+		client := newTestClient(hub)
+		name := client.user.Username
+		if seen[name] {
+			t.Fatalf("duplicate username %q", name)
+		}
+		seen[name] = true
+		if attempts > hub.config.NameAttempts {
+			t.Errorf("generator called %d times, want at most %d", attempts, hub.config.NameAttempts)
+		}
+	}
+	if !seen["BraveBadger1"] {
+		t.Error("the first user should get the generated name unchanged")
+	}
+}
+
+// TestValidateUsername tests the username rules
+func TestValidateUsername(t *testing.T) {
+	tests := map[string]string{
+		"ab":                    ErrUsernameLength,
+		"abc":                   "",
+		"Player_One-2.0":        "",
+		"Zoë":                   "",
+		"twentyonecharacters!!": ErrUsernameLength,
+		"exactlytwentychars20":  "",
+		"no spaces":             ErrUsernameChars,
+		"<script>":              ErrUsernameChars,
+	}
+	for name, want := range tests {
+		if got := validateUsername(name); got != want {
+			t.Errorf("validateUsername(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestSetUsername tests renaming, and rejection of invalid or taken names
+func TestSetUsername(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.UserListBatchWindow = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	drainMessages(c1)
+	drainMessages(c2)
+
+	h.handleClientMessage(c1, &Message{Type: "set_username", Username: "Alice"})
+	if c1.user.Username != "Alice" {
+		t.Fatalf("username: got %q, want Alice", c1.user.Username)
+	}
+	update := lastMessageOfType(drainMessages(c2), "users_update")
+	found := false
+	for _, info := range update.Users {
+		if info.UserID == c1.user.ID && info.Username == "Alice" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("other users should see the new name, got %+v", update.Users)
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "set_username", Username: "alice"})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrUsernameTaken) {
+		t.Errorf("expected %s error, got %+v", ErrUsernameTaken, errMsg)
+	}
+
+	// A generated name can't clash with a chosen one by case either
+	h.generateName = func() string { return "ALICE" }
+	if c3 := newTestClient(h); c3.user.Username == "ALICE" {
+		t.Error("a new user should not be named after Alice in another case")
+	}
+
+	old := c2.user.Username
+	h.handleClientMessage(c2, &Message{Type: "set_username", Username: "x"})
+	if c2.user.Username != old {
+		t.Error("an invalid name should leave the username unchanged")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Inbound client messages are short-lived: each is decoded in a client's
+// readPump, handled once by the hub and then dropped. With Config.PoolMessages
+// they are recycled through messagePool instead of allocated per message.
+// Handlers must not keep a reference to an inbound *Message after returning.
+//
+// Games and challenges are not pooled: they outlive the hub loop iteration
+// (a finished game stays for Config.FinishedGameRetention to take rematch
+// requests and late messages, and federation peers refer to both by ID) so
+// there is no safe point to recycle them.
+var messagePool = sync.Pool{
+	New: func() interface{} { return new(Message) },
+}
+
+// acquireMessage returns a zeroed message from the pool
+func acquireMessage() *Message {
+	return messagePool.Get().(*Message)
+}
+
+// releaseMessage clears every field and returns the message to the pool
+func releaseMessage(msg *Message) {
+	*msg = Message{}
+	messagePool.Put(msg)
+}
+
+// errMissingType rejects inbound JSON that decodes but names no message type
+var errMissingType = errors.New("message has no type")
+
+// decodeMessage unmarshals an inbound message, into a pooled one if pooled
+// is set. The caller releases pooled messages once handled.
+func decodeMessage(data []byte, pooled bool) (*Message, error) {
+	if !pooled {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		if msg.Type == "" {
+			return nil, errMissingType
+		}
+		return &msg, nil
+	}
+
+	msg := acquireMessage()
+	if err := json.Unmarshal(data, msg); err != nil {
+		releaseMessage(msg)
+		return nil, err
+	}
+	if msg.Type == "" {
+		releaseMessage(msg)
+		return nil, errMissingType
+	}
+	return msg, nil
+}
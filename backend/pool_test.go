@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var benchBid = []byte(`{"type":"submit_bid","gameId":"6f1c2a9e-game","bid":7}`)
+
+// TestRecycledMessageIsClean tests that a recycled message carries no fields from its previous use
+func TestRecycledMessageIsClean(t *testing.T) {
+	percent := 50
+	msg := acquireMessage()
+	*msg = Message{
+		Type:       "submit_bid",
+		GameID:     "game-1",
+		Bid:        3,
+		BidPercent: &percent,
+		Note:       "hello",
+		Users:      []UserInfo{{UserID: "u1"}},
+		GameConfig: &GameConfig{MaxSteps: 3},
+	}
+	releaseMessage(msg)
+
+	// The pool may or may not hand back the same struct; either way it must be empty
+	for i := 0; i < 10; i++ {
+		reused := acquireMessage()
+		if !reflect.DeepEqual(*reused, Message{}) {
+			t.Fatalf("recycled message has stale fields: %+v", *reused)
+		}
+		releaseMessage(reused)
+	}
+
+	// Decoding a sparse message into a recycled struct leaves the rest unset
+	first, err := decodeMessage([]byte(`{"type":"submit_bid","gameId":"g","bidPercent":40}`), true)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	releaseMessage(first)
+	second, err := decodeMessage([]byte(`{"type":"reveal_done"}`), true)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if second.GameID != "" || second.BidPercent != nil {
+		t.Errorf("decoded message picked up stale fields: %+v", *second)
+	}
+	releaseMessage(second)
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeMessage(benchBid, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessagePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg, err := decodeMessage(benchBid, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		releaseMessage(msg)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -48,6 +50,28 @@ func TestMessageSerialization(t *testing.T) {
 					msg.YourPlayer == 1 && msg.OpponentID == "opp123" && msg.OpponentUsername == "Opponent"
 			},
 		},
+		{
+			name: "game_start message with config",
+			msg: Message{
+				Type:   "game_start",
+				GameID: "game789",
+				GameConfig: &GameConfig{
+					MaxSteps:         3,
+					InitialBudget:    20,
+					RoundWinTarget:   2,
+					EventCards:       true,
+					RevealAckSeconds: 5,
+					BidTimeoutSeconds: 20,
+					TieBreaks:        []string{"BALANCE", "POSITION"},
+				},
+			},
+			checkFunc: func(msg Message) bool {
+				return msg.GameConfig != nil && msg.GameConfig.MaxSteps == 3 &&
+					msg.GameConfig.InitialBudget == 20 && msg.GameConfig.RoundWinTarget == 2 &&
+					msg.GameConfig.EventCards && msg.GameConfig.RevealAckSeconds == 5 &&
+					msg.GameConfig.BidTimeoutSeconds == 20 && reflect.DeepEqual(msg.GameConfig.TieBreaks, []string{"BALANCE", "POSITION"})
+			},
+		},
 		{
 			name: "waiting_for_bids message",
 			msg: Message{
@@ -111,6 +135,35 @@ func TestMessageSerialization(t *testing.T) {
 					msg.Winner == 1 && msg.Reason == "Reached final step"
 			},
 		},
+		{
+			name: "game_end message with history",
+			msg: Message{
+				Type:   "game_end",
+				GameID: "game789",
+				Winner: 2,
+				History: []RoundHistory{
+					{Turn: 1, P1Bid: 5, P2Bid: 3, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND", FirstBidder: 2},
+					{Turn: 2, P1Bid: 0, P2Bid: 0, P1NewPos: 1, P2NewPos: 0, Result: "DRAW", TimedOut: 3},
+				},
+			},
+			checkFunc: func(msg Message) bool {
+				return len(msg.History) == 2 &&
+					msg.History[0] == RoundHistory{Turn: 1, P1Bid: 5, P2Bid: 3, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND", FirstBidder: 2} &&
+					msg.History[1] == RoundHistory{Turn: 2, P1Bid: 0, P2Bid: 0, P1NewPos: 1, P2NewPos: 0, Result: "DRAW", TimedOut: 3}
+			},
+		},
+		{
+			name: "error message",
+			msg: Message{
+				Type:      "error",
+				Error:     "Bid exceeds your balance",
+				ErrorCode: ErrBidExceedsBalance,
+			},
+			checkFunc: func(msg Message) bool {
+				return msg.Type == "error" && msg.Error == "Bid exceeds your balance" &&
+					msg.ErrorCode == ErrBidExceedsBalance && msg.Username == ""
+			},
+		},
 		{
 			name: "users_update message",
 			msg: Message{
@@ -153,8 +206,8 @@ func TestMessageSerialization(t *testing.T) {
 // TestChallengeFlow tests the challenge accept flow
 func TestChallengeFlow(t *testing.T) {
 	// Create a hub
-	hub := newHub()
-	go hub.run()
+	hub := newHub(DefaultConfig())
+	go hub.run(context.Background())
 	defer func() {
 		// Clean up - this would need proper channel closure in real code
 	}()
@@ -344,3 +397,113 @@ func TestBidSubmissionProtocol(t *testing.T) {
 		})
 	}
 }
+
+// TestBidOverwrite tests that a bid can be changed until the opponent bids
+func TestBidOverwrite(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.RevealAckTimeout = 5 * time.Second
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 9})
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 25})
+	if *game.Player1Bid != 9 {
+		t.Fatalf("an invalid resubmission must keep the earlier bid, got %d", *game.Player1Bid)
+	}
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 2})
+	if *game.Player1Bid != 2 || game.Status != "WAITING_FOR_BIDS" {
+		t.Fatalf("resubmission should replace the bid, got %d in %s", *game.Player1Bid, game.Status)
+	}
+	if msgs := drainMessages(c2); len(msgs) != 1 || msgs[0].Type != "bid_committed" {
+		t.Errorf("opponent should be told about the bid once, got %+v", msgs)
+	}
+
+	// The replaced bid is the one that resolves
+	hub.handleClientMessage(c2, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	result := lastMessageOfType(drainMessages(c2), "round_result")
+	if result == nil || result.P1Bid != 2 || result.P2Bid != 1 {
+		t.Fatalf("round should resolve with the changed bid, got %+v", result)
+	}
+	if game.Player1Balance != INITIAL_BUDGET-2 {
+		t.Errorf("balance: got %d, want %d", game.Player1Balance, INITIAL_BUDGET-2)
+	}
+
+	// Once resolved, the round takes no more bids
+	drainMessages(c1)
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 5})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrRoundNotOpen {
+		t.Errorf("expected %s error, got %+v", ErrRoundNotOpen, errMsg)
+	}
+	if game.Player1Bid == nil || *game.Player1Bid != 2 {
+		t.Error("a late submission must not change the resolved bid")
+	}
+}
+
+// TestGameStartConfig tests that game_start carries the rules in effect
+func TestGameStartConfig(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.EventCards = true
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+
+	hub.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, RoundWinTarget: 4})
+	received := lastMessageOfType(drainMessages(c2), "challenge_received")
+	hub.handleClientMessage(c2, &Message{Type: "accept_challenge", ChallengeID: received.ChallengeID})
+
+	for _, client := range []*Client{c1, c2} {
+		start := lastMessageOfType(drainMessages(client), "game_start")
+		if start == nil || start.GameConfig == nil {
+			t.Fatal("game_start should carry the game config")
+		}
+		want := GameConfig{MaxSteps: MAX_STEPS, InitialBudget: INITIAL_BUDGET, RoundWinTarget: 4, EventCards: true, MaxRounds: hub.config.MaxRounds, GameMode: GameModeAllPay,
+			BidTimeoutSeconds: 20, TieBreaks: []string{"POSITION"}}
+		if !reflect.DeepEqual(*start.GameConfig, want) {
+			t.Errorf("config: got %+v, want %+v", *start.GameConfig, want)
+		}
+	}
+}
+
+// TestGameEndHistory tests that game_end recaps every round played
+func TestGameEndHistory(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	hub.config.RevealAckTimeout = 0
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+
+	playRound(hub, game, c1, c2, 2, 2)
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(hub, game, c1, c2, 1, 0)
+	}
+
+	end := lastMessageOfType(drainMessages(c2), "game_end")
+	if end == nil || len(end.History) != MAX_STEPS+1 {
+		t.Fatalf("game_end should carry all %d rounds, got %+v", MAX_STEPS+1, end)
+	}
+	if first := end.History[0]; first.Turn != 1 || first.Result != "DRAW" || first.P1Bid != 2 {
+		t.Errorf("first round: got %+v", first)
+	}
+	if last := end.History[MAX_STEPS]; last.P1NewPos != MAX_STEPS || last.Result != "P1_WINS_ROUND" {
+		t.Errorf("last round: got %+v", last)
+	}
+}
+
+// TestBidAfterGameOver tests that a late bid can't touch a finished game
+func TestBidAfterGameOver(t *testing.T) {
+	hub := newHub(DefaultConfig())
+	c1 := newTestClient(hub)
+	c2 := newTestClient(hub)
+	game := startTestGame(t, hub, c1, c2)
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 3})
+	hub.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	drainMessages(c1)
+
+	hub.handleClientMessage(c1, &Message{Type: "submit_bid", GameID: game.ID, Bid: 5})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrGameOver || errMsg.Error != "Game is over" {
+		t.Errorf("expected %s error, got %+v", ErrGameOver, errMsg)
+	}
+	if game.Player1Balance != INITIAL_BUDGET || game.Player1Bid == nil || *game.Player1Bid != 3 {
+		t.Errorf("a finished game must not change, balance %d, bid %v", game.Player1Balance, game.Player1Bid)
+	}
+}
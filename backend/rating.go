@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+)
+
+// Ratings use the Elo system. A user who connects with a login token
+// (?login=...) or an account JWT (see jwt.go) gets a persistent profile
+// carrying their rating; anonymous users are unrated. Only games between two
+// rated players change ratings.
+
+// profileID derives a profile's ID from its login token
+func profileID(loginToken string) string {
+	sum := sha256.Sum256([]byte(loginToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// accountProfileID derives the profile ID of a JWT account, kept apart from
+// login token profiles
+func accountProfileID(subject string) string {
+	return profileID("account:" + subject)
+}
+
+// clientProfile returns the profile for the client's account or, failing
+// that, its login token; nil for an anonymous client
+func (h *Hub) clientProfile(client *Client) *Profile {
+	if client.account != "" {
+		return h.loadProfile(accountProfileID(client.account))
+	}
+	if len(client.loginToken) < MIN_LOGIN_TOKEN_LENGTH {
+		return nil
+	}
+	return h.loadProfile(profileID(client.loginToken))
+}
+
+// loadProfile returns the profile with the given ID, creating it on first use
+func (h *Hub) loadProfile(id string) *Profile {
+	// Connections sharing a profile share its rating
+	for _, user := range h.users {
+		if user.Profile != nil && user.Profile.ID == id {
+			return user.Profile
+		}
+	}
+
+	profile, err := h.store.LoadProfile(id)
+	if err == ErrProfileNotFound {
+		profile = &Profile{ID: id, Rating: INITIAL_RATING}
+		if err := h.store.SaveProfile(profile); err != nil {
+			h.logger.Error("profile_save_failed", "profile_id", id, "error", err)
+		}
+	} else if err != nil {
+		h.logger.Error("profile_load_failed", "profile_id", id, "error", err)
+		return nil
+	}
+	return profile
+}
+
+// rating returns the user's Elo rating, or 0 when they are unrated
+func (u *User) rating() int {
+	if u.Profile == nil {
+		return 0
+	}
+	return u.Profile.Rating
+}
+
+// eloRatings returns both players' new ratings after a game in which player
+// 1 scored score1 (1 for a win, 0.5 for a draw, 0 for a loss)
+func eloRatings(r1, r2 int, score1 float64, k int) (int, int) {
+	expected1 := 1 / (1 + math.Pow(10, float64(r2-r1)/400))
+	delta := int(math.Round(float64(k) * (score1 - expected1)))
+	return r1 + delta, r2 - delta
+}
+
+// dominanceK scales the K-factor by how decisively the game was won: a
+// nail-biter (dominance score 0) counts K and a blowout (100) up to 2K.
+// Draws score 0, so they count K.
+func dominanceK(game *Game, k int) int {
+	return k + k*game.DominanceScore/100
+}
+
+// rated reports whether the game's result changes ratings: both players
+// have distinct profiles
+func (g *Game) rated() bool {
+	p1, p2 := g.Player1.Profile, g.Player2.Profile
+	return p1 != nil && p2 != nil && p1 != p2
+}
+
+// updateRatings applies a finished game's result to both players' ratings
+func (h *Hub) updateRatings(game *Game) {
+	if !game.rated() {
+		return
+	}
+	p1, p2 := game.Player1.Profile, game.Player2.Profile
+
+	var score1 float64
+	switch game.Winner {
+	case 1:
+		score1 = 1
+	case 3:
+		score1 = 0.5
+	}
+	k := h.config.EloK
+	if h.config.EloDominance {
+		k = dominanceK(game, k)
+	}
+	p1.Rating, p2.Rating = eloRatings(p1.Rating, p2.Rating, score1, k)
+	switch game.Winner {
+	case 1:
+		p1.Wins++
+		p2.Losses++
+	case 2:
+		p1.Losses++
+		p2.Wins++
+	default:
+		p1.Draws++
+		p2.Draws++
+	}
+
+	for _, profile := range []*Profile{p1, p2} {
+		profile.Games++
+		if err := h.store.SaveProfile(profile); err != nil {
+			h.logger.Error("profile_save_failed", "profile_id", profile.ID, "error", err)
+		}
+	}
+	h.logger.Info("ratings_updated", "game_id", game.ID, "player1", game.Player1.Username, "rating1", p1.Rating, "player2", game.Player2.Username, "rating2", p2.Rating)
+	h.pushLeaderboards()
+}
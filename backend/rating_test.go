@@ -0,0 +1,127 @@
+package main
+
+import "testing"
+
+// TestEloRatings tests the Elo update for wins, losses and draws
+func TestEloRatings(t *testing.T) {
+	tests := []struct {
+		name         string
+		r1, r2       int
+		score1       float64
+		want1, want2 int
+	}{
+		{"Even win", 1500, 1500, 1, 1516, 1484},
+		{"Even draw", 1500, 1500, 0.5, 1500, 1500},
+		{"Upset", 1400, 1600, 1, 1424, 1576},
+		{"Favourite wins", 1600, 1400, 1, 1608, 1392},
+		{"Draw moves toward expected", 1600, 1400, 0.5, 1592, 1408},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, got2 := eloRatings(tt.r1, tt.r2, tt.score1, 32)
+			if got1 != tt.want1 || got2 != tt.want2 {
+				t.Errorf("got %d/%d, want %d/%d", got1, got2, tt.want1, tt.want2)
+			}
+		})
+	}
+}
+
+// TestDominanceRating tests that, when enabled, a blowout moves ratings
+// further than a nail-biter
+func TestDominanceRating(t *testing.T) {
+	if got := dominanceK(&Game{DominanceScore: 0}, 32); got != 32 {
+		t.Errorf("a nail-biter should count K, got %d", got)
+	}
+	if got := dominanceK(&Game{DominanceScore: 100}, 32); got != 64 {
+		t.Errorf("a perfect blowout should count 2K, got %d", got)
+	}
+
+	h := newHub(DefaultConfig())
+	h.config.EloDominance = true
+	c1 := newLoggedInClient(h, "player-one-login-token")
+	c2 := newLoggedInClient(h, "player-two-login-token")
+	game := startTestGame(t, h, c1, c2)
+	for !game.GameOver {
+		playRound(h, game, c1, c2, 1, 0)
+	}
+	if game.DominanceScore == 0 {
+		t.Fatal("a shutout should have a dominance score")
+	}
+	want, _ := eloRatings(INITIAL_RATING, INITIAL_RATING, 1, dominanceK(game, h.config.EloK))
+	if c1.user.rating() != want || want <= 1516 {
+		t.Errorf("rating after a blowout: got %d, want %d (more than a plain win's 1516)", c1.user.rating(), want)
+	}
+}
+
+// newLoggedInClient connects a test client with a login token
+func newLoggedInClient(h *Hub, loginToken string) *Client {
+	client := &Client{hub: h, send: make(chan []byte, 256), loginToken: loginToken}
+	h.clients[client] = true
+	h.handleConnect(client)
+	return client
+}
+
+// TestRatedGame tests that finished games between logged-in players update and persist ratings
+func TestRatedGame(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.UserListBatchWindow = 0
+	c1 := newLoggedInClient(h, "player-one-login-token")
+	c2 := newLoggedInClient(h, "player-two-login-token")
+	anon := newTestClient(h)
+
+	if welcome := lastMessageOfType(drainMessages(c1), "welcome"); welcome == nil || welcome.Rating != INITIAL_RATING {
+		t.Fatalf("welcome should carry the rating, got %+v", welcome)
+	}
+	if welcome := lastMessageOfType(drainMessages(anon), "welcome"); welcome == nil || welcome.Rating != 0 {
+		t.Errorf("anonymous users should be unrated, got %+v", welcome)
+	}
+
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	if c1.user.rating() != 1516 || c2.user.rating() != 1484 {
+		t.Fatalf("ratings after the game: got %d/%d, want 1516/1484", c1.user.rating(), c2.user.rating())
+	}
+	users := lastMessageOfType(drainMessages(anon), "users_update")
+	if users == nil {
+		t.Fatal("users_update not sent")
+	}
+	for _, info := range users.Users {
+		if info.UserID == c1.user.ID && info.Rating != 1516 {
+			t.Errorf("users_update should carry the new rating, got %d", info.Rating)
+		}
+	}
+
+	// The rating survives a new connection with the same login
+	c3 := newLoggedInClient(h, "player-one-login-token")
+	h.handleUnregister(c1)
+	h.handleUnregister(c3)
+	c4 := newLoggedInClient(h, "player-one-login-token")
+	if welcome := lastMessageOfType(drainMessages(c4), "welcome"); welcome == nil || welcome.Rating != 1516 {
+		t.Errorf("rating should persist across connections, got %+v", welcome)
+	}
+}
+
+// TestLeavingRatedGame tests that dropping out of a rated game loses it
+func TestLeavingRatedGame(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.ReconnectGrace = 0
+	c1 := newLoggedInClient(h, "player-one-login-token")
+	c2 := newLoggedInClient(h, "player-two-login-token")
+	game := startTestGame(t, h, c1, c2)
+	drainMessages(c2)
+
+	h.handleUnregister(c1)
+	end := lastMessageOfType(drainMessages(c2), "game_end")
+	if end == nil || end.Winner != 2 || end.ReasonCode != ReasonOpponentLeft {
+		t.Fatalf("the remaining player should win, got %+v", end)
+	}
+	if c2.user.rating() != 1516 || c2.user.Profile.Wins != 1 {
+		t.Errorf("the win should count: rating %d, wins %d", c2.user.rating(), c2.user.Profile.Wins)
+	}
+	if profile, err := h.store.LoadProfile(c1.user.Profile.ID); err != nil || profile.Rating != 1484 || profile.Losses != 1 {
+		t.Errorf("the leaver's loss should be stored, got %+v, %v", profile, err)
+	}
+	if _, err := h.store.LoadGame(game.ID); err != nil {
+		t.Errorf("the game should be recorded: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"quevadis/gameengine"
+)
+
+// RoundComparison aligns the same round index of two games
+type RoundComparison struct {
+	Round         int           `json:"round"`
+	A             *RoundHistory `json:"a,omitempty"`
+	B             *RoundHistory `json:"b,omitempty"`
+	BidsDiffer    bool          `json:"bidsDiffer"`
+	ResultDiffers bool          `json:"resultDiffers"`
+	Diverged      bool          `json:"diverged"`
+}
+
+// GameComparison is a round-by-round diff of two stored games
+type GameComparison struct {
+	GameA           string            `json:"gameA"`
+	GameB           string            `json:"gameB"`
+	Rounds          []RoundComparison `json:"rounds"`
+	FirstDivergence int               `json:"firstDivergence"` // 0 if the games are identical
+}
+
+// compareGames aligns two games by round index and marks where they diverge.
+// A round present in only one of the games counts as diverged.
+func compareGames(a, b *GameRecord) GameComparison {
+	rounds := len(a.History)
+	if len(b.History) > rounds {
+		rounds = len(b.History)
+	}
+
+	cmp := GameComparison{
+		GameA:  a.ID,
+		GameB:  b.ID,
+		Rounds: make([]RoundComparison, 0, rounds),
+	}
+	for i := 0; i < rounds; i++ {
+		rc := RoundComparison{Round: i + 1}
+		if i < len(a.History) {
+			rc.A = &a.History[i]
+		}
+		if i < len(b.History) {
+			rc.B = &b.History[i]
+		}
+
+		if rc.A != nil && rc.B != nil {
+			rc.BidsDiffer = rc.A.P1Bid != rc.B.P1Bid || rc.A.P2Bid != rc.B.P2Bid
+			rc.ResultDiffers = rc.A.Result != rc.B.Result
+			rc.Diverged = rc.BidsDiffer || rc.ResultDiffers
+		} else {
+			rc.Diverged = true
+		}
+
+		if rc.Diverged && cmp.FirstDivergence == 0 {
+			cmp.FirstDivergence = rc.Round
+		}
+		cmp.Rounds = append(cmp.Rounds, rc)
+	}
+	return cmp
+}
+
+// Bid annotation kinds used by analyzeGame
+const (
+	AnnotationOverbid   = "OVERBID"    // Paid more for a won round than the minimal margin of 1 would have
+	AnnotationWastedBid = "WASTED_BID" // Paid for a round that was lost anyway
+)
+
+// BidAnnotation flags a bid that was suboptimal in hindsight
+type BidAnnotation struct {
+	Round     int    `json:"round"`
+	Player    int    `json:"player"`
+	Kind      string `json:"kind"`
+	Bid       int    `json:"bid"`
+	Suggested int    `json:"suggested"` // Best bid for the same outcome knowing the opponent's bid
+	Wasted    int    `json:"wasted"`    // Paid beyond what the suggested bid would have cost
+}
+
+// GameAnalysis is a stored game's history with per-round bid annotations
+type GameAnalysis struct {
+	GameID      string          `json:"gameId"`
+	History     []RoundHistory  `json:"history"`
+	Annotations []BidAnnotation `json:"annotations"`
+	P1Wasted    int             `json:"p1Wasted"`
+	P2Wasted    int             `json:"p2Wasted"`
+}
+
+// analyzeGame looks back over each round with both bids known and flags bids
+// that could have been cheaper for the same or a better outcome: a winning
+// bid above the opponent's bid plus 1, or a non-zero bid on a lost round.
+// Costs follow the game mode's payment rule, so only bids that were actually
+// paid for are flagged: a lost bid costs nothing in the first- and
+// second-price modes, and overbidding costs nothing in the second-price mode.
+func analyzeGame(record *GameRecord) GameAnalysis {
+	mode := record.Settings.GameMode
+	analysis := GameAnalysis{
+		GameID:      record.ID,
+		History:     record.History,
+		Annotations: []BidAnnotation{},
+	}
+	for i, round := range record.History {
+		bids := [2]int{round.P1Bid, round.P2Bid}
+		for p := 0; p < 2; p++ {
+			bid, opponent := bids[p], bids[1-p]
+			ann := BidAnnotation{Round: i + 1, Player: p + 1, Bid: bid}
+			switch {
+			case bid > opponent+1:
+				ann.Kind = AnnotationOverbid
+				ann.Suggested = opponent + 1
+			case bid < opponent && bid > 0:
+				ann.Kind = AnnotationWastedBid
+				ann.Suggested = 0
+			default:
+				continue
+			}
+			ann.Wasted = bidCost(mode, bid, opponent) - bidCost(mode, ann.Suggested, opponent)
+			if ann.Wasted <= 0 {
+				continue
+			}
+			if p == 0 {
+				analysis.P1Wasted += ann.Wasted
+			} else {
+				analysis.P2Wasted += ann.Wasted
+			}
+			analysis.Annotations = append(analysis.Annotations, ann)
+		}
+	}
+	return analysis
+}
+
+// bidCost is what a player bidding bid against the opponent's bid pays under
+// the game mode
+func bidCost(mode string, bid, opponent int) int {
+	cost, _ := gameengine.Payments(mode, bid, opponent)
+	return cost
+}
+
+// resultHash chains a SHA-256 hash over a game's rounds in order, starting
+// from the game ID. It depends only on the recorded bids, positions, results
+// and timeouts, so recomputing it from a stored replay detects any edit.
+// Rounds without a timeout hash as they did before timeouts were recorded.
+func resultHash(gameID string, history []RoundHistory) string {
+	sum := sha256.Sum256([]byte(gameID))
+	for _, round := range history {
+		h := sha256.New()
+		h.Write(sum[:])
+		fmt.Fprintf(h, "%d|%d|%d|%d|%d|%s|%d",
+			round.Turn, round.P1Bid, round.P2Bid, round.P1NewPos, round.P2NewPos, round.Result, round.FirstBidder)
+		if round.TimedOut != 0 {
+			fmt.Fprintf(h, "|%d", round.TimedOut)
+		}
+		copy(sum[:], h.Sum(nil))
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyRecord reports whether a stored game's history still matches the
+// hash issued when it ended
+func verifyRecord(record *GameRecord) bool {
+	return record.ResultHash != "" && resultHash(record.ID, record.History) == record.ResultHash
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCompareGames tests that the comparison endpoint marks the divergent round
+func TestCompareGames(t *testing.T) {
+	store := newMemoryStore()
+	store.SaveGame(&GameRecord{
+		ID: "game-a",
+		History: []RoundHistory{
+			{Turn: 1, P1Bid: 5, P2Bid: 3, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+			{Turn: 2, P1Bid: 2, P2Bid: 4, P1NewPos: 1, P2NewPos: 1, Result: "P2_WINS_ROUND"},
+		},
+	})
+	store.SaveGame(&GameRecord{
+		ID: "game-b",
+		History: []RoundHistory{
+			{Turn: 1, P1Bid: 5, P2Bid: 3, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+			{Turn: 2, P1Bid: 6, P2Bid: 4, P1NewPos: 2, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+			{Turn: 3, P1Bid: 1, P2Bid: 0, P1NewPos: 3, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/games/game-a/vs/game-b", nil)
+	rec := httptest.NewRecorder()
+	serveGames(store, rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+
+	var cmp GameComparison
+	if err := json.Unmarshal(rec.Body.Bytes(), &cmp); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if len(cmp.Rounds) != 3 {
+		t.Fatalf("rounds: got %d, want 3", len(cmp.Rounds))
+	}
+	if cmp.Rounds[0].Diverged {
+		t.Error("round 1 is identical and should not be marked diverged")
+	}
+	if !cmp.Rounds[1].Diverged || !cmp.Rounds[1].BidsDiffer || !cmp.Rounds[1].ResultDiffers {
+		t.Errorf("round 2 should be marked divergent, got %+v", cmp.Rounds[1])
+	}
+	if !cmp.Rounds[2].Diverged || cmp.Rounds[2].A != nil {
+		t.Errorf("round 3 exists only in game B, got %+v", cmp.Rounds[2])
+	}
+	if cmp.FirstDivergence != 2 {
+		t.Errorf("FirstDivergence: got %d, want 2", cmp.FirstDivergence)
+	}
+
+	// Unknown games are reported as not found
+	rec = httptest.NewRecorder()
+	serveGames(store, rec, httptest.NewRequest(http.MethodGet, "/games/game-a/vs/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status for missing game: got %d, want 404", rec.Code)
+	}
+}
+
+// TestAnalyzeGame tests that the analysis endpoint flags an over-bid round
+func TestAnalyzeGame(t *testing.T) {
+	store := newMemoryStore()
+	store.SaveGame(&GameRecord{
+		ID: "game-a",
+		History: []RoundHistory{
+			{Turn: 1, P1Bid: 3, P2Bid: 2, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+			{Turn: 2, P1Bid: 9, P2Bid: 4, P1NewPos: 2, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	serveGames(store, rec, httptest.NewRequest(http.MethodGet, "/games/game-a/analysis", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+
+	var analysis GameAnalysis
+	if err := json.Unmarshal(rec.Body.Bytes(), &analysis); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if len(analysis.History) != 2 {
+		t.Errorf("history: got %d rounds, want 2", len(analysis.History))
+	}
+
+	var overbid *BidAnnotation
+	for i, ann := range analysis.Annotations {
+		if ann.Round == 1 && ann.Player == 1 {
+			t.Errorf("a minimal winning bid should not be flagged, got %+v", ann)
+		}
+		if ann.Kind == AnnotationOverbid {
+			overbid = &analysis.Annotations[i]
+		}
+	}
+	if overbid == nil || overbid.Round != 2 || overbid.Player != 1 || overbid.Suggested != 5 || overbid.Wasted != 4 {
+		t.Fatalf("round 2 over-bid should be flagged, got %+v", analysis.Annotations)
+	}
+}
+
+// TestAnalyzeGameModes tests that annotations only count what the game mode
+// actually charges for a bid
+func TestAnalyzeGameModes(t *testing.T) {
+	history := []RoundHistory{
+		{Turn: 1, P1Bid: 9, P2Bid: 4, P1NewPos: 1, P2NewPos: 0, Result: "P1_WINS_ROUND"},
+	}
+
+	// The loser pays nothing in either mode, and the winner pays the
+	// loser's bid in the second-price mode however much they bid
+	second := analyzeGame(&GameRecord{Settings: GameSettings{GameMode: GameModeSecondPrice}, History: history})
+	if len(second.Annotations) != 0 {
+		t.Errorf("second price: nothing was paid beyond need, got %+v", second.Annotations)
+	}
+
+	first := analyzeGame(&GameRecord{Settings: GameSettings{GameMode: GameModeFirstPrice}, History: history})
+	if len(first.Annotations) != 1 {
+		t.Fatalf("first price: only the over-bid should be flagged, got %+v", first.Annotations)
+	}
+	if ann := first.Annotations[0]; ann.Kind != AnnotationOverbid || ann.Player != 1 || ann.Suggested != 5 || ann.Wasted != 4 {
+		t.Errorf("first price: the winner paid 4 more than needed, got %+v", ann)
+	}
+}
+
+// TestResultHashDetectsTampering tests that editing a stored round changes the recomputed hash
+func TestResultHashDetectsTampering(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	for i := 0; i < MAX_STEPS; i++ {
+		playRound(h, game, c1, c2, 2, 1)
+	}
+
+	end := lastMessageOfType(drainMessages(c1), "game_end")
+	if end == nil || end.ResultHash == "" {
+		t.Fatalf("game_end should carry a result hash, got %+v", end)
+	}
+
+	record, err := h.store.LoadGame(game.ID)
+	if err != nil {
+		t.Fatalf("LoadGame: %v", err)
+	}
+	if record.ResultHash != end.ResultHash || !verifyRecord(record) {
+		t.Fatal("the stored replay should reproduce the issued hash")
+	}
+
+	record.History[1].P1Bid = 1
+	if resultHash(record.ID, record.History) == end.ResultHash || verifyRecord(record) {
+		t.Error("tampering with a round should change the recomputed hash")
+	}
+
+	// A bid of 0 made in time and one forced by the timer are different games
+	history := []RoundHistory{{Turn: 1, P1Bid: 3, P2Bid: 0, P1NewPos: 1, Result: "P1_WINS_ROUND", FirstBidder: 1}}
+	inTime := resultHash("g", history)
+	history[0].TimedOut = 2
+	if resultHash("g", history) == inTime {
+		t.Error("a timed-out bid should change the hash")
+	}
+}
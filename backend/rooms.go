@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// Private rooms let two friends meet without the lobby: the creator gets a
+// short join code to share, and whoever joins with it plays the creator.
+// A room is single-use and lasts until Config.RoomTTL or the creator leaves.
+
+// roomCodeAlphabet leaves out characters that are easy to misread (0/O, 1/I/L)
+const roomCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// newRoomCode returns a random join code not used by an open room
+func (h *Hub) newRoomCode() string {
+	buf := make([]byte, ROOM_CODE_LENGTH)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			panic("room code: " + err.Error())
+		}
+		code := make([]byte, ROOM_CODE_LENGTH)
+		for i, b := range buf {
+			code[i] = roomCodeAlphabet[int(b)%len(roomCodeAlphabet)]
+		}
+		if _, taken := h.rooms[string(code)]; !taken {
+			return string(code)
+		}
+	}
+}
+
+// handleCreateRoom opens a room for the user, replacing any room they
+// already have open, and sends them its join code
+func (h *Hub) handleCreateRoom(user *User, msg *Message) {
+	if !h.canJoinGame(user) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	settings := GameSettings{
+		RoundWinTarget: msg.RoundWinTarget,
+		BestOf:         msg.BestOf,
+		GameMode:       msg.GameMode,
+		HideBalance:    msg.HideBalance,
+		MaxSteps:       msg.MaxSteps,
+		InitialBudget:  msg.InitialBudget,
+		Private:        msg.Private,
+	}
+	if code := settings.validate(h.config.SuddenDeath); code != "" {
+		h.sendError(user, code)
+		return
+	}
+
+	h.removeRooms(user)
+	room := &Room{
+		Code:     h.newRoomCode(),
+		Creator:  user,
+		Created:  h.now(),
+		Settings: settings,
+	}
+	h.rooms[room.Code] = room
+	h.sendToUser(user, &Message{Type: "room_created", RoomCode: room.Code, Private: settings.Private})
+	if settings.Private {
+		h.broadcastUserList()
+	}
+	h.logger.Info("room_open", "room", room.Code, "user", user.Username)
+}
+
+// handleJoinRoom starts a game between the room's creator and the user
+func (h *Hub) handleJoinRoom(user *User, msg *Message) {
+	code := strings.ToUpper(strings.TrimSpace(msg.RoomCode))
+	room, exists := h.rooms[code]
+	if !exists {
+		h.sendError(user, ErrUnknownRoom)
+		return
+	}
+	if room.Creator == user {
+		return
+	}
+	if !h.canJoinGame(user) || !h.canJoinGame(room.Creator) {
+		h.sendError(user, ErrUserInGame)
+		return
+	}
+
+	delete(h.rooms, code)
+	game := h.createGame(room.Creator, user, room.Settings, nil)
+	h.broadcastUserList()
+	h.logger.Info("game_start", "game_id", game.ID, "player1", room.Creator.Username, "player2", user.Username, "room", code)
+}
+
+// removeRooms closes the rooms the user created
+func (h *Hub) removeRooms(user *User) {
+	for code, room := range h.rooms {
+		if room.Creator == user {
+			delete(h.rooms, code)
+			if room.Settings.Private {
+				h.broadcastUserList()
+			}
+		}
+	}
+}
+
+// checkExpiredRooms closes rooms nobody joined within Config.RoomTTL
+func (h *Hub) checkExpiredRooms() {
+	if h.config.RoomTTL <= 0 {
+		return
+	}
+	now := h.now()
+	for code, room := range h.rooms {
+		if now.Sub(room.Created) > h.config.RoomTTL {
+			delete(h.rooms, code)
+			h.sendToUser(room.Creator, &Message{Type: "room_expired", RoomCode: code})
+			if room.Settings.Private {
+				h.broadcastUserList()
+			}
+			h.logger.Info("room_expired", "room", code)
+		}
+	}
+}
+
+// isHidden reports whether the user is left out of user lists: they are
+// waiting in a private room or playing a game that started from one
+func (h *Hub) isHidden(user *User) bool {
+	for _, room := range h.rooms {
+		if room.Creator == user && room.Settings.Private {
+			return true
+		}
+	}
+	for gameID := range user.GameIDs {
+		if game, exists := h.games[gameID]; exists && !game.GameOver && game.Settings.Private {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestJoinRoom tests that joining with a room's code starts a game against its creator
+func TestJoinRoom(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.UserListBatchWindow = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "create_room", MaxSteps: 5, Private: true})
+	created := lastMessageOfType(drainMessages(c1), "room_created")
+	if created == nil || len(created.RoomCode) != ROOM_CODE_LENGTH || !created.Private {
+		t.Fatalf("creator should get a join code, got %+v", created)
+	}
+	users := lastMessageOfType(drainMessages(c3), "users_update")
+	if users == nil {
+		t.Fatal("users_update not sent")
+	}
+	for _, info := range users.Users {
+		if info.UserID == c1.user.ID {
+			t.Error("a private room's creator should be hidden from the user list")
+		}
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "join_room", RoomCode: "nope"})
+	if errMsg := lastMessageOfType(drainMessages(c2), "error"); errMsg == nil || errMsg.ErrorCode != ErrUnknownRoom {
+		t.Errorf("unknown code should be rejected, got %+v", errMsg)
+	}
+
+	// Codes are not case sensitive
+	h.handleClientMessage(c2, &Message{Type: "join_room", RoomCode: " " + strings.ToLower(created.RoomCode) + " "})
+	start := lastMessageOfType(drainMessages(c2), "game_start")
+	if start == nil {
+		t.Fatal("joining should start a game")
+	}
+	game := h.games[start.GameID]
+	if game.Player1 != c1.user || game.Player2 != c2.user || game.maxSteps() != 5 {
+		t.Errorf("game should pit the creator against the joiner with the room's settings, got %+v", game)
+	}
+	if len(h.rooms) != 0 {
+		t.Error("a room is single-use")
+	}
+	users = lastMessageOfType(drainMessages(c3), "users_update")
+	if users == nil || len(users.Users) != 1 {
+		t.Errorf("players of a private game should stay hidden, got %+v", users)
+	}
+	if len(h.collectLiveGames()) != 0 {
+		t.Error("private games should not be listed for spectators")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	users = lastMessageOfType(drainMessages(c3), "users_update")
+	if users == nil || len(users.Users) != 3 {
+		t.Errorf("players should reappear once the game is over, got %+v", users)
+	}
+}
+
+// TestRoomCleanup tests that rooms close when their creator leaves or nobody joins in time
+func TestRoomCleanup(t *testing.T) {
+	h := newHub(DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "create_room"})
+	h.handleDisconnect(c1)
+	if len(h.rooms) != 0 {
+		t.Fatal("a room should close when its creator disconnects")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "create_room"})
+	code := lastMessageOfType(drainMessages(c2), "room_created").RoomCode
+	now = now.Add(h.config.RoomTTL + time.Second)
+	h.checkExpiredRooms()
+	if expired := lastMessageOfType(drainMessages(c2), "room_expired"); expired == nil || expired.RoomCode != code {
+		t.Errorf("creator should be told the room expired, got %+v", expired)
+	}
+	if len(h.rooms) != 0 {
+		t.Error("an unused room should expire")
+	}
+}
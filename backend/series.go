@@ -0,0 +1,136 @@
+package main
+
+// A best-of-N series plays games between the same two players, in the same
+// seats, until one of them has won more than half of N. The series is also
+// decided after N games (draws count for nobody), and forfeited by whoever
+// resigns or leaves during any of its games. A series still tied after N
+// games goes to a single sudden-death decider when the server plays them.
+
+// Series tracks the score of a best-of-N match
+type Series struct {
+	BestOf      int
+	Games       int // Games started so far
+	Player1Wins int
+	Player2Wins int
+	Draws       int
+	Over        bool
+	Winner      int // 1 or 2, 3 for a drawn series; set once Over
+
+	// Settings the series was challenged with, for each game and a rematch,
+	// and those of the sudden-death game played if it ends tied (nil when a
+	// tie is a drawn series)
+	Settings    GameSettings
+	Decider     *GameSettings
+	SuddenDeath bool // The decider is being played
+}
+
+// SeriesScore is the series state sent in game_start, series_update and
+// series_end
+type SeriesScore struct {
+	BestOf      int  `json:"bestOf"`
+	Game        int  `json:"game"` // Current or last game, from 1
+	Player1Wins int  `json:"p1Wins"`
+	Player2Wins int  `json:"p2Wins"`
+	Draws       int  `json:"draws"`
+	SuddenDeath bool `json:"suddenDeath,omitempty"` // Game is the sudden-death decider
+}
+
+// score returns the wire form of the series, nil outside a series
+func (s *Series) score() *SeriesScore {
+	if s == nil {
+		return nil
+	}
+	return &SeriesScore{
+		BestOf:      s.BestOf,
+		Game:        s.Games,
+		Player1Wins: s.Player1Wins,
+		Player2Wins: s.Player2Wins,
+		Draws:       s.Draws,
+		SuddenDeath: s.SuddenDeath,
+	}
+}
+
+// deciderSettings returns the sudden-death settings for a series played with
+// the given settings, nil when the server doesn't play deciders
+func (h *Hub) deciderSettings(settings GameSettings) *GameSettings {
+	if !h.config.SuddenDeath {
+		return nil
+	}
+	decider := settings
+	decider.BestOf = 0
+	if h.config.SuddenDeathSteps > 0 {
+		decider.MaxSteps = h.config.SuddenDeathSteps
+	}
+	if h.config.SuddenDeathBudget > 0 {
+		decider.InitialBudget = h.config.SuddenDeathBudget
+	}
+	return &decider
+}
+
+// advanceSeries scores a finished game of a series and either starts the
+// next game or ends the series
+func (h *Hub) advanceSeries(game *Game) {
+	series := game.Series
+	if series == nil || series.Over {
+		return
+	}
+	switch game.Winner {
+	case 1:
+		series.Player1Wins++
+	case 2:
+		series.Player2Wins++
+	default:
+		series.Draws++
+	}
+
+	clinch := series.BestOf/2 + 1
+	switch {
+	case game.Reason == ReasonOpponentResigned || game.Reason == ReasonOpponentLeft:
+		h.endSeries(game, game.Winner)
+	case series.Player1Wins >= clinch:
+		h.endSeries(game, 1)
+	case series.Player2Wins >= clinch:
+		h.endSeries(game, 2)
+	case series.Games >= series.BestOf && series.Player1Wins == series.Player2Wins &&
+		series.Decider != nil && !series.SuddenDeath:
+		series.SuddenDeath = true
+		h.nextSeriesGame(game, *series.Decider)
+	case series.Games >= series.BestOf:
+		winner := 3
+		if series.Player1Wins > series.Player2Wins {
+			winner = 1
+		} else if series.Player2Wins > series.Player1Wins {
+			winner = 2
+		}
+		h.endSeries(game, winner)
+	default:
+		h.nextSeriesGame(game, series.Settings)
+	}
+}
+
+// nextSeriesGame tells both players the series score and starts its next
+// game with the given settings
+func (h *Hub) nextSeriesGame(game *Game, settings GameSettings) {
+	series := game.Series
+	updateMsg := Message{Type: "series_update", GameID: game.ID, Series: series.score()}
+	h.sendToUser(game.Player1, &updateMsg)
+	h.sendToUser(game.Player2, &updateMsg)
+
+	next := h.createGame(game.Player1, game.Player2, settings, series)
+	h.broadcastUserList()
+	h.logger.Info("game_start", "game_id", next.ID, "player1", game.Player1.Username, "player2", game.Player2.Username,
+		"series_game", series.Games, "best_of", series.BestOf, "sudden_death", series.SuddenDeath)
+}
+
+// endSeries decides the series of the game for the given player (3 for a
+// draw, 0 when aborted) and tells both players
+func (h *Hub) endSeries(game *Game, winner int) {
+	series := game.Series
+	series.Over = true
+	series.Winner = winner
+
+	endMsg := Message{Type: "series_end", GameID: game.ID, Winner: winner, Series: series.score()}
+	h.sendToUser(game.Player1, &endMsg)
+	h.sendToUser(game.Player2, &endMsg)
+	h.logger.Info("series_end", "player1", game.Player1.Username, "wins1", series.Player1Wins, "player2", game.Player2.Username, "wins2", series.Player2Wins)
+}
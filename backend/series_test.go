@@ -0,0 +1,152 @@
+package main
+
+import "testing"
+
+// winGame plays rounds in which the given player outbids the other until the game ends
+func winGame(t *testing.T, h *Hub, game *Game, c1, c2 *Client, winner int) {
+	t.Helper()
+	for i := 0; !game.GameOver; i++ {
+		if i > game.maxSteps() {
+			t.Fatal("game did not end")
+		}
+		if winner == 1 {
+			playRound(h, game, c1, c2, 2, 1)
+		} else {
+			playRound(h, game, c1, c2, 1, 2)
+		}
+	}
+}
+
+// nextSeriesGame returns the game started after a series_update
+func nextSeriesGame(t *testing.T, h *Hub, c1, c2 *Client) (*Game, *Message) {
+	t.Helper()
+	drainMessages(c2)
+	msgs := drainMessages(c1)
+	update := lastMessageOfType(msgs, "series_update")
+	start := lastMessageOfType(msgs, "game_start")
+	if update == nil || start == nil {
+		t.Fatalf("series should continue, got update=%+v start=%+v", update, start)
+	}
+	return h.games[start.GameID], update
+}
+
+// TestSeriesPlaysUntilClinched tests that a best-of-3 runs game after game until someone has two wins
+func TestSeriesPlaysUntilClinched(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGameWith(t, h, c1, c2, Message{BestOf: 3, MaxSteps: 2})
+	if game.Series == nil || game.Series.BestOf != 3 || game.Series.Games != 1 {
+		t.Fatalf("challenge should start a series, got %+v", game.Series)
+	}
+
+	winGame(t, h, game, c1, c2, 1)
+	game, update := nextSeriesGame(t, h, c1, c2)
+	if s := update.Series; s.Game != 1 || s.Player1Wins != 1 || s.Player2Wins != 0 {
+		t.Errorf("series_update after game 1: got %+v", s)
+	}
+	if game.maxSteps() != 2 || game.Player1 != c1.user || game.Series.Games != 2 {
+		t.Fatalf("next game should keep the settings and seats, got %+v", game)
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: update.GameID})
+	if len(h.rematches) != 0 {
+		t.Error("no rematch while the series is still being played")
+	}
+
+	winGame(t, h, game, c1, c2, 2)
+	game, update = nextSeriesGame(t, h, c1, c2)
+	if s := update.Series; s.Player1Wins != 1 || s.Player2Wins != 1 {
+		t.Errorf("series_update after game 2: got %+v", s)
+	}
+
+	winGame(t, h, game, c1, c2, 2)
+	msgs := drainMessages(c2)
+	end := lastMessageOfType(msgs, "series_end")
+	if end == nil || end.Winner != 2 || end.Series.Player2Wins != 2 || end.Series.Game != 3 {
+		t.Fatalf("player 2 should take the series 2-1, got %+v", end)
+	}
+	if lastMessageOfType(msgs, "game_start") != nil {
+		t.Error("no game should start after the series is decided")
+	}
+}
+
+// TestSuddenDeathDecider tests that a best-of-2 ending 1-1 goes to a decider
+// played with the sudden-death settings
+func TestSuddenDeathDecider(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	h.config.SuddenDeath = true
+	h.config.SuddenDeathSteps = 2
+	h.config.SuddenDeathBudget = 10
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGameWith(t, h, c1, c2, Message{BestOf: 2, MaxSteps: 4})
+	if game.Series == nil || game.Series.Decider == nil {
+		t.Fatalf("series should carry its decider settings, got %+v", game.Series)
+	}
+
+	winGame(t, h, game, c1, c2, 1)
+	game, _ = nextSeriesGame(t, h, c1, c2)
+	winGame(t, h, game, c1, c2, 2)
+	decider, update := nextSeriesGame(t, h, c1, c2)
+	if s := update.Series; s.Player1Wins != 1 || s.Player2Wins != 1 || !s.SuddenDeath {
+		t.Errorf("series_update before the decider: got %+v", s)
+	}
+	if decider.maxSteps() != 2 || decider.initialBudget() != 10 || decider.Player1Balance != 10 {
+		t.Errorf("decider should race to 2 with 10 each, got steps %d budget %d", decider.maxSteps(), decider.initialBudget())
+	}
+	if decider.Settings.GameMode != game.Settings.GameMode || decider.Series.Games != 3 {
+		t.Errorf("decider should keep the series rules as its third game, got %+v", decider.Settings)
+	}
+
+	winGame(t, h, decider, c1, c2, 1)
+	end := lastMessageOfType(drainMessages(c1), "series_end")
+	if end == nil || end.Winner != 1 || end.Series.Player1Wins != 2 {
+		t.Fatalf("the decider should settle the series for player 1, got %+v", end)
+	}
+
+	// A rematch replays the series, not the decider
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: decider.ID})
+	h.handleClientMessage(c2, &Message{Type: "rematch", GameID: decider.ID})
+	start := lastMessageOfType(drainMessages(c1), "game_start")
+	if start == nil {
+		t.Fatal("rematch should start")
+	}
+	rematch := h.games[start.GameID]
+	if rematch.Series == nil || rematch.Series.BestOf != 2 || rematch.maxSteps() != 4 || rematch.initialBudget() != h.config.InitialBudget {
+		t.Errorf("rematch should be a new best-of-2 on the series' track, got %+v", rematch.Settings)
+	}
+}
+
+// TestSeriesForfeit tests that resigning one game concedes the whole series
+func TestSeriesForfeit(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+
+	h.handleClientMessage(c1, &Message{Type: "challenge", TargetUserID: c2.user.ID, BestOf: 4})
+	if errMsg := lastMessageOfType(drainMessages(c1), "error"); errMsg == nil || errMsg.ErrorCode != ErrInvalidBestOf {
+		t.Errorf("an even series length should be rejected, got %+v", errMsg)
+	}
+
+	game := startTestGameWith(t, h, c1, c2, Message{BestOf: 5})
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+	msgs := drainMessages(c2)
+	end := lastMessageOfType(msgs, "series_end")
+	if end == nil || end.Winner != 2 || !game.Series.Over {
+		t.Fatalf("resigning should forfeit the series, got %+v", end)
+	}
+	if lastMessageOfType(msgs, "game_start") != nil {
+		t.Error("no game should start after a forfeit")
+	}
+
+	h.handleClientMessage(c1, &Message{Type: "rematch", GameID: game.ID})
+	h.handleClientMessage(c2, &Message{Type: "rematch", GameID: game.ID})
+	start := lastMessageOfType(drainMessages(c1), "game_start")
+	if start == nil || start.Series == nil || start.Series.Game != 1 || start.Series.Player2Wins != 0 {
+		t.Errorf("a rematch after the series should start a new one, got %+v", start)
+	}
+}
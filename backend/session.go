@@ -0,0 +1,53 @@
+package main
+
+// SessionStats counts a user's results since they connected. Users with a
+// persistent profile keep theirs across connections for as long as the
+// server runs; anonymous users start over on every connection.
+type SessionStats struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+	Streak int `json:"streak"` // Consecutive wins, reset by a loss or draw
+}
+
+// record adds one game's result: the player's number, or 3 for a draw, as
+// in Game.Winner
+func (s *SessionStats) record(player, winner int) {
+	switch winner {
+	case player:
+		s.Wins++
+		s.Streak++
+	case 3:
+		s.Draws++
+		s.Streak = 0
+	default:
+		s.Losses++
+		s.Streak = 0
+	}
+}
+
+// sessionStatsFor returns the stats a new connection starts with: those of
+// an earlier connection with the same profile, or fresh ones
+func (h *Hub) sessionStatsFor(profile *Profile) *SessionStats {
+	if profile == nil {
+		return &SessionStats{}
+	}
+	stats, ok := h.profileSessions[profile.ID]
+	if !ok {
+		stats = &SessionStats{}
+		h.profileSessions[profile.ID] = stats
+	}
+	return stats
+}
+
+// recordSessionResults counts a finished game in both players' session
+// stats and sends each of them a stats_update
+func (h *Hub) recordSessionResults(game *Game) {
+	for i, player := range []*User{game.Player1, game.Player2} {
+		if player.Session == nil || player.Peer != "" {
+			continue
+		}
+		player.Session.record(i+1, game.Winner)
+		h.sendToUser(player, &Message{Type: "stats_update", SessionStats: player.Session, LadderRung: player.ladderRung()})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSessionStats tests that results and win streaks are counted per
+// session and sent after every game
+func TestSessionStats(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	if welcome := lastMessageOfType(drainMessages(c1), "welcome"); welcome == nil || welcome.SessionStats == nil || *welcome.SessionStats != (SessionStats{}) {
+		t.Fatalf("welcome should carry empty session stats, got %+v", welcome)
+	}
+
+	for i := 0; i < 2; i++ {
+		game := startTestGame(t, h, c1, c2)
+		for !game.GameOver {
+			playRound(h, game, c1, c2, 3, 1)
+		}
+	}
+	update := lastMessageOfType(drainMessages(c1), "stats_update")
+	if update == nil || *update.SessionStats != (SessionStats{Wins: 2, Streak: 2}) {
+		t.Fatalf("winner's stats_update: got %+v", update)
+	}
+
+	// Resigning counts as a loss and ends the streak
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c1, &Message{Type: "resign", GameID: game.ID})
+	if update := lastMessageOfType(drainMessages(c1), "stats_update"); update == nil || *update.SessionStats != (SessionStats{Wins: 2, Losses: 1}) {
+		t.Errorf("resigner's stats_update: got %+v", update)
+	}
+	if update := lastMessageOfType(drainMessages(c2), "stats_update"); update == nil || *update.SessionStats != (SessionStats{Wins: 1, Losses: 2, Streak: 1}) {
+		t.Errorf("opponent's stats_update: got %+v", update)
+	}
+}
+
+// TestSessionStatsPersistentIdentity tests that a profile keeps its session
+// stats across connections while an anonymous user starts over
+func TestSessionStatsPersistentIdentity(t *testing.T) {
+	h := newHub(DefaultConfig())
+	login := strings.Repeat("k", MIN_LOGIN_TOKEN_LENGTH)
+	c1 := &Client{hub: h, send: make(chan []byte, 256), loginToken: login}
+	h.handleRegister(c1)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	h.handleUnregister(c1)
+	h.handleUnregister(c2)
+
+	again := &Client{hub: h, send: make(chan []byte, 256), loginToken: login}
+	h.handleRegister(again)
+	if welcome := lastMessageOfType(drainMessages(again), "welcome"); welcome == nil || welcome.SessionStats == nil || welcome.SessionStats.Wins != 1 {
+		t.Errorf("profile should keep its session stats, got %+v", welcome)
+	}
+	fresh := newTestClient(h)
+	if fresh.user.Session.Losses != 0 {
+		t.Error("an anonymous connection should start with empty stats")
+	}
+}
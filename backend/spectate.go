@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Spectators watch a game without taking part: they are sent the round
+// broadcasts but are not players, so the game handlers ignore anything
+// they send about it.
+
+// handleSpectate adds the client to the spectators of a game in progress and
+// sends it the current state of the board
+func (h *Hub) handleSpectate(client *Client, msg *Message) {
+	user := client.user
+	game, exists := h.games[msg.GameID]
+	if !exists || game.GameOver || game.Settings.Private {
+		h.sendError(user, ErrGameNotLive)
+		return
+	}
+	if game.Player1.ID == user.ID || game.Player2.ID == user.ID {
+		return
+	}
+	for _, spectator := range h.spectators[game.ID] {
+		if spectator == client {
+			return
+		}
+	}
+	if limit := h.config.MaxSpectatorsPerGame; limit > 0 && len(h.spectators[game.ID]) >= limit {
+		h.sendError(user, ErrSpectatorLimit)
+		return
+	}
+	h.spectators[game.ID] = append(h.spectators[game.ID], client)
+	h.spectatorsChanged(game.ID)
+
+	config := h.gameConfig(game)
+	state := buildStateFor(game, 0)
+	state.Type = "spectating"
+	state.Users = []UserInfo{
+		{UserID: game.Player1.ID, Username: game.Player1.Username, InGame: true, Color: game.Player1Color},
+		{UserID: game.Player2.ID, Username: game.Player2.Username, InGame: true, Color: game.Player2Color},
+	}
+	state.GameConfig = &config
+	h.sendToClient(client, &state)
+	board := boardState(game, 0)
+	h.sendToClient(client, &board)
+
+	h.logger.Info("spectate", "game_id", game.ID, "user", user.Username)
+}
+
+// sendToSpectators sends a message to everyone watching the game
+func (h *Hub) sendToSpectators(game *Game, msg *Message) {
+	for _, spectator := range h.spectators[game.ID] {
+		h.sendToClient(spectator, msg)
+	}
+}
+
+// isSpectating reports whether the client is watching any game
+func (h *Hub) isSpectating(client *Client) bool {
+	for _, spectators := range h.spectators {
+		for _, spectator := range spectators {
+			if spectator == client {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeSpectator drops the client from every game it is watching
+func (h *Hub) removeSpectator(client *Client) {
+	for gameID, spectators := range h.spectators {
+		for i, spectator := range spectators {
+			if spectator == client {
+				spectators = append(spectators[:i], spectators[i+1:]...)
+				if len(spectators) == 0 {
+					delete(h.spectators, gameID)
+				} else {
+					h.spectators[gameID] = spectators
+				}
+				h.spectatorsChanged(gameID)
+				break
+			}
+		}
+	}
+}
+
+// spectatorsChanged recounts a game's spectators and tells its players
+// while the game is live
+func (h *Hub) spectatorsChanged(gameID string) {
+	game, exists := h.games[gameID]
+	if !exists {
+		return
+	}
+	game.SpectatorCount = len(h.spectators[gameID])
+	if game.GameOver {
+		return
+	}
+	msg := Message{Type: "spectator_count", GameID: gameID, SpectatorCount: game.SpectatorCount}
+	h.sendToUser(game.Player1, &msg)
+	h.sendToUser(game.Player2, &msg)
+}
+
+// handleSetAutoSpectate toggles the user's auto_spectate_on_end preference
+func (h *Hub) handleSetAutoSpectate(user *User, msg *Message) {
+	user.AutoSpectateOnEnd = msg.Enabled
+}
+
+// autoSpectate attaches a player whose game just ended to the most-watched
+// live game, if they asked for that and aren't playing or watching already
+func (h *Hub) autoSpectate(user *User) {
+	if !user.AutoSpectateOnEnd || user.InGame || user.Client == nil || user.Peer != "" || h.isSpectating(user.Client) {
+		return
+	}
+	var best *Game
+	for _, game := range h.games {
+		if !listedLive(game) || game.Player1 == user || game.Player2 == user {
+			continue
+		}
+		if limit := h.config.MaxSpectatorsPerGame; limit > 0 && game.SpectatorCount >= limit {
+			continue
+		}
+		if best == nil || game.SpectatorCount > best.SpectatorCount ||
+			(game.SpectatorCount == best.SpectatorCount && game.ID < best.ID) {
+			best = game
+		}
+	}
+	if best == nil {
+		return
+	}
+	h.sendToUser(user, &Message{Type: "now_watching", GameID: best.ID})
+	h.handleSpectate(user.Client, &Message{GameID: best.ID})
+}
+
+// listedLive reports whether a game is listed for spectators: live, between
+// two people and not private
+func listedLive(game *Game) bool {
+	return !game.GameOver && game.Ghost == nil && !game.Player1.IsBot && !game.Player2.IsBot && !game.Settings.Private
+}
+
+// LiveGame is one entry of the GET /games listing of games open to spectators
+type LiveGame struct {
+	GameID     string `json:"gameId"`
+	Player1    string `json:"player1"`
+	Player2    string `json:"player2"`
+	Round      int    `json:"round"`
+	P1Position int    `json:"p1Position"`
+	P2Position int    `json:"p2Position"`
+	Spectators int    `json:"spectators"`
+}
+
+// collectLiveGames lists the games a user can spectate, longest-running
+// first. Bot, ghost and private games are left out. It must run on the hub goroutine.
+func (h *Hub) collectLiveGames() []LiveGame {
+	games := []LiveGame{}
+	for _, game := range h.games {
+		if !listedLive(game) {
+			continue
+		}
+		games = append(games, LiveGame{
+			GameID:     game.ID,
+			Player1:    game.Player1.Username,
+			Player2:    game.Player2.Username,
+			Round:      game.CurrentRound,
+			P1Position: game.Player1Pos,
+			P2Position: game.Player2Pos,
+			Spectators: game.SpectatorCount,
+		})
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Round != games[j].Round {
+			return games[i].Round > games[j].Round
+		}
+		return games[i].GameID < games[j].GameID
+	})
+	return games
+}
+
+// serveLiveGames answers GET /games with the spectatable games, as listed by
+// the hub loop
+func serveLiveGames(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply := make(chan []LiveGame, 1)
+	select {
+	case hub.liveGamesRequests <- reply:
+	case <-hub.stopped:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case games := <-reply:
+		writeJSON(w, http.StatusOK, games)
+	case <-r.Context().Done():
+	}
+}
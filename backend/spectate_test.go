@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSpectatorReceivesRounds tests that a spectator follows the game but can't play it
+func TestSpectatorReceivesRounds(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	drainMessages(watcher)
+
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	msgs := drainMessages(watcher)
+	state := lastMessageOfType(msgs, "spectating")
+	if state == nil {
+		t.Fatal("spectator should be sent the current state")
+	}
+	if len(state.Users) != 2 || state.Users[0].UserID != c1.user.ID || state.Users[1].UserID != c2.user.ID {
+		t.Errorf("spectating should list both players in order, got %+v", state.Users)
+	}
+
+	playRound(h, game, c1, c2, 2, 1)
+	msgs = drainMessages(watcher)
+	if result := lastMessageOfType(msgs, "round_result"); result == nil || result.P1Bid != 2 || result.P2Bid != 1 {
+		t.Errorf("spectator should get the round result, got %+v", result)
+	}
+	if lastMessageOfType(msgs, "waiting_for_bids") == nil {
+		t.Error("spectator should get the next round")
+	}
+
+	// Anything the spectator sends about the game is ignored
+	h.handleClientMessage(watcher, &Message{Type: "submit_bid", GameID: game.ID, Bid: 1})
+	if game.Player1Bid != nil || game.Player2Bid != nil {
+		t.Error("spectator bids must not be recorded")
+	}
+	h.handleClientMessage(watcher, &Message{Type: "resign", GameID: game.ID})
+	if game.GameOver {
+		t.Fatal("spectator must not be able to resign the game")
+	}
+
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+	if end := lastMessageOfType(drainMessages(watcher), "game_end"); end == nil || end.Winner != 1 {
+		t.Errorf("spectator should get game_end, got %+v", end)
+	}
+
+	h.handleClientMessage(watcher, &Message{Type: "rematch", GameID: game.ID})
+	if pending := h.rematches[game.ID]; pending != nil && !pending.requested[watcher.user.ID].IsZero() {
+		t.Error("spectator must not be able to ask for a rematch")
+	}
+}
+
+// TestSpectatorCleanup tests that spectators are dropped on disconnect and with their game
+func TestSpectatorCleanup(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watcher := newTestClient(h)
+	other := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+	h.handleClientMessage(other, &Message{Type: "spectate", GameID: game.ID})
+	h.handleClientMessage(other, &Message{Type: "spectate", GameID: game.ID})
+	if n := len(h.spectators[game.ID]); n != 2 {
+		t.Fatalf("spectators: got %d, want 2", n)
+	}
+
+	h.handleUnregister(watcher)
+	if spectators := h.spectators[game.ID]; len(spectators) != 1 || spectators[0] != other {
+		t.Fatalf("disconnected spectator should be removed, got %v", spectators)
+	}
+
+	// Without a reconnect grace the game is deleted when a player leaves
+	h.config.ReconnectGrace = 0
+	h.handleUnregister(c1)
+	if _, ok := h.spectators[game.ID]; ok {
+		t.Error("spectators should be freed with the game")
+	}
+
+	h.handleClientMessage(other, &Message{Type: "spectate", GameID: game.ID})
+	if errMsg := lastMessageOfType(drainMessages(other), "error"); errMsg == nil || errMsg.Error != translate(defaultLocale, ErrGameNotLive) {
+		t.Errorf("expected %s error, got %+v", ErrGameNotLive, errMsg)
+	}
+}
+
+// TestSpectatorCount tests that players are told how many spectators are
+// watching, both as they come and go and in the round messages
+func TestSpectatorCount(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	watchers := []*Client{newTestClient(h), newTestClient(h)}
+	game := startTestGame(t, h, c1, c2)
+
+	for i, watcher := range watchers {
+		h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+		if update := lastMessageOfType(drainMessages(c1), "spectator_count"); update == nil || update.SpectatorCount != i+1 {
+			t.Fatalf("after %d spectators joined: got %+v", i+1, update)
+		}
+	}
+	h.handleUnregister(watchers[0])
+	if update := lastMessageOfType(drainMessages(c2), "spectator_count"); update == nil || update.SpectatorCount != 1 {
+		t.Fatalf("after a spectator left: got %+v", update)
+	}
+
+	playRound(h, game, c1, c2, 2, 1)
+	msgs := drainMessages(c1)
+	for _, msgType := range []string{"round_result", "waiting_for_bids"} {
+		if msg := lastMessageOfType(msgs, msgType); msg == nil || msg.SpectatorCount != 1 {
+			t.Errorf("%s should report 1 spectator, got %+v", msgType, msg)
+		}
+	}
+}
+
+// TestSpectatorLimit tests that a game refuses spectators beyond the cap
+func TestSpectatorLimit(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.MaxSpectatorsPerGame = 2
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+
+	for i := 0; i < 2; i++ {
+		watcher := newTestClient(h)
+		h.handleClientMessage(watcher, &Message{Type: "spectate", GameID: game.ID})
+		if lastMessageOfType(drainMessages(watcher), "spectating") == nil {
+			t.Fatalf("spectator %d should be let in", i+1)
+		}
+	}
+
+	late := newTestClient(h)
+	drainMessages(late)
+	h.handleClientMessage(late, &Message{Type: "spectate", GameID: game.ID})
+	msgs := drainMessages(late)
+	if lastMessageOfType(msgs, "spectating") != nil {
+		t.Fatal("a spectator beyond the cap should be refused")
+	}
+	if errMsg := lastMessageOfType(msgs, "error"); errMsg == nil || errMsg.ErrorCode != ErrSpectatorLimit {
+		t.Errorf("expected %s, got %+v", ErrSpectatorLimit, errMsg)
+	}
+	if game.SpectatorCount != 2 {
+		t.Errorf("SpectatorCount: got %d, want 2", game.SpectatorCount)
+	}
+}
+
+// TestAutoSpectateOnEnd tests that a player who asked for it watches the
+// most-watched live game once theirs ends
+func TestAutoSpectateOnEnd(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	quiet := startTestGame(t, h, newTestClient(h), newTestClient(h))
+	busy := startTestGame(t, h, newTestClient(h), newTestClient(h))
+	h.handleClientMessage(newTestClient(h), &Message{Type: "spectate", GameID: busy.ID})
+
+	h.handleClientMessage(c1, &Message{Type: "set_auto_spectate_on_end", Enabled: true})
+	drainMessages(c1)
+	drainMessages(c2)
+	h.handleClientMessage(c2, &Message{Type: "resign", GameID: game.ID})
+
+	msgs := drainMessages(c1)
+	watching := lastMessageOfType(msgs, "now_watching")
+	if watching == nil || watching.GameID != busy.ID {
+		t.Fatalf("should be sent to the most-watched game %s, got %+v", busy.ID, watching)
+	}
+	if lastMessageOfType(msgs, "spectating") == nil || busy.SpectatorCount != 2 {
+		t.Errorf("should be attached as a spectator, count %d", busy.SpectatorCount)
+	}
+	if quiet.SpectatorCount != 0 {
+		t.Error("the other game should not get the spectator")
+	}
+	if lastMessageOfType(drainMessages(c2), "now_watching") != nil {
+		t.Error("a player without the preference should not be moved")
+	}
+}
+
+// TestServeLiveGames tests that GET /games lists the games open to spectators
+func TestServeLiveGames(t *testing.T) {
+	h := newHub(DefaultConfig())
+	h.config.RevealAckTimeout = 0
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	playRound(h, game, c1, c2, 3, 1)
+	h.handleClientMessage(c3, &Message{Type: "challenge_bot"})
+	go h.run(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/games", nil)
+	rec := httptest.NewRecorder()
+	serveLiveGames(h, rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d", rec.Code)
+	}
+	var games []LiveGame
+	if err := json.Unmarshal(rec.Body.Bytes(), &games); err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 {
+		t.Fatalf("only the game between two players should be listed, got %+v", games)
+	}
+	want := LiveGame{
+		GameID:     game.ID,
+		Player1:    c1.user.Username,
+		Player2:    c2.user.Username,
+		Round:      game.CurrentRound,
+		P1Position: game.Player1Pos,
+		P2Position: game.Player2Pos,
+	}
+	if games[0] != want {
+		t.Errorf("listing: got %+v, want %+v", games[0], want)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS games (
+	id               TEXT PRIMARY KEY,
+	player1_id       TEXT NOT NULL,
+	player1_username TEXT NOT NULL,
+	player2_id       TEXT NOT NULL,
+	player2_username TEXT NOT NULL,
+	winner           INTEGER NOT NULL,
+	reason_code      TEXT NOT NULL,
+	start_time       INTEGER NOT NULL,
+	end_time         INTEGER NOT NULL,
+	record           TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS games_end_time ON games (end_time);
+CREATE TABLE IF NOT EXISTS challenge_log (
+	challenge_id  TEXT NOT NULL,
+	event         TEXT NOT NULL,
+	from_user_id  TEXT NOT NULL,
+	from_username TEXT NOT NULL,
+	to_user_id    TEXT NOT NULL,
+	to_username   TEXT NOT NULL,
+	time          INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS profiles (
+	id       TEXT PRIMARY KEY,
+	username TEXT NOT NULL DEFAULT '',
+	rating   INTEGER NOT NULL,
+	games    INTEGER NOT NULL,
+	wins     INTEGER NOT NULL DEFAULT 0,
+	losses   INTEGER NOT NULL DEFAULT 0,
+	draws    INTEGER NOT NULL DEFAULT 0,
+	ladder_rung INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS profiles_rating ON profiles (rating);
+`
+
+// sqliteMigrations add columns to tables created by an older schema. Each
+// fails harmlessly with "duplicate column" on a database that has it.
+var sqliteMigrations = []string{
+	`ALTER TABLE profiles ADD COLUMN ladder_rung INTEGER NOT NULL DEFAULT 0`,
+}
+
+// sqliteWriteQueue is how many writes may wait for the writer goroutine
+// before SaveGame and LogChallenge block
+const sqliteWriteQueue = 256
+
+// sqliteStore keeps finished games, the challenge log and profiles in a
+// SQLite database so they survive restarts. Games and challenge log entries
+// are written by a single background goroutine, so the hub loop doesn't wait
+// on the disk for them; games still being written are served from memory.
+// Profiles are the exception: LoadProfile and SaveProfile query the database
+// directly, so the hub loop does block on them when a profile user connects,
+// is renamed or finishes a rated game.
+type sqliteStore struct {
+	db      *sql.DB
+	writes  chan func() error
+	done    chan struct{}
+	mu      sync.Mutex
+	pending map[string]*GameRecord // Saved games not yet written
+}
+
+// openSQLiteStore opens (creating if needed) the database at path
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, migration := range sqliteMigrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, err
+		}
+	}
+	s := &sqliteStore{
+		db:      db,
+		writes:  make(chan func() error, sqliteWriteQueue),
+		done:    make(chan struct{}),
+		pending: make(map[string]*GameRecord),
+	}
+	go s.writer()
+	return s, nil
+}
+
+// writer runs queued writes in order until Close
+func (s *sqliteStore) writer() {
+	defer close(s.done)
+	for write := range s.writes {
+		if err := write(); err != nil {
+			slog.Error("sqlite_write_failed", "error", err)
+		}
+	}
+}
+
+// Close waits for queued writes to finish and closes the database
+func (s *sqliteStore) Close() error {
+	close(s.writes)
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *sqliteStore) SaveGame(record *GameRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.pending[record.ID] = record
+	s.mu.Unlock()
+
+	s.writes <- func() error {
+		defer func() {
+			s.mu.Lock()
+			if s.pending[record.ID] == record {
+				delete(s.pending, record.ID)
+			}
+			s.mu.Unlock()
+		}()
+		_, err := s.db.Exec(`INSERT OR REPLACE INTO games
+			(id, player1_id, player1_username, player2_id, player2_username, winner, reason_code, start_time, end_time, record)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			record.ID, record.Player1ID, record.Player1Username, record.Player2ID, record.Player2Username,
+			record.Winner, record.ReasonCode, record.StartTime.UnixMilli(), record.EndTime.UnixMilli(), string(data))
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteStore) LoadGame(id string) (*GameRecord, error) {
+	s.mu.Lock()
+	record, queued := s.pending[id]
+	s.mu.Unlock()
+	if queued {
+		return record, nil
+	}
+
+	var data string
+	err := s.db.QueryRow(`SELECT record FROM games WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record = &GameRecord{}
+	if err := json.Unmarshal([]byte(data), record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *sqliteStore) LogChallenge(entry *ChallengeLogEntry) error {
+	s.writes <- func() error {
+		_, err := s.db.Exec(`INSERT INTO challenge_log
+			(challenge_id, event, from_user_id, from_username, to_user_id, to_username, time)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			entry.ChallengeID, entry.Event, entry.FromUserID, entry.FromUsername,
+			entry.ToUserID, entry.ToUsername, entry.Time.UnixMilli())
+		return err
+	}
+	return nil
+}
+
+// ChallengeLog returns the entries written so far; entries still queued
+// show up once the writer gets to them
+func (s *sqliteStore) ChallengeLog() ([]*ChallengeLogEntry, error) {
+	rows, err := s.db.Query(`SELECT challenge_id, event, from_user_id, from_username, to_user_id, to_username, time
+		FROM challenge_log ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*ChallengeLogEntry{}
+	for rows.Next() {
+		entry := &ChallengeLogEntry{}
+		var millis int64
+		if err := rows.Scan(&entry.ChallengeID, &entry.Event, &entry.FromUserID, &entry.FromUsername,
+			&entry.ToUserID, &entry.ToUsername, &millis); err != nil {
+			return nil, err
+		}
+		entry.Time = time.UnixMilli(millis)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqliteStore) LoadProfile(id string) (*Profile, error) {
+	profile := &Profile{ID: id}
+	err := s.db.QueryRow(`SELECT username, rating, games, wins, losses, draws, ladder_rung FROM profiles WHERE id = ?`, id).
+		Scan(&profile.Username, &profile.Rating, &profile.Games, &profile.Wins, &profile.Losses, &profile.Draws, &profile.LadderRung)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProfileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// SaveProfile writes synchronously: a rating is read back as soon as its
+// owner logs in again
+func (s *sqliteStore) SaveProfile(profile *Profile) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO profiles (id, username, rating, games, wins, losses, draws, ladder_rung)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		profile.ID, profile.Username, profile.Rating, profile.Games, profile.Wins, profile.Losses, profile.Draws, profile.LadderRung)
+	return err
+}
+
+// leaderboardOrderBy mirrors rankProfiles' ordering in SQL
+var leaderboardOrderBy = map[string]string{
+	LeaderboardByRating:  "rating DESC, id",
+	LeaderboardByWins:    "wins DESC, rating DESC, id",
+	LeaderboardByWinRate: "CAST(wins AS REAL) / games DESC, rating DESC, id",
+}
+
+func (s *sqliteStore) Leaderboard(order string, limit int) ([]Profile, error) {
+	orderBy, ok := leaderboardOrderBy[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard order %q", order)
+	}
+	minGames := 1
+	if order == LeaderboardByWinRate {
+		minGames = MIN_WIN_RATE_GAMES
+	}
+	rows, err := s.db.Query(`SELECT id, username, rating, games, wins, losses, draws, ladder_rung FROM profiles
+		WHERE games >= ? ORDER BY `+orderBy+` LIMIT ?`, minGames, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []Profile{}
+	for rows.Next() {
+		var p Profile
+		if err := rows.Scan(&p.ID, &p.Username, &p.Rating, &p.Games, &p.Wins, &p.Losses, &p.Draws, &p.LadderRung); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSQLiteStorePersists tests that games, the challenge log and profiles survive reopening the database
+func TestSQLiteStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "games.db")
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := time.UnixMilli(time.Now().UnixMilli()).UTC()
+	record := &GameRecord{
+		ID:              "game-a",
+		Player1ID:       "u1",
+		Player1Username: "alice",
+		Player2ID:       "u2",
+		Player2Username: "bob",
+		Winner:          1,
+		ReasonCode:      ReasonReachedFinalStep,
+		History:         []RoundHistory{{Turn: 1, P1Bid: 3, P2Bid: 2, P1NewPos: 1, Result: "P1_WINS_ROUND"}},
+		StartTime:       ended.Add(-time.Minute),
+		EndTime:         ended,
+	}
+	if err := store.SaveGame(record); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := store.LoadGame("game-a"); err != nil || loaded.Winner != 1 {
+		t.Fatalf("a game should be readable while its write is queued, got %+v, %v", loaded, err)
+	}
+	store.LogChallenge(&ChallengeLogEntry{ChallengeID: "c1", Event: ChallengeSent, FromUserID: "u1", Time: ended})
+	if err := store.SaveProfile(&Profile{ID: "p1", Rating: 1516, Games: 1, LadderRung: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	loaded, err := store.LoadGame("game-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Player2Username != "bob" || len(loaded.History) != 1 || loaded.History[0].P1Bid != 3 || !loaded.EndTime.Equal(ended) {
+		t.Errorf("stored game: got %+v", loaded)
+	}
+	if _, err := store.LoadGame("missing"); err != ErrGameNotFound {
+		t.Errorf("missing game: got %v, want ErrGameNotFound", err)
+	}
+	if entries, err := store.ChallengeLog(); err != nil || len(entries) != 1 || entries[0].ChallengeID != "c1" {
+		t.Errorf("challenge log: got %+v, %v", entries, err)
+	}
+	if profile, err := store.LoadProfile("p1"); err != nil || profile.Rating != 1516 || profile.LadderRung != 2 {
+		t.Errorf("profile: got %+v, %v", profile, err)
+	}
+	if _, err := store.LoadProfile("p2"); err != ErrProfileNotFound {
+		t.Errorf("missing profile: got %v, want ErrProfileNotFound", err)
+	}
+	for i := range leaderboardProfiles {
+		store.SaveProfile(&leaderboardProfiles[i])
+	}
+	for _, order := range []string{LeaderboardByRating, LeaderboardByWins, LeaderboardByWinRate} {
+		got, err := store.Leaderboard(order, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := rankProfiles(append([]Profile{{ID: "p1", Rating: 1516, Games: 1, LadderRung: 2}}, leaderboardProfiles...), order, 3)
+		if len(got) != len(want) {
+			t.Fatalf("%s leaderboard: got %+v, want %+v", order, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s leaderboard: got %+v, want %+v", order, got, want)
+				break
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	serveGames(store, rec, httptest.NewRequest(http.MethodGet, "/games/game-a", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+	var served GameRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.ID != "game-a" || len(served.History) != 1 {
+		t.Errorf("GET /games/{id}: got %+v", served)
+	}
+}
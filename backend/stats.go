@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Stats is the live server summary served at GET /stats
+type Stats struct {
+	Clients       int         `json:"clients"`
+	Games         int         `json:"games"` // Games in progress
+	Challenges    int         `json:"challenges"`
+	InProgress    []GameStats `json:"inProgress"`
+	UptimeSeconds int64       `json:"uptimeSeconds"`
+}
+
+// GameStats describes one game in progress without naming its players
+type GameStats struct {
+	Player1 string `json:"player1"`
+	Player2 string `json:"player2"`
+	Round   int    `json:"round"`
+	Status  string `json:"status"`
+}
+
+// anonymizedName stands in for a username on public pages. It is stable for
+// the user so a status page can follow a player without identifying them.
+func anonymizedName(user *User) string {
+	return fmt.Sprintf("player-%06x", hashUserID(user.ID)&0xffffff)
+}
+
+// collectStats summarizes the hub state. It must run on the hub goroutine.
+func (h *Hub) collectStats() Stats {
+	stats := Stats{
+		Clients:    len(h.clients),
+		Challenges: len(h.challenges),
+		InProgress: []GameStats{},
+	}
+	for _, game := range h.games {
+		if game.GameOver {
+			continue
+		}
+		stats.InProgress = append(stats.InProgress, GameStats{
+			Player1: anonymizedName(game.Player1),
+			Player2: anonymizedName(game.Player2),
+			Round:   game.CurrentRound,
+			Status:  game.Status,
+		})
+	}
+	stats.Games = len(stats.InProgress)
+	sort.Slice(stats.InProgress, func(i, j int) bool {
+		return stats.InProgress[i].Round > stats.InProgress[j].Round
+	})
+	return stats
+}
+
+// serveStats answers GET /stats with a snapshot taken by the hub loop, so
+// the handler never reads hub state concurrently with run()
+func serveStats(hub *Hub, started time.Time, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply := make(chan Stats, 1)
+	select {
+	case hub.statsRequests <- reply:
+	case <-hub.stopped:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case stats := <-reply:
+		stats.UptimeSeconds = int64(time.Since(started) / time.Second)
+		writeJSON(w, http.StatusOK, stats)
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestServeStats tests the /stats snapshot taken through the hub loop
+func TestServeStats(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	c3 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	h.handleClientMessage(c3, &Message{Type: "open_challenge"})
+	go h.run(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	rec := httptest.NewRecorder()
+	serveStats(h, time.Now().Add(-time.Minute), rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d", rec.Code)
+	}
+	body := rec.Body.String()
+
+	var stats Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Clients != 3 || stats.Games != 1 || stats.Challenges != 1 {
+		t.Errorf("counts: got clients=%d games=%d challenges=%d, want 3/1/1", stats.Clients, stats.Games, stats.Challenges)
+	}
+	if stats.UptimeSeconds < 60 {
+		t.Errorf("uptime: got %ds, want at least 60", stats.UptimeSeconds)
+	}
+	if len(stats.InProgress) != 1 || stats.InProgress[0].Round != game.CurrentRound {
+		t.Fatalf("in-progress games: got %+v", stats.InProgress)
+	}
+	if strings.Contains(body, game.Player1.Username) || strings.Contains(body, game.Player2.Username) {
+		t.Error("stats must not reveal usernames")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrGameNotFound is returned when a stored game does not exist
+var ErrGameNotFound = errors.New("game not found")
+
+// ErrProfileNotFound is returned when no profile has the given ID
+var ErrProfileNotFound = errors.New("profile not found")
+
+// GameRecord is a finished game as kept in the store
+type GameRecord struct {
+	ID              string         `json:"id"`
+	Player1ID       string         `json:"player1Id"`
+	Player1Username string         `json:"player1Username"`
+	Player2ID       string         `json:"player2Id"`
+	Player2Username string         `json:"player2Username"`
+	Winner          int            `json:"winner"`
+	Reason          string         `json:"reason"`
+	ReasonCode      string         `json:"reasonCode"`
+	DominanceScore  int            `json:"dominanceScore"`
+	ResultHash      string         `json:"resultHash"`
+	History         []RoundHistory `json:"history"`
+	Settings        GameSettings   `json:"settings"`
+	StartTime       time.Time      `json:"startTime"`
+	EndTime         time.Time      `json:"endTime"`
+}
+
+// Challenge log events
+const (
+	ChallengeSent      = "SENT"
+	ChallengeAccepted  = "ACCEPTED"
+	ChallengeDeclined  = "DECLINED"
+	ChallengeExpired   = "EXPIRED"
+	ChallengeCancelled = "CANCELLED" // Withdrawn by the challenger, or because a party disconnected
+)
+
+// ChallengeLogEntry records one step in a challenge's life, kept for abuse detection
+type ChallengeLogEntry struct {
+	ChallengeID  string    `json:"challengeId"`
+	Event        string    `json:"event"`
+	FromUserID   string    `json:"fromUserId"`
+	FromUsername string    `json:"fromUsername"`
+	ToUserID     string    `json:"toUserId"`
+	ToUsername   string    `json:"toUsername"`
+	Time         time.Time `json:"time"`
+}
+
+// Profile is the persistent identity behind a login token. The ID is derived
+// from the token (see profileID) so the token itself is never stored.
+type Profile struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"` // Name last used, shown on the leaderboard
+	Rating     int    `json:"rating"`
+	Games      int    `json:"games"` // Rated games played
+	Wins       int    `json:"wins"`
+	Losses     int    `json:"losses"`
+	Draws      int    `json:"draws"`
+	LadderRung int    `json:"ladderRung"` // Practice ladder rung reached, from 0
+}
+
+// GameStore persists finished games, the challenge log and profiles. Implementations
+// must be safe for concurrent use since HTTP handlers read from it outside
+// the hub loop.
+type GameStore interface {
+	SaveGame(record *GameRecord) error
+	LoadGame(id string) (*GameRecord, error)
+	LogChallenge(entry *ChallengeLogEntry) error
+	ChallengeLog() ([]*ChallengeLogEntry, error)
+	LoadProfile(id string) (*Profile, error)
+	SaveProfile(profile *Profile) error
+	// Leaderboard returns up to limit profiles with rated games, ranked by
+	// the given order (see rankProfiles)
+	Leaderboard(order string, limit int) ([]Profile, error)
+}
+
+// newGameRecord snapshots a finished game
+func newGameRecord(game *Game) *GameRecord {
+	history := make([]RoundHistory, len(game.History))
+	copy(history, game.History)
+	return &GameRecord{
+		ID:              game.ID,
+		Player1ID:       game.Player1.ID,
+		Player1Username: game.Player1.Username,
+		Player2ID:       game.Player2.ID,
+		Player2Username: game.Player2.Username,
+		Winner:          game.Winner,
+		Reason:          translate(defaultLocale, game.Reason),
+		ReasonCode:      game.Reason,
+		DominanceScore:  game.DominanceScore,
+		ResultHash:      game.ResultHash,
+		History:         history,
+		Settings:        game.Settings,
+		StartTime:       game.StartTime,
+		EndTime:         game.EndTime,
+	}
+}
+
+// memoryStore keeps finished games in memory for the life of the process
+type memoryStore struct {
+	mu         sync.RWMutex
+	games      map[string]*GameRecord
+	challenges []*ChallengeLogEntry
+	profiles   map[string]Profile
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{games: make(map[string]*GameRecord), profiles: make(map[string]Profile)}
+}
+
+func (s *memoryStore) SaveGame(record *GameRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.games[record.ID] = record
+	return nil
+}
+
+func (s *memoryStore) LoadGame(id string) (*GameRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, exists := s.games[id]
+	if !exists {
+		return nil, ErrGameNotFound
+	}
+	return record, nil
+}
+
+func (s *memoryStore) LogChallenge(entry *ChallengeLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenges = append(s.challenges, entry)
+	return nil
+}
+
+func (s *memoryStore) ChallengeLog() ([]*ChallengeLogEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]*ChallengeLogEntry, len(s.challenges))
+	copy(entries, s.challenges)
+	return entries, nil
+}
+
+func (s *memoryStore) LoadProfile(id string) (*Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	profile, exists := s.profiles[id]
+	if !exists {
+		return nil, ErrProfileNotFound
+	}
+	return &profile, nil
+}
+
+func (s *memoryStore) SaveProfile(profile *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[profile.ID] = *profile
+	return nil
+}
+
+func (s *memoryStore) Leaderboard(order string, limit int) ([]Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	profiles := make([]Profile, 0, len(s.profiles))
+	for _, profile := range s.profiles {
+		profiles = append(profiles, profile)
+	}
+	return rankProfiles(profiles, order, limit), nil
+}
+
+// ChallengeAbuse summarizes a challenger whose challenges are mostly declined
+type ChallengeAbuse struct {
+	UserID      string  `json:"userId"`
+	Username    string  `json:"username"`
+	Sent        int     `json:"sent"`
+	Declined    int     `json:"declined"`
+	DeclineRate float64 `json:"declineRate"`
+	Targets     int     `json:"targets"` // Distinct users challenged
+}
+
+// findChallengeAbuse lists challengers who sent at least minSent challenges
+// with a decline rate of at least minRate, highest rate first
+func findChallengeAbuse(entries []*ChallengeLogEntry, minSent int, minRate float64) []ChallengeAbuse {
+	byUser := make(map[string]*ChallengeAbuse)
+	targets := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		stats, exists := byUser[entry.FromUserID]
+		if !exists {
+			stats = &ChallengeAbuse{UserID: entry.FromUserID}
+			byUser[entry.FromUserID] = stats
+			targets[entry.FromUserID] = make(map[string]bool)
+		}
+		stats.Username = entry.FromUsername
+		switch entry.Event {
+		case ChallengeSent:
+			stats.Sent++
+			if entry.ToUserID != "" { // open challenges have no target
+				targets[entry.FromUserID][entry.ToUserID] = true
+			}
+		case ChallengeDeclined:
+			stats.Declined++
+		}
+	}
+
+	report := []ChallengeAbuse{}
+	for id, stats := range byUser {
+		if stats.Sent == 0 || stats.Sent < minSent {
+			continue
+		}
+		stats.DeclineRate = float64(stats.Declined) / float64(stats.Sent)
+		stats.Targets = len(targets[id])
+		if stats.DeclineRate >= minRate {
+			report = append(report, *stats)
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].DeclineRate != report[j].DeclineRate {
+			return report[i].DeclineRate > report[j].DeclineRate
+		}
+		return report[i].Sent > report[j].Sent
+	})
+	return report
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("invalid session token")
+	ErrTokenExpired = errors.New("session token expired")
+)
+
+// newTokenSecret returns a random key for signing session tokens
+func newTokenSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("token secret: " + err.Error())
+	}
+	return secret
+}
+
+// issueToken returns a session token for the user that expires at the given
+// time, in the form base64(userID|expiry).base64(hmac)
+func issueToken(secret []byte, userID string, expires time.Time) string {
+	payload := userID + "|" + strconv.FormatInt(expires.Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signToken(secret, encoded))
+}
+
+// verifyToken checks the signature and expiry of a session token and
+// returns the user ID it was issued for
+func verifyToken(secret []byte, token string, now time.Time) (string, error) {
+	encoded, sig, found := strings.Cut(token, ".")
+	if !found {
+		return "", ErrTokenInvalid
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, signToken(secret, encoded)) {
+		return "", ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	userID, expiry, found := strings.Cut(string(payload), "|")
+	if !found {
+		return "", ErrTokenInvalid
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return "", ErrTokenExpired
+	}
+	return userID, nil
+}
+
+func signToken(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSessionTokens tests token verification for valid, expired and tampered tokens
+func TestSessionTokens(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+	token := issueToken(secret, "user-1", now.Add(time.Minute))
+
+	t.Run("Valid", func(t *testing.T) {
+		userID, err := verifyToken(secret, token, now)
+		if err != nil || userID != "user-1" {
+			t.Errorf("got %q, %v", userID, err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		if _, err := verifyToken(secret, token, now.Add(2*time.Minute)); err != ErrTokenExpired {
+			t.Errorf("got %v, want ErrTokenExpired", err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		// Swap in another user's payload but keep the original signature
+		_, sig, _ := strings.Cut(token, ".")
+		other, _, _ := strings.Cut(issueToken(secret, "user-2", now.Add(time.Minute)), ".")
+		if _, err := verifyToken(secret, other+"."+sig, now); err != ErrTokenInvalid {
+			t.Errorf("got %v, want ErrTokenInvalid", err)
+		}
+
+		forged := issueToken([]byte("other-secret"), "user-1", now.Add(time.Minute))
+		if _, err := verifyToken(secret, forged, now); err != ErrTokenInvalid {
+			t.Errorf("token signed with another secret: got %v, want ErrTokenInvalid", err)
+		}
+	})
+
+	t.Run("Welcome carries a fresh token", func(t *testing.T) {
+		hub := newHub(DefaultConfig())
+		client := newTestClient(hub)
+		welcome := lastMessageOfType(drainMessages(client), "welcome")
+		if welcome == nil || welcome.Token == "" {
+			t.Fatal("welcome should carry a session token")
+		}
+		userID, err := verifyToken(hub.config.TokenSecret, welcome.Token, time.Now())
+		if err != nil || userID != client.user.ID {
+			t.Errorf("got %q, %v", userID, err)
+		}
+	})
+}
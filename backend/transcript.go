@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A transcript is a stored game as plain text, for sharing and analysis.
+// It starts with a version line and a header, then has one line per round
+// with both bids, the result and the resulting positions:
+//
+//	quevadis-replay 1
+//	game 3f2a...
+//	player1 u-1 "BraveBadger"
+//	player2 u-2 "CalmOtter"
+//	settings mode=all_pay maxSteps=0 initialBudget=0 roundWinTarget=0 bestOf=0 hideBalance=false private=false
+//	start 2024-05-01T10:00:00Z
+//	end 2024-05-01T10:03:12Z
+//	round 1 bids=5,3 result=P1_WINS_ROUND positions=1,0 first=2 timedOut=0
+//	result winner=1 dominance=83 hash=9c1e...
+//	reason REACHED_FINAL_STEP "Reached final step"
+//
+// Settings are written as stored, so 0 and an empty mode mean the defaults.
+// parseTranscript reads it back into the same record.
+const transcriptVersion = "quevadis-replay 1"
+
+// formatTranscript writes the game as a transcript
+func formatTranscript(record *GameRecord) string {
+	var b strings.Builder
+	s := record.Settings
+	fmt.Fprintln(&b, transcriptVersion)
+	fmt.Fprintf(&b, "game %s\n", record.ID)
+	fmt.Fprintf(&b, "player1 %s %s\n", record.Player1ID, strconv.Quote(record.Player1Username))
+	fmt.Fprintf(&b, "player2 %s %s\n", record.Player2ID, strconv.Quote(record.Player2Username))
+	fmt.Fprintf(&b, "settings mode=%s maxSteps=%d initialBudget=%d roundWinTarget=%d bestOf=%d hideBalance=%t private=%t\n",
+		s.GameMode, s.MaxSteps, s.InitialBudget, s.RoundWinTarget, s.BestOf, s.HideBalance, s.Private)
+	fmt.Fprintf(&b, "start %s\n", record.StartTime.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "end %s\n", record.EndTime.UTC().Format(time.RFC3339Nano))
+	for _, round := range record.History {
+		fmt.Fprintf(&b, "round %d bids=%d,%d result=%s positions=%d,%d first=%d timedOut=%d\n",
+			round.Turn, round.P1Bid, round.P2Bid, round.Result, round.P1NewPos, round.P2NewPos, round.FirstBidder, round.TimedOut)
+	}
+	fmt.Fprintf(&b, "result winner=%d dominance=%d hash=%s\n", record.Winner, record.DominanceScore, record.ResultHash)
+	fmt.Fprintf(&b, "reason %s %s\n", record.ReasonCode, strconv.Quote(record.Reason))
+	return b.String()
+}
+
+// parseTranscript reads a transcript written by formatTranscript
+func parseTranscript(text string) (*GameRecord, error) {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	if !scanner.Scan() || scanner.Text() != transcriptVersion {
+		return nil, fmt.Errorf("not a %q transcript", transcriptVersion)
+	}
+
+	record := &GameRecord{History: []RoundHistory{}}
+	for line := 2; scanner.Scan(); line++ {
+		keyword, rest, _ := strings.Cut(scanner.Text(), " ")
+		var err error
+		switch keyword {
+		case "game":
+			record.ID = rest
+		case "player1":
+			record.Player1ID, record.Player1Username, err = parseTranscriptName(rest)
+		case "player2":
+			record.Player2ID, record.Player2Username, err = parseTranscriptName(rest)
+		case "settings":
+			err = parseTranscriptFields(rest, map[string]interface{}{
+				"mode":           &record.Settings.GameMode,
+				"maxSteps":       &record.Settings.MaxSteps,
+				"initialBudget":  &record.Settings.InitialBudget,
+				"roundWinTarget": &record.Settings.RoundWinTarget,
+				"bestOf":         &record.Settings.BestOf,
+				"hideBalance":    &record.Settings.HideBalance,
+				"private":        &record.Settings.Private,
+			})
+		case "start":
+			record.StartTime, err = time.Parse(time.RFC3339Nano, rest)
+		case "end":
+			record.EndTime, err = time.Parse(time.RFC3339Nano, rest)
+		case "round":
+			var round RoundHistory
+			turn, fields, _ := strings.Cut(rest, " ")
+			if round.Turn, err = strconv.Atoi(turn); err == nil {
+				err = parseTranscriptFields(fields, map[string]interface{}{
+					"bids":      &[2]*int{&round.P1Bid, &round.P2Bid},
+					"result":    &round.Result,
+					"positions": &[2]*int{&round.P1NewPos, &round.P2NewPos},
+					"first":     &round.FirstBidder,
+					"timedOut":  &round.TimedOut,
+				})
+			}
+			record.History = append(record.History, round)
+		case "result":
+			err = parseTranscriptFields(rest, map[string]interface{}{
+				"winner":    &record.Winner,
+				"dominance": &record.DominanceScore,
+				"hash":      &record.ResultHash,
+			})
+		case "reason":
+			var code string
+			code, record.Reason, err = parseTranscriptName(rest)
+			record.ReasonCode = code
+		default:
+			err = fmt.Errorf("unknown line %q", keyword)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return record, scanner.Err()
+}
+
+// parseTranscriptName splits "<id> <quoted text>"
+func parseTranscriptName(s string) (string, string, error) {
+	id, quoted, _ := strings.Cut(s, " ")
+	text, err := strconv.Unquote(quoted)
+	return id, text, err
+}
+
+// parseTranscriptFields reads space-separated key=value pairs into the
+// pointers in dst. Every key must be present exactly once.
+func parseTranscriptFields(s string, dst map[string]interface{}) error {
+	seen := make(map[string]bool, len(dst))
+	for _, field := range strings.Fields(s) {
+		key, value, _ := strings.Cut(field, "=")
+		target, ok := dst[key]
+		if !ok || seen[key] {
+			return fmt.Errorf("unexpected field %q", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch p := target.(type) {
+		case *string:
+			*p = value
+		case *int:
+			*p, err = strconv.Atoi(value)
+		case *bool:
+			*p, err = strconv.ParseBool(value)
+		case *[2]*int:
+			a, b, _ := strings.Cut(value, ",")
+			if *p[0], err = strconv.Atoi(a); err == nil {
+				*p[1], err = strconv.Atoi(b)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	if len(seen) != len(dst) {
+		return fmt.Errorf("missing fields in %q", s)
+	}
+	return nil
+}
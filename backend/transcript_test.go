@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTranscriptFormat pins the transcript layout so shared replays stay readable
+func TestTranscriptFormat(t *testing.T) {
+	record := &GameRecord{
+		ID:              "game-1",
+		Player1ID:       "u-1",
+		Player1Username: "Brave Badger",
+		Player2ID:       "u-2",
+		Player2Username: `Calm "Otter"`,
+		Winner:          1,
+		Reason:          "Reached final step",
+		ReasonCode:      ReasonReachedFinalStep,
+		DominanceScore:  83,
+		ResultHash:      "abc",
+		Settings:        GameSettings{GameMode: GameModeSecondPrice, MaxSteps: 2},
+		StartTime:       time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		EndTime:         time.Date(2024, 5, 1, 10, 3, 12, 500, time.UTC),
+		History: []RoundHistory{
+			{Turn: 1, P1Bid: 5, P2Bid: 3, Result: "P1_WINS_ROUND", P1NewPos: 1, FirstBidder: 2},
+			{Turn: 2, P1Bid: 4, P2Bid: 0, Result: "P1_WINS_ROUND", P1NewPos: 2, FirstBidder: 1, TimedOut: 2},
+		},
+	}
+	want := `quevadis-replay 1
+game game-1
+player1 u-1 "Brave Badger"
+player2 u-2 "Calm \"Otter\""
+settings mode=second_price maxSteps=2 initialBudget=0 roundWinTarget=0 bestOf=0 hideBalance=false private=false
+start 2024-05-01T10:00:00Z
+end 2024-05-01T10:03:12.0000005Z
+round 1 bids=5,3 result=P1_WINS_ROUND positions=1,0 first=2 timedOut=0
+round 2 bids=4,0 result=P1_WINS_ROUND positions=2,0 first=1 timedOut=2
+result winner=1 dominance=83 hash=abc
+reason REACHED_FINAL_STEP "Reached final step"
+`
+	text := formatTranscript(record)
+	if text != want {
+		t.Fatalf("transcript:\n%s\nwant:\n%s", text, want)
+	}
+	parsed, err := parseTranscript(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, record) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", parsed, record)
+	}
+}
+
+// TestTranscriptRoundTrip tests that a played game served by /games/{id}/replay
+// parses back into the stored record
+func TestTranscriptRoundTrip(t *testing.T) {
+	h := newHub(DefaultConfig())
+	c1 := newTestClient(h)
+	c2 := newTestClient(h)
+	game := startTestGame(t, h, c1, c2)
+	for !game.GameOver {
+		playRound(h, game, c1, c2, 3, 1)
+	}
+	stored, err := h.store.LoadGame(game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/games/"+game.ID+"/replay", nil)
+	rec := httptest.NewRecorder()
+	serveGames(h.store, rec, req)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	parsed, err := parseTranscript(rec.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.History, stored.History) || parsed.Winner != stored.Winner ||
+		parsed.ResultHash != stored.ResultHash || !parsed.EndTime.Equal(stored.EndTime) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", parsed, stored)
+	}
+	if formatTranscript(parsed) != rec.Body.String() {
+		t.Error("re-exporting a parsed transcript should give the same text")
+	}
+}
+
+// TestParseTranscriptErrors tests that damaged transcripts are rejected
+func TestParseTranscriptErrors(t *testing.T) {
+	valid := formatTranscript(&GameRecord{ID: "g", History: []RoundHistory{{Turn: 1, P1Bid: 1, Result: "P1_WINS_ROUND", P1NewPos: 1}}})
+	for name, text := range map[string]string{
+		"no version":     strings.SplitN(valid, "\n", 2)[1],
+		"unknown line":   valid + "comment hello\n",
+		"bad bid":        strings.Replace(valid, "bids=1,0", "bids=one,0", 1),
+		"missing field":  strings.Replace(valid, " first=0", "", 1),
+		"repeated field": strings.Replace(valid, "winner=0", "winner=0 winner=1", 1),
+		"bad quoting":    strings.Replace(valid, `player1  ""`, `player1  "`, 1),
+	} {
+		if _, err := parseTranscript(text); err == nil {
+			t.Errorf("%s: should be rejected", name)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"math/rand"
 	"time"
+
+	"quevadis/gameengine"
 )
 
 // Game Constants
@@ -9,6 +12,27 @@ const (
 	MAX_STEPS       = 3  // Target position to win (positions 0, 1, 2, 3)
 	INITIAL_BUDGET  = 20 // Starting points/stones
 	CHALLENGE_EXPIRY = 60 // seconds
+	MAX_NOTE_LENGTH  = 140 // characters allowed in a challenge note
+	MAX_CHAT_LENGTH  = 500 // characters allowed in a chat message
+	MAX_ROUND_WIN_TARGET = 20 // upper bound for the round-win victory mode
+	MIN_GAME_STEPS       = 2   // bounds for a challenge's maxSteps
+	MAX_GAME_STEPS       = 10
+	MIN_INITIAL_BUDGET   = 5   // bounds for a challenge's initialBudget
+	MAX_INITIAL_BUDGET   = 200
+	MAX_BEST_OF          = 7   // longest series a challenge may ask for
+	MAX_LOBBY_NAME_LENGTH = 32 // characters allowed in a lobby name
+	DEFAULT_LOBBY        = "main" // lobby every user starts in
+	INITIAL_RATING       = 1500   // Elo rating of a new profile
+	MIN_LOGIN_TOKEN_LENGTH = 16   // shortest login token accepted for a profile
+	ROOM_CODE_LENGTH     = 6      // characters in a private room join code
+)
+
+// Protocol versions. Bump ProtocolVersion whenever Message changes in a way
+// older clients can't ignore, and raise MinProtocolVersion once the server
+// stops supporting them.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
 )
 
 // Message types sent between client and server
@@ -16,7 +40,10 @@ type Message struct {
 	Type             string      `json:"type"`
 	UserID           string      `json:"userId,omitempty"`
 	Username         string      `json:"username,omitempty"`
+	Error            string      `json:"error,omitempty"`     // Localized text in error messages
+	ErrorCode        string      `json:"errorCode,omitempty"` // Stable code for Error, see i18n.go
 	TargetUserID     string      `json:"targetUserId,omitempty"`
+	TargetUsername   string      `json:"targetUsername,omitempty"` // Alternative to TargetUserID in challenge
 	ChallengeID      string      `json:"challengeId,omitempty"`
 	GameID           string      `json:"gameId,omitempty"`
 	FromUserID       string      `json:"fromUserId,omitempty"`
@@ -25,6 +52,7 @@ type Message struct {
 	OpponentUsername string      `json:"opponentUsername,omitempty"`
 	YourPlayer       int         `json:"yourPlayer,omitempty"`
 	Bid              int         `json:"bid,omitempty"`
+	BidPercent       *int        `json:"bidPercent,omitempty"` // Alternative to Bid: 0-100 of the current balance
 	Users            []UserInfo  `json:"users,omitempty"`
 	// Game state fields
 	Turn             int         `json:"turn,omitempty"`
@@ -34,15 +62,68 @@ type Message struct {
 	P2Bid            int         `json:"p2Bid,omitempty"`
 	P1Position       int         `json:"p1Position,omitempty"`
 	P2Position       int         `json:"p2Position,omitempty"`
+	YourBalance      int         `json:"yourBalance,omitempty"`      // P1/P2 fields from the recipient's side, players only
+	OpponentBalance  int         `json:"opponentBalance,omitempty"`  // Left out with HideBalance
+	YourPosition     int         `json:"yourPosition,omitempty"`
+	OpponentPosition int         `json:"opponentPosition,omitempty"`
+	SpectatorCount   int         `json:"spectatorCount,omitempty"` // Clients watching the game
 	Winner           int         `json:"winner,omitempty"`
-	Reason           string      `json:"reason,omitempty"`
+	Reason           string      `json:"reason,omitempty"`     // Localized human text
+	ReasonCode       string      `json:"reasonCode,omitempty"` // Stable code for Reason
 	Result           string      `json:"result,omitempty"` // "P1_WINS", "P2_WINS", "DRAW"
+	Note             string      `json:"note,omitempty"`   // Challenger's greeting
+	ExpirySeconds    int         `json:"expirySeconds,omitempty"` // How long a challenge stays open; 0 in a challenge uses the server default
+	Dominance        int         `json:"dominance,omitempty"` // 0-100 win decisiveness in game_end
+	Event            string      `json:"event,omitempty"`     // Event card in play this round
+	Enabled          bool        `json:"enabled,omitempty"`   // Toggle for preference messages
+	Position         int         `json:"position,omitempty"`  // Waitlist position
+	QueueSize        int         `json:"queueSize,omitempty"` // Players waiting for a quick match, in queue_update
+	RoundWinTarget   int         `json:"roundWinTarget,omitempty"` // Challenge option, see GameSettings
+	MaxSteps         int         `json:"maxSteps,omitempty"`       // Challenge option, see GameSettings; track length in board_state
+	InitialBudget    int         `json:"initialBudget,omitempty"`  // Challenge option, see GameSettings
+	BestOf           int         `json:"bestOf,omitempty"`         // Challenge option, see GameSettings
+	GameMode         string      `json:"gameMode,omitempty"`       // Challenge option, see GameSettings
+	HideBalance      bool        `json:"hideBalance,omitempty"`    // Challenge option, see GameSettings
+	Series           *SeriesScore `json:"series,omitempty"`        // Series score in game_start, series_update and series_end
+	Token            string      `json:"token,omitempty"`     // Signed session token in welcome
+	LadderRung       int         `json:"ladderRung,omitempty"`    // Practice ladder rung from 1 in welcome and stats_update, 0 when not logged in
+	ActiveGameID     string      `json:"activeGameId,omitempty"` // Live game to return to, in welcome after a reconnect
+	ProtocolVersion  int         `json:"protocolVersion,omitempty"` // Server's protocol version in welcome
+	GameConfig       *GameConfig `json:"gameConfig,omitempty"` // Rules in effect, sent in game_start
+	Color            string      `json:"color,omitempty"`         // Your color in game_start
+	OpponentColor    string      `json:"opponentColor,omitempty"` // Opponent's color in game_start
+	ResultHash       string      `json:"resultHash,omitempty"`    // Hash chain over the rounds, in game_end
+	GhostPlayer      int         `json:"ghostPlayer,omitempty"`   // Recorded player to replay in play_ghost
+	Lobby            string      `json:"lobby,omitempty"`         // Lobby name in join_lobby and lobby_joined
+	BidRule          *BidRule    `json:"bidRule,omitempty"`       // Assistant rule in set_bid_rule, nil to clear
+	BidReady         int         `json:"bidReady,omitempty"`      // Player who has bid, in bid_committed; never the amount
+	PendingBids      []int       `json:"pendingBids,omitempty"`   // Players whose bid is still awaited, in board_state
+	History          []RoundHistory `json:"history,omitempty"`    // Every round played, in game_end
+	Elapsed          int         `json:"elapsed,omitempty"`       // Seconds the opponent has been deciding; idle limit in kicked_idle and quick_match_timeout
+	Rating           int         `json:"rating,omitempty"`        // Your Elo rating in welcome, 0 when not logged in
+	SessionStats     *SessionStats `json:"sessionStats,omitempty"` // Your results this session, in welcome and stats_update
+	Text             string      `json:"text,omitempty"`          // Chat text in chat and chat_message
+	Emote            string      `json:"emote,omitempty"`         // Canned reaction in emote and emote_received, see emote.go
+	Timestamp        int64       `json:"timestamp,omitempty"`     // Server time of a chat_message, Unix milliseconds
+	SecondsLeft      int         `json:"secondsLeft,omitempty"`   // Seconds until missing bids count as 0, in waiting_for_bids
+	TimedOut         int         `json:"timedOut,omitempty"`      // Players who bid 0 by timeout, in round_result
+	Order            string      `json:"order,omitempty"`         // Leaderboard ordering: "rating", "wins" or "winrate"
+	Limit            int         `json:"limit,omitempty"`         // Leaderboard length
+	Leaderboard      []LeaderboardEntry `json:"leaderboard,omitempty"` // Ranked players in leaderboard
+	RoomCode         string      `json:"roomCode,omitempty"`      // Private room join code
+	Private          bool        `json:"private,omitempty"`       // Hide a room's players from user lists, in create_room
 }
 
 type UserInfo struct {
-	UserID    string `json:"userId"`
-	Username  string `json:"username"`
-	InGame    bool   `json:"inGame"`
+	UserID     string `json:"userId"`
+	Username   string `json:"username"`
+	InGame     bool   `json:"inGame"`
+	Available  bool   `json:"available"`            // Below the concurrent-game limit, so open to challenges
+	Server     string `json:"server,omitempty"`     // Home server of a federated user
+	Lobby      string `json:"lobby,omitempty"`
+	Color      string `json:"color,omitempty"`      // Server-assigned display color
+	AvatarSeed string `json:"avatarSeed,omitempty"` // Stable seed for generated avatars
+	Rating     int    `json:"rating,omitempty"`     // Elo rating, 0 when not logged in
 }
 
 // User represents a connected client
@@ -50,16 +131,168 @@ type User struct {
 	ID      string
 	Username string
 	Client   *Client
-	InGame   bool
-	GameID   string // ID of game user is in
+	InGame   bool            // True while the user is in at least one game
+	GameIDs  map[string]bool // IDs of the games the user is in
+	Locale   string          // Catalog locale for server-generated text
+	AutoFold bool            // Bid 0 automatically once the race is lost
+	AutoSpectateOnEnd bool   // Watch the most-watched live game when a game ends
+	BidRule  *BidRule        // Bid assistant rule, nil when off
+	LastActive time.Time     // Time of the last inbound message
+	AbsentSince time.Time    // When the connection dropped mid-game; zero while connected
+	QueuedSince time.Time    // When the user joined the quick-match queue
+	Peer     string          // Home server ID for federated (proxy) users, "" for local users
+	IsBot    bool            // Server-side practice opponent, never connected or listed
+	BotRung  int             // Practice ladder rung a bot plays on, from 1; 0 for the plain bot
+	Profile  *Profile        // Persistent identity and rating, nil when not logged in
+	Session  *SessionStats   // Results this session, shared by connections of one profile
+	Lobby    string          // Lobby the user is in; scopes users_update and open challenges
+}
+
+// joinGame records that the user is playing in the game
+func (u *User) joinGame(gameID string) {
+	if u.GameIDs == nil {
+		u.GameIDs = make(map[string]bool)
+	}
+	u.GameIDs[gameID] = true
+	u.InGame = true
+}
+
+// leaveGame records that the user is no longer playing in the game
+func (u *User) leaveGame(gameID string) {
+	delete(u.GameIDs, gameID)
+	u.InGame = len(u.GameIDs) > 0
 }
 
 // Challenge represents a game challenge between two users
 type Challenge struct {
 	ID        string
 	FromUser  *User
-	ToUser    *User
+	ToUser    *User // nil for an open challenge until someone accepts it
+	Lobby     string // Lobby an open challenge is offered in
 	Timestamp time.Time
+	Note      string
+	Settings  GameSettings
+	Expiry    time.Duration // Chosen by the sender; 0 uses Config.ChallengeExpiry
+}
+
+// Room is a private room waiting for someone to join with its code
+type Room struct {
+	Code     string
+	Creator  *User
+	Created  time.Time
+	Settings GameSettings
+}
+
+// pendingRematch tracks who asked for a rematch of a finished game, and when
+type pendingRematch struct {
+	game      *Game
+	requested map[string]time.Time // by user ID
+}
+
+// Game modes: how bids are paid (see gameengine.Payments)
+const (
+	GameModeAllPay      = gameengine.AllPay
+	GameModeSecondPrice = gameengine.SecondPrice
+	GameModeFirstPrice  = gameengine.FirstPrice
+)
+
+// validGameMode reports whether mode is a known game mode
+func validGameMode(mode string) bool {
+	return mode == GameModeAllPay || mode == GameModeSecondPrice || mode == GameModeFirstPrice
+}
+
+// GameSettings are the per-game rule options chosen with a challenge
+type GameSettings struct {
+	// Win by being first to this many round wins instead of racing to
+	// MAX_STEPS. 0 keeps the default position race.
+	RoundWinTarget int `json:"roundWinTarget,omitempty"`
+
+	// Position to reach to win and each player's starting balance. 0 keeps
+	// MAX_STEPS and INITIAL_BUDGET.
+	MaxSteps      int `json:"maxSteps,omitempty"`
+	InitialBudget int `json:"initialBudget,omitempty"`
+
+	// Auction rule for paying bids: GameModeAllPay (the default when
+	// empty), GameModeSecondPrice or GameModeFirstPrice. See
+	// gameengine.Payments.
+	GameMode string `json:"gameMode,omitempty"`
+
+	// Fog of war: each player is only told their own balance. Spectators
+	// still see both.
+	HideBalance bool `json:"hideBalance,omitempty"`
+
+	// Play a best-of-N series (up to MAX_BEST_OF, odd unless the server
+	// plays sudden-death deciders) instead of a single game. 0 or 1 plays
+	// one game.
+	BestOf int `json:"bestOf,omitempty"`
+
+	// Started from a private room that asked to keep its players out of
+	// user lists and the live games listing
+	Private bool `json:"private,omitempty"`
+}
+
+// GameConfig enumerates every rule and parameter in effect for a game so
+// clients can render any variant without assuming defaults
+type GameConfig struct {
+	MaxSteps         int    `json:"maxSteps"`
+	InitialBudget    int    `json:"initialBudget"`
+	RoundWinTarget   int    `json:"roundWinTarget"` // 0 = position race
+	EventCards       bool   `json:"eventCards"`
+	RevealAckSeconds int    `json:"revealAckSeconds"` // 0 = next round opens immediately
+	BidTimeoutSeconds int   `json:"bidTimeoutSeconds"` // 0 = no bid timer
+	MinTotalBid      int    `json:"minTotalBid"`      // 0 = no anti-sandbagging rule
+	MinTotalBidRound int    `json:"minTotalBidRound"`
+	GraceBid         bool   `json:"graceBid"`
+	MaxRounds        int    `json:"maxRounds"` // 0 = no round cap
+	BestOf           int    `json:"bestOf"`    // 0 = single game
+	GameMode         string `json:"gameMode"`  // One of the GameMode constants
+	HideBalance      bool   `json:"hideBalance"`
+	TieBreaks        []string `json:"tieBreaks"` // Bankruptcy tie-breakers in order, see gameengine.BreakTie
+}
+
+// validate returns an error code if any setting is out of range. Even
+// series lengths are only allowed with a sudden-death decider to break ties.
+func (s GameSettings) validate(suddenDeath bool) string {
+	if s.RoundWinTarget < 0 || s.RoundWinTarget > MAX_ROUND_WIN_TARGET {
+		return ErrInvalidRoundWinTarget
+	}
+	if s.MaxSteps != 0 && (s.MaxSteps < MIN_GAME_STEPS || s.MaxSteps > MAX_GAME_STEPS) {
+		return ErrInvalidMaxSteps
+	}
+	if s.InitialBudget != 0 && (s.InitialBudget < MIN_INITIAL_BUDGET || s.InitialBudget > MAX_INITIAL_BUDGET) {
+		return ErrInvalidInitialBudget
+	}
+	if s.GameMode != "" && !validGameMode(s.GameMode) {
+		return ErrInvalidGameMode
+	}
+	if s.BestOf < 0 || s.BestOf > MAX_BEST_OF || (s.BestOf > 1 && s.BestOf%2 == 0 && !suddenDeath) {
+		return ErrInvalidBestOf
+	}
+	return ""
+}
+
+// gameMode returns the auction rule the game is played with
+func (g *Game) gameMode() string {
+	if g.Settings.GameMode != "" {
+		return g.Settings.GameMode
+	}
+	return GameModeAllPay
+}
+
+// maxSteps returns the position the game is raced to
+func (g *Game) maxSteps() int {
+	if g.Settings.MaxSteps > 0 {
+		return g.Settings.MaxSteps
+	}
+	return MAX_STEPS
+}
+
+// initialBudget returns the balance each player starts the game with
+func (g *Game) initialBudget() int {
+	if g.Settings.InitialBudget > 0 {
+		return g.Settings.InitialBudget
+	}
+	return INITIAL_BUDGET
 }
 
 // Game represents an active game session
@@ -69,31 +302,81 @@ type Game struct {
 	Player2     *User
 	Turn        int
 	CurrentRound int
-	Status      string // "WAITING_FOR_BIDS", "RESOLVING", "GAME_OVER"
+	Status      string // "WAITING_FOR_BIDS", "RESOLVING", "REVEALING", "PAUSED", "GAME_OVER"
 	Player1Pos  int
 	Player2Pos  int
 	Player1Balance int
 	Player2Balance int
+	Player1Spent int // Cumulative amount paid for bids over the game
+	Player2Spent int
+	Player1TotalBid int // Cumulative amount bid, paid or not (see Config.MinTotalBid)
+	Player2TotalBid int
+	Player1RoundWins int // Rounds won outright (draws don't count)
+	Player2RoundWins int
+	Player1GraceUsed bool // Grace bid already granted (Config.GraceBid)
+	Player2GraceUsed bool
 	Player1Bid  *int
 	Player2Bid  *int
+	RoundStart  time.Time // When the current round opened for bids
+	BidDeadline time.Time // When missing bids count as 0, zero without Config.BidTimeout
+	TimedOut    int       // Players whose bid timed out this round: 1, 2 or 3 for both
+	LastThinkingPulse time.Time
+	FirstBidder int // Player whose bid for the current round arrived first, 0 if none yet
+	SpectatorCount int // Clients watching, kept in step with Hub.spectators
+	EmoteRound  int    // Round EmotesSent counts for
+	EmotesSent  [2]int // Emotes each player sent in EmoteRound
 	GameOver    bool
 	Winner      int // 0 = none, 1 = player1, 2 = player2, 3 = draw
+	Reason      string // Reason code, see i18n.go
+	DominanceScore int // How decisively the game was won, 0-100
+	ResultHash  string // Hash chain over History, see resultHash
 	History     []RoundHistory
+	Settings    GameSettings
+	MaxRounds   int // Round cap, 0 for none (Config.MaxRounds when the game started)
+	Ghost       *Ghost // Recorded opponent playing as player 2, nil in live games
+	Player1Color string // Display colors, distinct within the game
+	Player2Color string
+	// Event cards variant: the deck is drawn from an RNG seeded per game
+	Seed        int64
+	EventCards  bool
+	Event       string // Event card for the current round
+	eventRNG    *rand.Rand
+	// Pause by mutual consent (Status "PAUSED")
+	PauseRequestedBy int    // Player asking for a pause, 0 if none pending
+	DrawOfferedBy    int    // Player offering a draw this round, 0 if none pending
+	DrawOfferRounds  [2]int // Round each player last offered a draw in, 0 if never
+	Series           *Series // Best-of-N series the game belongs to, nil for a single game
+	PausedFrom       string // Status to restore on resume
+	PausedAt         time.Time
+	// Reveal acknowledgment (Status "REVEALING")
+	RevealDeadline  time.Time
+	Player1Revealed bool
+	Player2Revealed bool
 	StartTime   time.Time
 	EndTime     time.Time
 }
 
 type RoundHistory struct {
-	Turn        int
-	P1Bid       int
-	P2Bid       int
-	P1NewPos    int
-	P2NewPos    int
-	Result      string
+	Turn        int    `json:"turn"`
+	P1Bid       int    `json:"p1Bid"`
+	P2Bid       int    `json:"p2Bid"`
+	P1NewPos    int    `json:"p1NewPos"`
+	P2NewPos    int    `json:"p2NewPos"`
+	Result      string `json:"result"`
+	FirstBidder int    `json:"firstBidder"` // Player whose bid the server received first
+	TimedOut    int    `json:"timedOut,omitempty"` // Players who bid 0 by timeout: 1, 2 or 3 for both
 }
 
 // MessageWrapper wraps a message with its client
 type MessageWrapper struct {
 	client  *Client
 	message *Message
+
+	// rateLimited reports that the client started sending faster than
+	// Config.MessageRate; message is nil
+	rateLimited bool
+
+	// badMessage reports that the client sent something that isn't a
+	// message: invalid JSON or no type. message is nil.
+	badMessage bool
 }
@@ -1,5 +1,8 @@
 // Multiplayer WebSocket client for Quo Vadis
 
+// Protocol version this client speaks; must match the server's ProtocolVersion range
+const PROTOCOL_VERSION = 1;
+
 class MultiplayerClient {
     constructor() {
         this.ws = null;
@@ -12,11 +15,12 @@ class MultiplayerClient {
         this.onlineUsers = [];
         this.pendingChallenges = new Map();
         this.connected = false;
+        this.versionMismatch = false;
     }
 
     connect() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        const wsUrl = `${protocol}//${window.location.host}/ws?protocol=${PROTOCOL_VERSION}`;
 
         this.ws = new WebSocket(wsUrl);
 
@@ -39,6 +43,10 @@ class MultiplayerClient {
             console.log('Disconnected from multiplayer server');
             this.connected = false;
             this.updateConnectionStatus(false);
+            // Reconnecting can't help an outdated client; it needs a reload
+            if (this.versionMismatch) {
+                return;
+            }
             // Attempt to reconnect after 3 seconds
             setTimeout(() => this.connect(), 3000);
         };
@@ -79,6 +87,9 @@ class MultiplayerClient {
             case 'challenge_expired':
                 this.handleChallengeExpired(msg);
                 break;
+            case 'challenge_cancelled':
+                this.handleChallengeCancelled(msg);
+                break;
             case 'game_start':
                 this.handleGameStart(msg);
                 break;
@@ -141,6 +152,17 @@ class MultiplayerClient {
         this.pendingChallenges.delete(msg.challengeId);
     }
 
+    handleChallengeCancelled(msg) {
+        // The challenger withdrew the challenge; clear its prompt
+        const notification = document.querySelector(`.challenge-notification[data-challenge-id="${msg.challengeId}"]`);
+        if (notification) {
+            notification.remove();
+            showNotification('A challenge was withdrawn', 'info');
+        }
+
+        this.pendingChallenges.delete(msg.challengeId);
+    }
+
     handleGameStart(msg) {
         this.gameId = msg.gameId;
         this.yourPlayer = msg.yourPlayer;
@@ -264,7 +286,11 @@ class MultiplayerClient {
     }
 
     handleError(msg) {
-        showNotification(msg.username || 'An error occurred', 'error');
+        if (msg.errorCode === 'VERSION_MISMATCH') {
+            this.versionMismatch = true;
+        }
+        // Servers before the error field sent the text in username
+        showNotification(msg.error || msg.username || 'An error occurred', 'error');
     }
 
     // Challenge methods
@@ -364,7 +390,7 @@ class MultiplayerClient {
         usersList.innerHTML = this.onlineUsers.map(user => `
             <div class="user-item ${user.inGame ? 'in-game' : ''}" data-user-id="${user.userId}">
                 <span class="user-name">${user.username}</span>
-                ${!user.inGame ? `<button class="challenge-btn" onclick="mpClient.challengeUser('${user.userId}')">Challenge</button>` : '<span style="color: #888; font-size: 12px;">In Game</span>'}
+                ${user.available ? `<button class="challenge-btn" onclick="mpClient.challengeUser('${user.userId}')">Challenge</button>` : '<span style="color: #888; font-size: 12px;">In Game</span>'}
             </div>
         `).join('');
     }